@@ -0,0 +1,137 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/admissions/pods"
+	corev1 "k8s.io/api/core/v1"
+)
+
+// verdict is a verdict of the webhook on a container of a workload
+type verdict struct {
+	Kind      string `json:"kind"`
+	Namespace string `json:"namespace"`
+	Name      string `json:"name"`
+	Container string `json:"container"`
+	Image     string `json:"image"`
+
+	// Allowed is true if the webhook admits the image
+	Allowed bool `json:"allowed"`
+	// Reason is why the image is not allowed
+	Reason string `json:"reason,omitempty"`
+	// Error is the error which occurred while validating the image, which the webhook rejects the pod by
+	Error string `json:"error,omitempty"`
+	// PinnedImage is the image pinned by its digest, which the webhook patches the pod with
+	PinnedImage string `json:"pinnedImage,omitempty"`
+	// KeyID is the ID of the cosign key which verified the image
+	KeyID string `json:"keyID,omitempty"`
+}
+
+// checkWorkloads validates the pod of each workload once, as the webhook does, and gives each container its own verdict from the
+// decisions on the images
+func checkWorkloads(validator pods.Validator, workloads []workload) []verdict {
+	var verdicts []verdict
+	for _, w := range workloads {
+		pod := w.pod.DeepCopy()
+		result, err := validator.CheckIsValidAndAddDigest(pod)
+		pinned := containersOf(pod)
+
+		for i, c := range containersOf(w.pod) {
+			v := verdict{Kind: w.Kind, Namespace: w.Namespace, Name: w.Name, Container: c.name, Image: c.image}
+			switch {
+			case err != nil:
+				v.Error = err.Error()
+			case result.NamespaceWhitelisted:
+				v.Allowed = true
+			default:
+				decision, exist := result.Decisions[c.image]
+				if !exist {
+					v.Error = fmt.Sprintf("image %s is not validated", c.image)
+					break
+				}
+				if !decision.Valid {
+					v.Reason = decision.Reason
+					break
+				}
+				v.Allowed = true
+				if pinned[i].image != c.image {
+					v.PinnedImage = pinned[i].image
+				}
+				if decision.Cosign != nil {
+					v.KeyID = decision.Cosign.KeyID
+				}
+			}
+			verdicts = append(verdicts, v)
+		}
+	}
+	return verdicts
+}
+
+// containerImage is a container of a pod with its image
+type containerImage struct {
+	name  string
+	image string
+}
+
+// containersOf returns the init containers, the containers and the ephemeral containers of the pod, in order
+func containersOf(pod *corev1.Pod) []containerImage {
+	var containers []containerImage
+	for _, c := range pod.Spec.InitContainers {
+		containers = append(containers, containerImage{name: c.Name, image: c.Image})
+	}
+	for _, c := range pod.Spec.Containers {
+		containers = append(containers, containerImage{name: c.Name, image: c.Image})
+	}
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, containerImage{name: c.Name, image: c.Image})
+	}
+	return containers
+}
+
+// allAllowed checks if all the containers are allowed
+func allAllowed(verdicts []verdict) bool {
+	for _, v := range verdicts {
+		if !v.Allowed {
+			return false
+		}
+	}
+	return true
+}
+
+// printVerdicts prints the verdicts in the format, which is either text or json
+func printVerdicts(w io.Writer, verdicts []verdict, format string) error {
+	switch format {
+	case outputJSON:
+		encoder := json.NewEncoder(w)
+		encoder.SetIndent("", "  ")
+		encoder.SetEscapeHTML(false)
+		if verdicts == nil {
+			verdicts = []verdict{}
+		}
+		return encoder.Encode(verdicts)
+	case outputText:
+		tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		if _, err := fmt.Fprintln(tw, "WORKLOAD\tCONTAINER\tIMAGE\tVERDICT\tDETAIL"); err != nil {
+			return err
+		}
+		for _, v := range verdicts {
+			result, detail := "ALLOWED", v.PinnedImage
+			if v.Error != "" {
+				result, detail = "ERROR", v.Error
+			} else if !v.Allowed {
+				result, detail = "DENIED", v.Reason
+			}
+			detail = strings.ReplaceAll(detail, "\n", "; ")
+			if _, err := fmt.Fprintf(tw, "%s/%s/%s\t%s\t%s\t%s\t%s\n", v.Kind, v.Namespace, v.Name, v.Container, v.Image, result, detail); err != nil {
+				return err
+			}
+		}
+		return tw.Flush()
+	default:
+		return fmt.Errorf("output format %s is not supported", format)
+	}
+}
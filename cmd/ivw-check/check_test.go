@@ -0,0 +1,89 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/admissions/pods"
+)
+
+const testPinnedApp = "registry.io/app:v1@sha256:1111111111111111111111111111111111111111111111111111111111111111"
+
+type checkWorkloadsTestCase struct {
+	expectedAllowed     bool
+	expectedPinnedImage string
+	expectedReason      string
+	expectedErrOccur    bool
+}
+
+func TestCheckWorkloads(t *testing.T) {
+	policyObjs, err := readObjects([]string{"testdata/policies.yaml"})
+	require.NoError(t, err)
+	verifier, err := newStubVerifier("testdata/signatures.yaml")
+	require.NoError(t, err)
+	validator, err := pods.NewOfflineValidator(policyObjs, "default", verifier)
+	require.NoError(t, err)
+
+	objs, err := readObjects([]string{"testdata/workloads.yaml"})
+	require.NoError(t, err)
+	verdicts := checkWorkloads(validator, workloadsOf(objs, "default"))
+
+	tc := map[string]checkWorkloadsTestCase{
+		"Deployment/default/app/init": {
+			expectedAllowed: true,
+		},
+		"Deployment/default/app/app": {
+			expectedAllowed:     true,
+			expectedPinnedImage: testPinnedApp,
+		},
+		"Deployment/default/app/sidecar": {
+			expectedAllowed: false,
+			expectedReason:  "Cosign: Image 'registry.io/sidecar:v1' is not signed, as the registry security policy has no cosign keys",
+		},
+		"Deployment/default/app/open": {
+			expectedAllowed: true,
+		},
+		"Deployment/default/app/hub": {
+			expectedAllowed: false,
+			expectedReason:  "Image 'alpine:3' does not meet registry security policy",
+		},
+		"Deployment/default/app/tool": {
+			expectedAllowed:     true,
+			expectedPinnedImage: "cosign.io/tool:v1@sha256:2222222222222222222222222222222222222222222222222222222222222222",
+		},
+		"Pod/test/pod/app": {
+			expectedAllowed: true,
+		},
+		"CronJob/default/job/job": {
+			expectedAllowed: false,
+		},
+	}
+	require.Len(t, verdicts, len(tc))
+
+	for _, v := range verdicts {
+		name := v.Kind + "/" + v.Namespace + "/" + v.Name + "/" + v.Container
+		c, ok := tc[name]
+		require.True(t, ok, name)
+		t.Run(name, func(t *testing.T) {
+			if c.expectedErrOccur {
+				require.NotEmpty(t, v.Error)
+				require.False(t, v.Allowed)
+				return
+			}
+			require.Empty(t, v.Error)
+			require.Equal(t, c.expectedAllowed, v.Allowed)
+			require.Equal(t, c.expectedAllowed, v.Reason == "", "reason")
+			require.Equal(t, c.expectedPinnedImage, v.PinnedImage)
+			if c.expectedReason != "" {
+				require.Contains(t, v.Reason, c.expectedReason, "reason")
+			}
+		})
+	}
+	require.False(t, allAllowed(verdicts))
+
+	buf := &bytes.Buffer{}
+	require.NoError(t, printVerdicts(buf, verdicts, outputText))
+	require.Contains(t, buf.String(), "Deployment/default/app  app        registry.io/app:v1")
+	require.Contains(t, buf.String(), "ALLOWED  "+testPinnedApp)
+}
@@ -0,0 +1,169 @@
+// ivw-check checks offline whether the webhook admits the pods and the workloads of the manifests.
+// Policies, the whitelist, the mirrors and the keys are read either from the files or from the cluster, and the signatures are
+// verified either with the registries or with the stub signatures.
+//
+//	ivw-check --policies policies.yaml --verifier stub --signatures signatures.yaml deployment.yaml
+//
+// It exits with 0 if all the containers are allowed, 1 if any of them is not, and 2 if the check itself fails
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+	"k8s.io/client-go/kubernetes"
+	"sigs.k8s.io/controller-runtime/pkg/client/config"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/log/zap"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/admissions/pods"
+)
+
+const (
+	outputText = "text"
+	outputJSON = "json"
+
+	verifierRegistry = "registry"
+	verifierStub     = "stub"
+)
+
+const (
+	exitAllowed = 0
+	exitDenied  = 1
+	exitError   = 2
+)
+
+// fileList is a flag of the files, which may be repeated or separated by commas
+type fileList []string
+
+func (f *fileList) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *fileList) Set(value string) error {
+	*f = append(*f, strings.Split(value, ",")...)
+	return nil
+}
+
+func main() {
+	var policyFiles fileList
+	flag.Var(&policyFiles, "policies", "Files of the policies, the cosign key sets, the whitelist, the mirrors, the pull secrets and the keys. They may be repeated or separated by commas")
+	fromCluster := flag.Bool("cluster", false, "Read the policies, the whitelist, the mirrors, the pull secrets and the keys from the cluster of the kubeconfig, instead of the files")
+	namespace := flag.String("namespace", "default", "Namespace of the workloads, which do not have one")
+	output := flag.String("output", outputText, "Output format, either text or json")
+	verifierName := flag.String("verifier", verifierRegistry, "Verifier of the signatures, either registry (the registries and the notary servers) or stub (the stub signatures)")
+	signaturesFile := flag.String("signatures", "", "File of the stub signatures for the stub verifier")
+	verbose := flag.Bool("verbose", false, "Print the logs of the validation")
+	flag.Usage = func() {
+		fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] MANIFEST...\n\nMANIFEST is a YAML or JSON file of the pods and the workloads, or - for the standard input\n\n", os.Args[0])
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+
+	if *verbose {
+		logf.SetLogger(zap.New(zap.WriteTo(os.Stderr)))
+	} else {
+		logf.SetLogger(zap.New(zap.WriteTo(ioutil.Discard)))
+		logrus.SetOutput(ioutil.Discard)
+	}
+
+	allowed, err := run(options{
+		manifests:      flag.Args(),
+		policyFiles:    policyFiles,
+		fromCluster:    *fromCluster,
+		namespace:      *namespace,
+		output:         *output,
+		verifier:       *verifierName,
+		signaturesFile: *signaturesFile,
+	})
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "Error: %s\n", err)
+		os.Exit(exitError)
+	}
+	if !allowed {
+		os.Exit(exitDenied)
+	}
+	os.Exit(exitAllowed)
+}
+
+// options are the options of the check
+type options struct {
+	manifests      []string
+	policyFiles    []string
+	fromCluster    bool
+	namespace      string
+	output         string
+	verifier       string
+	signaturesFile string
+}
+
+// run checks the manifests and prints the verdicts. It returns true if all the containers are allowed
+func run(opts options) (bool, error) {
+	if len(opts.manifests) == 0 {
+		return false, fmt.Errorf("there is no manifest to check")
+	}
+	if opts.fromCluster && len(opts.policyFiles) > 0 {
+		return false, fmt.Errorf("policies should be read either from the files or from the cluster")
+	}
+	if opts.output != outputText && opts.output != outputJSON {
+		return false, fmt.Errorf("output format %s is not supported", opts.output)
+	}
+
+	var verifier pods.Verifier
+	switch opts.verifier {
+	case verifierRegistry:
+		if opts.signaturesFile != "" {
+			return false, fmt.Errorf("signatures are only for the stub verifier")
+		}
+	case verifierStub:
+		stub, err := newStubVerifier(opts.signaturesFile)
+		if err != nil {
+			return false, err
+		}
+		verifier = stub
+	default:
+		return false, fmt.Errorf("verifier %s is not supported", opts.verifier)
+	}
+
+	objs, err := readObjects(opts.manifests)
+	if err != nil {
+		return false, err
+	}
+	workloads := workloadsOf(objs, opts.namespace)
+
+	validator, err := newValidator(opts, verifier)
+	if err != nil {
+		return false, err
+	}
+
+	verdicts := checkWorkloads(validator, workloads)
+	if err := printVerdicts(os.Stdout, verdicts, opts.output); err != nil {
+		return false, err
+	}
+	return allAllowed(verdicts), nil
+}
+
+// newValidator creates the validator of the cluster or of the policy files
+func newValidator(opts options, verifier pods.Verifier) (pods.Validator, error) {
+	if opts.fromCluster {
+		cfg, err := config.GetConfig()
+		if err != nil {
+			return nil, err
+		}
+		clientSet, err := kubernetes.NewForConfig(cfg)
+		if err != nil {
+			return nil, err
+		}
+		return pods.NewValidator(cfg, clientSet, verifier)
+	}
+
+	policyObjs, err := readObjects(opts.policyFiles)
+	if err != nil {
+		return nil, err
+	}
+	return pods.NewOfflineValidator(policyObjs, opts.namespace, verifier)
+}
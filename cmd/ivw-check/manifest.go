@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+// workload is a pod or a workload of the manifests, whose pod template is checked
+type workload struct {
+	// Kind, Namespace and Name identify the object in the report
+	Kind      string
+	Namespace string
+	Name      string
+
+	pod *corev1.Pod
+}
+
+// readObjects reads the objects of the YAML or JSON manifests. A manifest may have several documents. "-" is the standard input
+func readObjects(files []string) ([]runtime.Object, error) {
+	var objs []runtime.Object
+	for _, file := range files {
+		var b []byte
+		var err error
+		if file == "-" {
+			b, err = ioutil.ReadAll(os.Stdin)
+		} else {
+			b, err = ioutil.ReadFile(file)
+		}
+		if err != nil {
+			return nil, err
+		}
+		fileObjs, err := decodeObjects(b)
+		if err != nil {
+			return nil, fmt.Errorf("couldn't decode %s by %s", file, err)
+		}
+		objs = append(objs, fileObjs...)
+	}
+	return objs, nil
+}
+
+// decodeObjects decodes the documents of the manifest. Items of the lists are decoded as well
+func decodeObjects(b []byte) ([]runtime.Object, error) {
+	var objs []runtime.Object
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(b), 4096)
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if errors.Is(err, io.EOF) {
+				return objs, nil
+			}
+			return nil, err
+		}
+		raw.Raw = bytes.TrimSpace(raw.Raw)
+		if len(raw.Raw) == 0 || bytes.Equal(raw.Raw, []byte("null")) {
+			continue
+		}
+
+		obj, _, err := scheme.Codecs.UniversalDeserializer().Decode(raw.Raw, nil, nil)
+		if err != nil {
+			return nil, err
+		}
+		if list, ok := obj.(*corev1.List); ok {
+			for _, item := range list.Items {
+				itemObjs, err := decodeObjects(item.Raw)
+				if err != nil {
+					return nil, err
+				}
+				objs = append(objs, itemObjs...)
+			}
+			continue
+		}
+		objs = append(objs, obj)
+	}
+}
+
+// workloadsOf returns the pods and the workloads of the objects. Objects without namespaces are in the default namespace
+func workloadsOf(objs []runtime.Object, defaultNamespace string) []workload {
+	var workloads []workload
+	for _, obj := range objs {
+		var meta metav1.ObjectMeta
+		var template *corev1.PodTemplateSpec
+		switch o := obj.(type) {
+		case *corev1.Pod:
+			meta, template = o.ObjectMeta, &corev1.PodTemplateSpec{ObjectMeta: o.ObjectMeta, Spec: o.Spec}
+		case *corev1.PodTemplate:
+			meta, template = o.ObjectMeta, &o.Template
+		case *corev1.ReplicationController:
+			meta, template = o.ObjectMeta, o.Spec.Template
+		case *appsv1.Deployment:
+			meta, template = o.ObjectMeta, &o.Spec.Template
+		case *appsv1.StatefulSet:
+			meta, template = o.ObjectMeta, &o.Spec.Template
+		case *appsv1.DaemonSet:
+			meta, template = o.ObjectMeta, &o.Spec.Template
+		case *appsv1.ReplicaSet:
+			meta, template = o.ObjectMeta, &o.Spec.Template
+		case *batchv1.Job:
+			meta, template = o.ObjectMeta, &o.Spec.Template
+		case *batchv1.CronJob:
+			meta, template = o.ObjectMeta, &o.Spec.JobTemplate.Spec.Template
+		case *batchv1beta1.CronJob:
+			meta, template = o.ObjectMeta, &o.Spec.JobTemplate.Spec.Template
+		default:
+			continue
+		}
+		if template == nil {
+			continue
+		}
+
+		namespace := meta.Namespace
+		if namespace == "" {
+			namespace = defaultNamespace
+		}
+		pod := &corev1.Pod{ObjectMeta: *template.ObjectMeta.DeepCopy(), Spec: *template.Spec.DeepCopy()}
+		pod.Name = meta.Name
+		pod.Namespace = namespace
+
+		workloads = append(workloads, workload{
+			Kind:      obj.GetObjectKind().GroupVersionKind().Kind,
+			Namespace: namespace,
+			Name:      meta.Name,
+			pod:       pod,
+		})
+	}
+	return workloads
+}
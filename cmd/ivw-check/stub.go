@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sigstore/cosign/pkg/oci"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+	"github.com/sigstore/cosign/pkg/oci/static"
+	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"k8s.io/apimachinery/pkg/util/yaml"
+)
+
+// stubSignatures are the signatures the stub verifier reports, instead of the ones of the registries and the notary servers, e.g.,
+//
+//	notary:
+//	  registry.io/app:
+//	    - SignedTag: v1
+//	      Digest: sha256:...
+//	      Signers: ["alice"]
+//	digests:
+//	  registry.io/tool:v1: sha256:...
+//	cosign:
+//	  - registry.io/tool:v1
+//	attestations:
+//	  - registry.io/tool:v1
+type stubSignatures struct {
+	// Notary are the signed tags of the repositories
+	Notary map[string][]notary.SignedTag `json:"notary"`
+	// Digests are the digests of the tags, which the cosign signatures are verified against
+	Digests map[string]string `json:"digests"`
+	// Cosign are the images signed by the keys of their policies
+	Cosign []string `json:"cosign"`
+	// Attestations are the images having all the attestations their policies require
+	Attestations []string `json:"attestations"`
+}
+
+// stubVerifier verifies the images with the stub signatures, so that the manifests are checked without the registries.
+// Images which are not in the signatures are not signed. Cosign signatures and attestations are kept by the digests of the images
+type stubVerifier struct {
+	notary       map[string][]notary.SignedTag
+	digests      map[string]string
+	cosign       map[string]bool
+	attestations map[string]bool
+}
+
+// newStubVerifier creates a stub verifier of the signatures file. Nothing is signed if the file is empty
+func newStubVerifier(file string) (*stubVerifier, error) {
+	sigs := &stubSignatures{}
+	if file != "" {
+		b, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(b), 4096).Decode(sigs); err != nil {
+			return nil, fmt.Errorf("couldn't decode %s by %s", file, err)
+		}
+	}
+
+	v := &stubVerifier{notary: map[string][]notary.SignedTag{}, digests: map[string]string{}}
+	for repo, tags := range sigs.Notary {
+		r, err := name.NewRepository(repo)
+		if err != nil {
+			return nil, err
+		}
+		v.notary[r.Name()] = append(v.notary[r.Name()], tags...)
+	}
+	for image, digest := range sigs.Digests {
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return nil, err
+		}
+		v.digests[ref.Name()] = digest
+	}
+	var err error
+	if v.cosign, err = v.digestSet(sigs.Cosign); err != nil {
+		return nil, err
+	}
+	if v.attestations, err = v.digestSet(sigs.Attestations); err != nil {
+		return nil, err
+	}
+	return v, nil
+}
+
+// digestSet returns the set of the digest references of the images
+func (v *stubVerifier) digestSet(images []string) (map[string]bool, error) {
+	set := map[string]bool{}
+	for _, image := range images {
+		ref, err := name.ParseReference(image)
+		if err != nil {
+			return nil, err
+		}
+		digestRef, err := v.ResolveDigest(ref)
+		if err != nil {
+			return nil, err
+		}
+		set[digestRef.Name()] = true
+	}
+	return set, nil
+}
+
+// ResolveDigest resolves the tag of the image with the digests, or with the signed tags of notary
+func (v *stubVerifier) ResolveDigest(ref name.Reference, _ ...ociremote.Option) (name.Digest, error) {
+	if d, ok := ref.(name.Digest); ok {
+		return d, nil
+	}
+	if digest, ok := v.digests[ref.Name()]; ok {
+		return ref.Context().Digest(digest), nil
+	}
+	for _, tag := range v.notary[ref.Context().Name()] {
+		if tag.SignedTag == ref.Identifier() {
+			return ref.Context().Digest(tag.Digest), nil
+		}
+	}
+	return name.Digest{}, fmt.Errorf("digest of %s is unknown", ref.Name())
+}
+
+// FetchNotarySignature returns the signed tags of the repository of the image
+func (v *stubVerifier) FetchNotarySignature(image string, _ notary.FetchOptions) (*notary.Signature, error) {
+	ref, err := name.ParseReference(image)
+	if err != nil {
+		return nil, err
+	}
+	tags, ok := v.notary[ref.Context().Name()]
+	if !ok {
+		return nil, nil
+	}
+	return &notary.Signature{Name: ref.Context().Name(), SignedTags: tags}, nil
+}
+
+// VerifyCosignSignatures reports the image is signed by the first key of its policy, if it is in the signatures
+func (v *stubVerifier) VerifyCosignSignatures(_ context.Context, ref name.Reference, _ *signer.Policy, keys []cosigns.Key, _ *cosigns.TransparencyLog, _ ...ociremote.Option) ([]oci.Signature, *cosigns.Key, error) {
+	if len(keys) == 0 {
+		return nil, nil, fmt.Errorf("there are no keys for valid")
+	}
+	if !v.cosign[ref.Name()] {
+		return nil, nil, fmt.Errorf("no matching signatures")
+	}
+	sig, err := static.NewSignature([]byte("{}"), "")
+	if err != nil {
+		return nil, nil, err
+	}
+	return []oci.Signature{sig}, &keys[0], nil
+}
+
+// VerifyCosignAttestations reports the image has all the required attestations, if it is in the signatures
+func (v *stubVerifier) VerifyCosignAttestations(_ context.Context, ref name.Digest, _ []whv1.AttestationSpec, _ []cosigns.Key, _ *cosigns.TransparencyLog, _ ...ociremote.Option) error {
+	if !v.attestations[ref.Name()] {
+		return fmt.Errorf("no matching attestations")
+	}
+	return nil
+}
@@ -5,13 +5,17 @@ set -e
 kubectl apply -f deploy/certificate.yaml
 kubectl apply -f config/crd/tmax.io_clusterregistrysecuritypolicies.yaml
 kubectl apply -f config/crd/tmax.io_registrysecuritypolicies.yaml
+kubectl apply -f config/crd/tmax.io_cosignkeysets.yaml
 kubectl apply -f deploy/role/account.yaml
 kubectl apply -f deploy/role/role.yaml
 kubectl apply -f deploy/role/role-binding.yaml
 
 kubectl apply -f deploy/whitelist-configmap.yaml
+kubectl apply -f deploy/mirror-configmap.yaml
+kubectl apply -f deploy/pull-secret-configmap.yaml
 kubectl apply -f deploy/deployment.yaml
 kubectl apply -f deploy/service.yaml
+kubectl apply -f deploy/mutating-webhook.yaml
 kubectl apply -f deploy/validating-webhook.yaml
 
 echo "Deloying image-validation-webhook completed"
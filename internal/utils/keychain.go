@@ -0,0 +1,105 @@
+package utils
+
+import (
+	"encoding/base64"
+	"fmt"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	corev1 "k8s.io/api/core/v1"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var keychainLog = logf.Log.WithName("utils/keychain.go")
+
+const (
+	// DockerHubRegistry is the registry of docker hub, which images are referenced by
+	DockerHubRegistry = "docker.io"
+)
+
+// Keychain is a keychain of go-containerregistry, which resolves the credentials of a repository from the image pull secrets.
+// Credentials are matched with the repository by the keyring, as the kubelet does. The repository is accessed anonymously if there are none
+type Keychain struct {
+	keyring *Keyring
+}
+
+// NewKeychain creates a keychain of the pull secrets. Invalid pull secrets are skipped, as the kubelet does
+func NewKeychain(secrets []*corev1.Secret) *Keychain {
+	var pullSecrets []*ImagePullSecret
+	for _, secret := range secrets {
+		pullSecret, err := NewImagePullSecret(secret)
+		if err != nil {
+			keychainLog.Error(err, "Skipping invalid pull secret", "secret", secret.Namespace+"/"+secret.Name)
+			continue
+		}
+		pullSecrets = append(pullSecrets, pullSecret)
+	}
+	return &Keychain{keyring: NewKeyring(pullSecrets)}
+}
+
+// Resolve resolves the first credentials matching the repository of the target
+func (k *Keychain) Resolve(target authn.Resource) (authn.Authenticator, error) {
+	return authenticator(k.Lookup(target.String())[0]), nil
+}
+
+// Lookup returns the credentials matching the repository, in the order they should be tried until one of them works.
+// It is a single empty one, which is for the anonymous access, if there are none
+func (k *Keychain) Lookup(repository string) []authn.AuthConfig {
+	var creds []authn.AuthConfig
+	if k != nil && k.keyring != nil {
+		creds = k.keyring.Lookup(repository)
+	}
+	if len(creds) == 0 {
+		// DO NOT return error - the image may be public
+		return []authn.AuthConfig{{}}
+	}
+	return creds
+}
+
+// Keychains returns the keychains, each of which resolves one of the credentials matching the repository, in the order of Lookup
+func (k *Keychain) Keychains(repository string) []authn.Keychain {
+	var keychains []authn.Keychain
+	for _, cfg := range k.Lookup(repository) {
+		keychains = append(keychains, &staticKeychain{auth: authenticator(cfg)})
+	}
+	return keychains
+}
+
+// BasicAuth encodes the username and the password of the credentials as basic auth. It is empty if there is no username
+func BasicAuth(cfg authn.AuthConfig) string {
+	if cfg.Username == "" {
+		return ""
+	}
+	return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", cfg.Username, cfg.Password)))
+}
+
+// staticKeychain resolves the same credentials for any repository
+type staticKeychain struct {
+	auth authn.Authenticator
+}
+
+// Resolve returns the credentials of the keychain
+func (k *staticKeychain) Resolve(authn.Resource) (authn.Authenticator, error) {
+	return k.auth, nil
+}
+
+// authenticator returns the authenticator of the credentials, which is anonymous if they are empty
+func authenticator(cfg authn.AuthConfig) authn.Authenticator {
+	if cfg == (authn.AuthConfig{}) {
+		return authn.Anonymous
+	}
+	return authn.FromConfig(cfg)
+}
+
+// decodeBasicAuth decodes username:password string encrypted by base64
+func decodeBasicAuth(basicAuth string) (string, string, error) {
+	b, err := base64.StdEncoding.DecodeString(basicAuth)
+	if err != nil {
+		return "", "", err
+	}
+	tokens := strings.SplitN(string(b), ":", 2)
+	if len(tokens) != 2 {
+		return "", "", fmt.Errorf("basic auth is not in username:password form")
+	}
+	return tokens[0], tokens[1], nil
+}
@@ -0,0 +1,80 @@
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/stretchr/testify/require"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type keychainResolveTestCase struct {
+	repository string
+	auths      []map[string]DockerLoginCredential
+
+	expectedAuth *authn.AuthConfig
+}
+
+func TestKeychain_Resolve(t *testing.T) {
+	tc := map[string]keychainResolveTestCase{
+		"anonymous": {
+			repository:   "found-host.io/app",
+			auths:        []map[string]DockerLoginCredential{{"https://other-host": {"auth": base64.StdEncoding.EncodeToString([]byte("testID:testPW"))}}},
+			expectedAuth: &authn.AuthConfig{},
+		},
+		"firstSecret": {
+			repository: "found-host.io/app",
+			auths: []map[string]DockerLoginCredential{
+				{"https://other-host": {"auth": base64.StdEncoding.EncodeToString([]byte("otherID:otherPW"))}},
+				{"found-host.io": {"user": "testID", "password": "testPW"}},
+				{"https://found-host.io": {"auth": base64.StdEncoding.EncodeToString([]byte("lastID:lastPW"))}},
+			},
+			expectedAuth: &authn.AuthConfig{Username: "testID", Password: "testPW"},
+		},
+		"dockerHub": {
+			repository:   "index.docker.io/library/alpine",
+			auths:        []map[string]DockerLoginCredential{{"https://registry-1.docker.io": {"auth": base64.StdEncoding.EncodeToString([]byte("testID:test:PW"))}}},
+			expectedAuth: &authn.AuthConfig{Username: "testID", Password: "test:PW"},
+		},
+		"registryToken": {
+			repository:   "reg.found-host.io/app",
+			auths:        []map[string]DockerLoginCredential{{"*.found-host.io": {"registrytoken": "access-token"}}},
+			expectedAuth: &authn.AuthConfig{RegistryToken: "access-token"},
+		},
+	}
+
+	for name_, c := range tc {
+		t.Run(name_, func(t *testing.T) {
+			var pullSecrets []*ImagePullSecret
+			for _, auths := range c.auths {
+				pullSecrets = append(pullSecrets, &ImagePullSecret{json: &DockerConfigJSON{Auths: auths}})
+			}
+			keyring := NewKeyring(pullSecrets)
+
+			repo, err := name.NewRepository(c.repository)
+			require.NoError(t, err)
+			auth, err := (&Keychain{keyring: keyring}).Resolve(repo)
+			require.NoError(t, err)
+			cfg, err := auth.Authorization()
+			require.NoError(t, err)
+			require.Equal(t, c.expectedAuth, cfg, "auth config")
+		})
+	}
+}
+
+func TestNewKeychain(t *testing.T) {
+	secrets := []*corev1.Secret{
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "opaque"}, Type: corev1.SecretTypeOpaque},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "broken"}, Type: corev1.SecretTypeDockerConfigJson, Data: map[string][]byte{corev1.DockerConfigJsonKey: []byte("{")}},
+		{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "valid"}, Type: corev1.SecretTypeDockerConfigJson, Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths":{"found-host.io":{"username":"testID","password":"testPW"}}}`),
+		}},
+	}
+
+	// Invalid pull secrets are skipped
+	keychain := NewKeychain(secrets)
+	require.Equal(t, []authn.AuthConfig{{Username: "testID", Password: "testPW"}}, keychain.Lookup("found-host.io/app"))
+}
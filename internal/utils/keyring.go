@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"net/url"
+	"sort"
+	"strings"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var keyringLog = logf.Log.WithName("utils/keyring.go")
+
+// Keyring matches the credentials of the pull secrets with the images, in the same way as the kubelet does.
+// Keys of the auths may have a path (harbor.corp/team-a), wildcards (*.corp) and a port, and an image matches a key if
+// the hosts match and the path of the key is a prefix of the image's. Keys are tried in reverse lexicographic order,
+// so the longer ones come first
+type Keyring struct {
+	index []string
+	creds map[string][]authn.AuthConfig
+}
+
+// NewKeyring creates a keyring of the pull secrets. Credentials of the same key are kept in the order of the pull secrets.
+// Malformed credentials are skipped, as the kubelet does, so that the others in the same pull secret are still used
+func NewKeyring(pullSecrets []*ImagePullSecret) *Keyring {
+	k := &Keyring{creds: map[string][]authn.AuthConfig{}}
+	for _, pullSecret := range pullSecrets {
+		// Iterate in order for a deterministic result
+		keys := make([]string, 0, len(pullSecret.json.Auths))
+		for key := range pullSecret.json.Auths {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		for _, key := range keys {
+			loginAuth := pullSecret.json.Auths[key]
+			// Credentials in the credential helpers cannot be used
+			if len(loginAuth) == 0 {
+				continue
+			}
+			cfg, err := loginAuth.authConfig(key)
+			if err != nil {
+				keyringLog.Error(err, "Skipping malformed credential", "key", key)
+				continue
+			}
+			indexKey, ok := parseKeyringKey(key)
+			if !ok {
+				continue
+			}
+			if _, exist := k.creds[indexKey]; !exist {
+				k.index = append(k.index, indexKey)
+			}
+			k.creds[indexKey] = append(k.creds[indexKey], *cfg)
+		}
+	}
+	sort.Sort(sort.Reverse(sort.StringSlice(k.index)))
+	return k
+}
+
+// Lookup returns all the credentials matching the image repository, i.e., <host>/<path>, in the order they should be tried
+func (k *Keyring) Lookup(repository string) []authn.AuthConfig {
+	target, err := parseSchemelessURL(normalizeRepository(repository))
+	if err != nil {
+		return nil
+	}
+
+	var creds []authn.AuthConfig
+	for _, key := range k.index {
+		glob, err := parseSchemelessURL(key)
+		if err != nil {
+			continue
+		}
+		if matchCredentialHost(glob.Host, target.Host) && strings.HasPrefix(target.Path, glob.Path) {
+			creds = append(creds, k.creds[key]...)
+		}
+	}
+	return creds
+}
+
+// parseKeyringKey parses a key of the auths as <host>[/<path>]. The /v1/ and /v2/ paths are treated as the host itself, as docker does
+func parseKeyringKey(key string) (string, bool) {
+	u, err := parseSchemelessURL(key)
+	if err != nil || u.Host == "" {
+		return "", false
+	}
+	path := u.Path
+	if strings.HasPrefix(path, "/v1/") || strings.HasPrefix(path, "/v2/") {
+		path = path[3:]
+	}
+	path = strings.TrimSuffix(path, "/")
+	return normalizeRegistryHost(u.Host) + path, true
+}
+
+// parseSchemelessURL parses the url, which may not have a scheme
+func parseSchemelessURL(s string) (*url.URL, error) {
+	if !strings.Contains(s, "://") {
+		s = "https://" + s
+	}
+	return url.Parse(s)
+}
+
+// normalizeRepository normalizes the repository of docker hub, e.g., alpine, as docker.io/library/alpine
+func normalizeRepository(repository string) string {
+	tokens := strings.SplitN(repository, "/", 2)
+	if len(tokens) == 1 || (!strings.ContainsAny(tokens[0], ".:") && tokens[0] != "localhost") {
+		tokens = append([]string{DockerHubRegistry}, tokens...)
+	}
+	host, path := normalizeRegistryHost(tokens[0]), strings.Join(tokens[1:], "/")
+	if host == DockerHubRegistry && !strings.Contains(path, "/") {
+		path = "library/" + path
+	}
+	return host + "/" + path
+}
+
+// normalizeRegistryHost normalizes the hosts of docker hub as docker.io
+func normalizeRegistryHost(host string) string {
+	switch host {
+	case DockerHubRegistry, "index.docker.io", "registry-1.docker.io":
+		return DockerHubRegistry
+	}
+	return host
+}
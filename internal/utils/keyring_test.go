@@ -0,0 +1,153 @@
+package utils
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/stretchr/testify/require"
+)
+
+type keyringLookupTestCase struct {
+	repository string
+	auths      []map[string]DockerLoginCredential
+
+	expectedCreds []authn.AuthConfig
+}
+
+func TestKeyring_Lookup(t *testing.T) {
+	basic := func(username string) DockerLoginCredential {
+		return DockerLoginCredential{"auth": base64.StdEncoding.EncodeToString([]byte(username + ":pw"))}
+	}
+	cred := func(username string) authn.AuthConfig {
+		return authn.AuthConfig{Username: username, Password: "pw"}
+	}
+
+	tc := map[string]keyringLookupTestCase{
+		"notFound": {
+			repository: "harbor.corp/team-a/app",
+			auths:      []map[string]DockerLoginCredential{{"other.corp": basic("other")}},
+		},
+		"host": {
+			repository:    "harbor.corp/team-a/app",
+			auths:         []map[string]DockerLoginCredential{{"https://harbor.corp": basic("host")}},
+			expectedCreds: []authn.AuthConfig{cred("host")},
+		},
+		"longestPathFirst": {
+			repository: "harbor.corp/team-a/app",
+			auths: []map[string]DockerLoginCredential{{
+				"harbor.corp":             basic("host"),
+				"harbor.corp/team-a":      basic("team"),
+				"harbor.corp/team-a/app":  basic("app"),
+				"harbor.corp/team-b":      basic("other-team"),
+				"harbor.corp/team-a/app2": basic("other-app"),
+			}},
+			expectedCreds: []authn.AuthConfig{cred("app"), cred("team"), cred("host")},
+		},
+		"pathPrefix": {
+			repository:    "harbor.corp/team-ab/app",
+			auths:         []map[string]DockerLoginCredential{{"harbor.corp/team-a": basic("team")}},
+			expectedCreds: []authn.AuthConfig{cred("team")},
+		},
+		"versionPath": {
+			repository:    "harbor.corp/team-a/app",
+			auths:         []map[string]DockerLoginCredential{{"https://harbor.corp/v2/": basic("host")}},
+			expectedCreds: []authn.AuthConfig{cred("host")},
+		},
+		"wildcard": {
+			repository: "harbor.corp/team-a/app",
+			auths: []map[string]DockerLoginCredential{{
+				"*.corp":          basic("wildcard"),
+				"*.*.corp":        basic("subdomain"),
+				"*.corp/team-a":   basic("wildcardTeam"),
+				"harbor.corp:443": basic("port"),
+			}},
+			expectedCreds: []authn.AuthConfig{cred("wildcardTeam"), cred("wildcard")},
+		},
+		"port": {
+			repository: "harbor.corp:5000/team-a/app",
+			auths: []map[string]DockerLoginCredential{{
+				"harbor.corp":      basic("noPort"),
+				"harbor.corp:5000": basic("port"),
+				"harbor.corp:5001": basic("otherPort"),
+			}},
+			expectedCreds: []authn.AuthConfig{cred("port")},
+		},
+		"secretsInOrder": {
+			repository: "harbor.corp/team-a/app",
+			auths: []map[string]DockerLoginCredential{
+				{"harbor.corp": basic("first")},
+				{"harbor.corp/team-a": basic("team")},
+				{"https://harbor.corp": basic("second")},
+			},
+			expectedCreds: []authn.AuthConfig{cred("team"), cred("first"), cred("second")},
+		},
+		"dockerHub": {
+			repository: "alpine",
+			auths: []map[string]DockerLoginCredential{{
+				"https://index.docker.io/v1/": basic("legacy"),
+				"docker.io/tmax-cloud":        basic("other-namespace"),
+			}},
+			expectedCreds: []authn.AuthConfig{cred("legacy")},
+		},
+		"dockerHubNamespace": {
+			repository: "index.docker.io/tmax-cloud/app",
+			auths: []map[string]DockerLoginCredential{{
+				"https://index.docker.io/v1/": basic("legacy"),
+				"docker.io/tmax-cloud":        basic("namespace"),
+			}},
+			expectedCreds: []authn.AuthConfig{cred("namespace"), cred("legacy")},
+		},
+		"credsStore": {
+			repository: "harbor.corp/team-a/app",
+			auths:      []map[string]DockerLoginCredential{{"harbor.corp": {}}},
+		},
+		"username": {
+			repository:    "harbor.corp/team-a/app",
+			auths:         []map[string]DockerLoginCredential{{"harbor.corp": {"username": "testID", "password": "pw", "email": "test@tmax.co.kr"}}},
+			expectedCreds: []authn.AuthConfig{cred("testID")},
+		},
+		"user": {
+			repository:    "harbor.corp/team-a/app",
+			auths:         []map[string]DockerLoginCredential{{"harbor.corp": {"user": "testID", "password": "pw"}}},
+			expectedCreds: []authn.AuthConfig{cred("testID")},
+		},
+		"identityToken": {
+			repository: "harbor.corp/team-a/app",
+			auths: []map[string]DockerLoginCredential{{
+				"harbor.corp": {"auth": base64.StdEncoding.EncodeToString([]byte("<token>:")), "identitytoken": "refresh-token"},
+			}},
+			expectedCreds: []authn.AuthConfig{{Username: "<token>", IdentityToken: "refresh-token"}},
+		},
+		"registryToken": {
+			repository:    "harbor.corp/team-a/app",
+			auths:         []map[string]DockerLoginCredential{{"harbor.corp": {"registrytoken": "access-token"}}},
+			expectedCreds: []authn.AuthConfig{{RegistryToken: "access-token"}},
+		},
+		"invalidAuthSkipped": {
+			repository: "harbor.corp/team-a/app",
+			auths: []map[string]DockerLoginCredential{
+				{
+					"https://harbor.corp":  {"auth": base64.StdEncoding.EncodeToString([]byte("testID"))},
+					"harbor.corp/team-a":   {"auth": "dummy"},
+					"harbor.corp/team-a/a": {"id": "testID", "password": "pw"},
+					"*.corp":               basic("wildcard"),
+				},
+				{"harbor.corp": basic("second")},
+			},
+			expectedCreds: []authn.AuthConfig{cred("second"), cred("wildcard")},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			var pullSecrets []*ImagePullSecret
+			for _, auths := range c.auths {
+				pullSecrets = append(pullSecrets, &ImagePullSecret{json: &DockerConfigJSON{Auths: auths}})
+			}
+
+			keyring := NewKeyring(pullSecrets)
+			require.Equal(t, c.expectedCreds, keyring.Lookup(c.repository), "credentials")
+		})
+	}
+}
@@ -1,27 +1,37 @@
 package utils
 
 import (
-	"encoding/base64"
 	"encoding/json"
 	"fmt"
-	"net/url"
+	"net"
+	"path/filepath"
+	"strings"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	corev1 "k8s.io/api/core/v1"
 )
 
 // Keys for docker configs
 const (
-	DockerConfigAuthKey     = "auth"
-	DockerConfigUserKey     = "user"
-	DockerConfigPasswordKey = "password"
+	DockerConfigAuthKey          = "auth"
+	DockerConfigUserKey          = "user"
+	DockerConfigUsernameKey      = "username"
+	DockerConfigPasswordKey      = "password"
+	DockerConfigIdentityTokenKey = "identitytoken"
+	DockerConfigRegistryTokenKey = "registrytoken"
 )
 
 // DockerConfigJSON is a top-level dcj
 type DockerConfigJSON struct {
 	Auths map[string]DockerLoginCredential `json:"auths"`
+
+	// CredHelpers and CredsStore are credential helpers, which store the credentials of the hosts out of the docker config.
+	// They cannot be run in the webhook, so the hosts whose credentials are in them are treated as ones without credentials
+	CredHelpers map[string]string `json:"credHelpers,omitempty"`
+	CredsStore  string            `json:"credsStore,omitempty"`
 }
 
-// DockerLoginCredential is a [basic|id|pw]:auth map
+// DockerLoginCredential is a [basic|id|pw|token]:auth map
 type DockerLoginCredential map[string]string
 
 // ImagePullSecret is a secret and dcj struct
@@ -30,20 +40,29 @@ type ImagePullSecret struct {
 	json   *DockerConfigJSON
 }
 
-// NewImagePullSecret creates a new ImagePullSecret from a secret
+// NewImagePullSecret creates a new ImagePullSecret from a secret of either kubernetes.io/dockerconfigjson or legacy kubernetes.io/dockercfg type
 func NewImagePullSecret(secret *corev1.Secret) (*ImagePullSecret, error) {
-	if secret.Type != corev1.SecretTypeDockerConfigJson {
-		return nil, fmt.Errorf("unsupported secret type")
-	}
-
-	imagePullSecretData, ok := secret.Data[corev1.DockerConfigJsonKey]
-	if !ok {
-		return nil, fmt.Errorf("failed to get dockerconfig from ImagePullSecret")
-	}
-
 	var dockerConfigJSON DockerConfigJSON
-	if err := json.Unmarshal(imagePullSecretData, &dockerConfigJSON); err != nil {
-		return nil, fmt.Errorf("failed to unmarshal ImagePullSecret(%s)'s dockerconfig", secret.Name)
+	switch secret.Type {
+	case corev1.SecretTypeDockerConfigJson:
+		imagePullSecretData, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			return nil, fmt.Errorf("failed to get dockerconfig from ImagePullSecret")
+		}
+		if err := json.Unmarshal(imagePullSecretData, &dockerConfigJSON); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ImagePullSecret(%s)'s dockerconfig", secret.Name)
+		}
+	case corev1.SecretTypeDockercfg:
+		// Legacy dockercfg is the auths of dockerconfigjson itself
+		imagePullSecretData, ok := secret.Data[corev1.DockerConfigKey]
+		if !ok {
+			return nil, fmt.Errorf("failed to get dockercfg from ImagePullSecret")
+		}
+		if err := json.Unmarshal(imagePullSecretData, &dockerConfigJSON.Auths); err != nil {
+			return nil, fmt.Errorf("failed to unmarshal ImagePullSecret(%s)'s dockercfg", secret.Name)
+		}
+	default:
+		return nil, fmt.Errorf("unsupported secret type")
 	}
 
 	return &ImagePullSecret{
@@ -52,26 +71,68 @@ func NewImagePullSecret(secret *corev1.Secret) (*ImagePullSecret, error) {
 	}, nil
 }
 
-// GetHostBasicAuth parses a PullSecret for the given host
-func (s *ImagePullSecret) GetHostBasicAuth(host string) (string, error) {
-	loginAuth, ok := s.json.Auths[host]
-	if !ok {
-		u, _ := url.Parse(host)
-		loginAuth, ok = s.json.Auths[u.Host]
-		// DO NOT return error, image may be public
-		if !ok {
-			return "", nil
+// username returns the username, which is either in user or in the standard username key
+func (c DockerLoginCredential) username() (string, bool) {
+	if username, ok := c[DockerConfigUsernameKey]; ok {
+		return username, true
+	}
+	username, ok := c[DockerConfigUserKey]
+	return username, ok
+}
+
+// authConfig converts the credential of the host to the one of go-containerregistry
+func (c DockerLoginCredential) authConfig(host string) (*authn.AuthConfig, error) {
+	cfg := &authn.AuthConfig{
+		IdentityToken: c[DockerConfigIdentityTokenKey],
+		RegistryToken: c[DockerConfigRegistryTokenKey],
+	}
+
+	username, isUserPresent := c.username()
+	password, isPasswordPresent := c[DockerConfigPasswordKey]
+	if basicAuth := c[DockerConfigAuthKey]; basicAuth != "" {
+		var err error
+		cfg.Username, cfg.Password, err = decodeBasicAuth(basicAuth)
+		if err != nil {
+			return nil, fmt.Errorf("basic auth for host %s is invalid: %v", host, err)
 		}
+	} else if isUserPresent && isPasswordPresent {
+		cfg.Username, cfg.Password = username, password
+	} else if cfg.IdentityToken == "" && cfg.RegistryToken == "" {
+		return nil, fmt.Errorf("there is neither basic auth nor id/pw in docker config json for host %s", host)
 	}
+	return cfg, nil
+}
 
-	if basicAuth, isBasicPresent := loginAuth[DockerConfigAuthKey]; isBasicPresent {
-		return basicAuth, nil
+// matchCredentialHost checks if the target host matches the host of a key, which may have wildcards in each of its dot-separated parts.
+// Ports should be the same
+func matchCredentialHost(pattern, target string) bool {
+	if pattern == "" || target == "" {
+		return false
 	}
+	patternHost, patternPort := splitHostPort(pattern)
+	targetHost, targetPort := splitHostPort(target)
+	if patternPort != targetPort {
+		return false
+	}
+
+	patternParts := strings.Split(patternHost, ".")
+	targetParts := strings.Split(targetHost, ".")
+	if len(patternParts) != len(targetParts) {
+		return false
+	}
+	for i := range patternParts {
+		if matched, err := filepath.Match(patternParts[i], targetParts[i]); err != nil || !matched {
+			return false
+		}
+	}
+	return true
+}
 
-	username, isUserPresent := loginAuth[DockerConfigUserKey]
-	password, isPasswordPresent := loginAuth[DockerConfigPasswordKey]
-	if isUserPresent && isPasswordPresent {
-		return base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%s:%s", username, password))), nil
+// splitHostPort splits the host and the port, which may be empty
+func splitHostPort(host string) (string, string) {
+	h, p, err := net.SplitHostPort(host)
+	if err != nil {
+		return host, ""
 	}
-	return "", fmt.Errorf("there is neither basic auth nor id/pw in docker config json for host %s", host)
+	return h, p
 }
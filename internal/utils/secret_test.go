@@ -1,17 +1,19 @@
 package utils
 
 import (
-	"encoding/base64"
 	"testing"
 
 	"github.com/stretchr/testify/require"
 	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 )
 
 type newImagePullSecretTestCase struct {
 	secret *corev1.Secret
 
 	expectedAuths       map[string]DockerLoginCredential
+	expectedCredHelpers map[string]string
+	expectedCredsStore  string
 	expectedErrorOccurs bool
 	expectedErrorString string
 }
@@ -66,6 +68,54 @@ func TestNewImagePullSecret(t *testing.T) {
 				},
 			},
 		},
+		"credHelpers": {
+			secret: &corev1.Secret{
+				Type: corev1.SecretTypeDockerConfigJson,
+				Data: map[string][]byte{
+					corev1.DockerConfigJsonKey: []byte(`{"auths": {"reg-test.registry.ipip.nip.io": {}}, "credHelpers": {"gcr.io": "gcloud"}, "credsStore": "desktop"}`),
+				},
+			},
+			expectedAuths: map[string]DockerLoginCredential{
+				"reg-test.registry.ipip.nip.io": {},
+			},
+			expectedCredHelpers: map[string]string{"gcr.io": "gcloud"},
+			expectedCredsStore:  "desktop",
+		},
+		"dockercfg": {
+			secret: &corev1.Secret{
+				Type: corev1.SecretTypeDockercfg,
+				Data: map[string][]byte{
+					corev1.DockerConfigKey: []byte(`{"https://index.docker.io/v1/": {"auth": "valval", "email": "test@tmax.co.kr"}}`),
+				},
+			},
+			expectedAuths: map[string]DockerLoginCredential{
+				"https://index.docker.io/v1/": {
+					"auth":  "valval",
+					"email": "test@tmax.co.kr",
+				},
+			},
+		},
+		"dockercfgNoData": {
+			secret: &corev1.Secret{
+				Type: corev1.SecretTypeDockercfg,
+				Data: map[string][]byte{
+					corev1.DockerConfigJsonKey: []byte(`{"auths": {"registry-1.docker.io": {"auth": "valval"}}}`),
+				},
+			},
+			expectedErrorOccurs: true,
+			expectedErrorString: "failed to get dockercfg from ImagePullSecret",
+		},
+		"dockercfgInvalid": {
+			secret: &corev1.Secret{
+				ObjectMeta: metav1.ObjectMeta{Name: "legacy"},
+				Type:       corev1.SecretTypeDockercfg,
+				Data: map[string][]byte{
+					corev1.DockerConfigKey: []byte(`["registry-1.docker.io"]`),
+				},
+			},
+			expectedErrorOccurs: true,
+			expectedErrorString: "failed to unmarshal ImagePullSecret(legacy)'s dockercfg",
+		},
 		"notProperType": {
 			secret: &corev1.Secret{
 				Type: corev1.SecretTypeBasicAuth,
@@ -97,84 +147,8 @@ func TestNewImagePullSecret(t *testing.T) {
 			} else {
 				require.NoError(t, err, "error occurs")
 				require.Equal(t, c.expectedAuths, ps.json.Auths, "auth result")
-			}
-		})
-	}
-}
-
-type getHostBasicAuthTestCase struct {
-	host  string
-	auths map[string]DockerLoginCredential
-
-	expectedAuth        string
-	expectedErrorOccurs bool
-	expectedErrorString string
-}
-
-func TestImagePullSecret_GetHostBasicAuth(t *testing.T) {
-	tc := map[string]getHostBasicAuthTestCase{
-		"notFound": {
-			host: "https://not-found-host",
-			auths: map[string]DockerLoginCredential{
-				"https://found-host": {"auth": "dummy"},
-			},
-			expectedAuth:        "",
-			expectedErrorOccurs: false,
-			expectedErrorString: "",
-		},
-		"onlyHost": {
-			host: "https://found-host",
-			auths: map[string]DockerLoginCredential{
-				"found-host": {"auth": "dummy"},
-			},
-			expectedAuth:        "dummy",
-			expectedErrorOccurs: false,
-			expectedErrorString: "",
-		},
-		"basicAuth": {
-			host: "https://found-host",
-			auths: map[string]DockerLoginCredential{
-				"https://found-host": {"auth": "dummy"},
-			},
-			expectedAuth:        "dummy",
-			expectedErrorOccurs: false,
-			expectedErrorString: "",
-		},
-		"idpw": {
-			host: "https://found-host",
-			auths: map[string]DockerLoginCredential{
-				"https://found-host": {"user": "testID", "password": "testPW"},
-			},
-			expectedAuth:        base64.StdEncoding.EncodeToString([]byte("testID:testPW")),
-			expectedErrorOccurs: false,
-			expectedErrorString: "",
-		},
-		"noProperKeys": {
-			host: "https://found-host",
-			auths: map[string]DockerLoginCredential{
-				"https://found-host": {"id": "testID", "password": "testPW"},
-			},
-			expectedAuth:        "",
-			expectedErrorOccurs: true,
-			expectedErrorString: "there is neither basic auth nor id/pw in docker config json for host https://found-host",
-		},
-	}
-
-	for name, c := range tc {
-		t.Run(name, func(t *testing.T) {
-			ps := ImagePullSecret{
-				json: &DockerConfigJSON{
-					Auths: c.auths,
-				},
-			}
-
-			auth, err := ps.GetHostBasicAuth(c.host)
-			if c.expectedErrorOccurs {
-				require.Error(t, err, "error occurs")
-				require.Equal(t, c.expectedErrorString, err.Error(), "error string")
-			} else {
-				require.NoError(t, err, "error occurs")
-				require.Equal(t, c.expectedAuth, auth, "basic auth")
+				require.Equal(t, c.expectedCredHelpers, ps.json.CredHelpers, "credHelpers result")
+				require.Equal(t, c.expectedCredsStore, ps.json.CredsStore, "credsStore result")
 			}
 		})
 	}
@@ -0,0 +1,441 @@
+package pods
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
+	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/rest"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	keylog = logf.Log.WithName("keys.go")
+)
+
+const (
+	// caBundleKey is the default key of the data which has the CA certificates
+	caBundleKey = "ca.crt"
+
+	// cachedClientSyncTimeout is the time to wait for the cache of a newly watched object to be synced
+	cachedClientSyncTimeout = 10 * time.Second
+
+	// cachedClientIdleTimeout is the time after which an object, which is not read, is not watched anymore
+	cachedClientIdleTimeout = 1 * time.Hour
+)
+
+// keyCache is a cache of the secrets and the config maps which have cosign public keys or CA certificates, and of the pull secrets and the service accounts.
+// Each object is watched from when it is referenced by a policy for the first time, and its keys are parsed once per resource version.
+// Objects which are not read for a while are not watched anymore, so that the watches do not grow with every pod's service account
+type keyCache struct {
+	watchCli rest.Interface
+
+	// offlineClients have all the objects of each resource, which are not watched, e.g., the ones read from files
+	offlineClients map[corev1.ResourceName]watcher.CachedClient
+
+	lock          sync.Mutex
+	cachedClients map[string]*watchedObject
+	syncs         map[string]*cachedClientSync
+	parsedKeys    map[string]parsedKeys
+	certPools     map[string]parsedCertPool
+	rekorKeys     map[string]parsedRekorKeys
+
+	syncTimeout time.Duration
+
+	// watch and now are for the test purpose
+	watch func(resource corev1.ResourceName, namespace, name string, synced chan struct{}) (watcher.CachedClient, func())
+	now   func() time.Time
+}
+
+// watchedObject is an object watched by the keyCache
+type watchedObject struct {
+	cachedClient watcher.CachedClient
+	stop         func()
+	lastUsed     time.Time
+}
+
+// cachedClientSync is the first sync of the cache of an object, which the concurrent readers of the object wait for together
+type cachedClientSync struct {
+	// done is closed when the cache is synced or the sync fails
+	done chan struct{}
+
+	cachedClient watcher.CachedClient
+	err          error
+}
+
+// parsedKeys are the public keys parsed from the data of an object, at its resource version
+type parsedKeys struct {
+	resourceVersion string
+	keys            []crypto.PublicKey
+}
+
+// parsedRekorKeys are the rekor public keys parsed from the data of a secret, at its resource version
+type parsedRekorKeys struct {
+	resourceVersion string
+	keys            []*ecdsa.PublicKey
+}
+
+// parsedCertPool is the cert pool parsed from the data of an object, at its resource version
+type parsedCertPool struct {
+	resourceVersion string
+	pool            *x509.CertPool
+}
+
+// missingKeyObjectError is an error for the secret or the config map which is referenced by a policy but does not exist
+type missingKeyObjectError struct {
+	resource  corev1.ResourceName
+	namespace string
+	name      string
+}
+
+func (e *missingKeyObjectError) Error() string {
+	return fmt.Sprintf("%s %s/%s referenced by the registry security policy does not exist", e.resource, e.namespace, e.name)
+}
+
+func newKeyCache(cfg *rest.Config) (*keyCache, error) {
+	// Create watcher client for corev1
+	watchCli, err := k8s.NewGroupVersionClient(cfg, corev1.SchemeGroupVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &keyCache{
+		watchCli:      watchCli,
+		cachedClients: map[string]*watchedObject{},
+		syncs:         map[string]*cachedClientSync{},
+		parsedKeys:    map[string]parsedKeys{},
+		certPools:     map[string]parsedCertPool{},
+		rekorKeys:     map[string]parsedRekorKeys{},
+		syncTimeout:   cachedClientSyncTimeout,
+		now:           time.Now,
+	}
+	c.watch = c.startWatch
+	return c, nil
+}
+
+// getPublicKeys gets the cosign public keys from the key source. Their IDs are the references of the source
+func (c *keyCache) getPublicKeys(source *whv1.CosignKeySource) ([]cosigns.Key, error) {
+	var keys []cosigns.Key
+	if source.SecretRef != nil {
+		secretKeys, err := c.getObjectPublicKeys(corev1.ResourceSecrets, source.SecretRef)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, secretKeys...)
+	}
+	if source.ConfigMapRef != nil {
+		configMapKeys, err := c.getObjectPublicKeys(corev1.ResourceConfigMaps, source.ConfigMapRef)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, configMapKeys...)
+	}
+	if source.PublicKey != "" {
+		publicKeys, err := cosigns.ParsePublicKeys([]byte(source.PublicKey), "inline public key")
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, cosigns.NewKeys("inline", publicKeys)...)
+	}
+	return keys, nil
+}
+
+// getObjectPublicKeys gets the cosign public keys from the data of the secret or the config map
+func (c *keyCache) getObjectPublicKeys(resource corev1.ResourceName, ref *whv1.KeyObjectReference) ([]cosigns.Key, error) {
+	key := ref.Key
+	if key == "" {
+		key = cosigns.PublicKeyKey
+	}
+
+	id := fmt.Sprintf("%s/%s/%s/%s", resource, ref.Namespace, ref.Name, key)
+	publicKeys, err := c.getParsedKeys(resource, ref.Namespace, ref.Name, key, func(b []byte) ([]crypto.PublicKey, error) {
+		publicKeys, err := cosigns.ParsePublicKeys(b, id)
+		if err != nil {
+			return nil, err
+		}
+		if len(publicKeys) == 0 {
+			return nil, fmt.Errorf("there is no cosign public key in %s", id)
+		}
+		return publicKeys, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cosigns.NewKeys(id, publicKeys), nil
+}
+
+// getRekorPublicKeys gets the rekor public keys from rekor.pub of the secret. The keys are parsed again only if the secret is updated
+func (c *keyCache) getRekorPublicKeys(namespace, name string) ([]*ecdsa.PublicKey, error) {
+	resourceVersion, data, err := c.getData(corev1.ResourceSecrets, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	id := fmt.Sprintf("%s/%s/%s/%s", corev1.ResourceSecrets, namespace, name, cosigns.RekorPublicKeyKey)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if parsed, exist := c.rekorKeys[id]; exist && parsed.resourceVersion == resourceVersion {
+		return parsed.keys, nil
+	}
+
+	keys, err := cosigns.GetRekorPublicKeys(map[string][]byte{cosigns.RekorPublicKeyKey: data[cosigns.RekorPublicKeyKey]})
+	if err != nil {
+		return nil, err
+	}
+	c.rekorKeys[id] = parsedRekorKeys{resourceVersion: resourceVersion, keys: keys}
+	return keys, nil
+}
+
+// getParsedKeys gets the keys parsed from the data of the object. The data is parsed again only if the object is updated
+func (c *keyCache) getParsedKeys(resource corev1.ResourceName, namespace, name, key string, parse func([]byte) ([]crypto.PublicKey, error)) ([]crypto.PublicKey, error) {
+	resourceVersion, data, err := c.getData(resource, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+
+	parsedKey := fmt.Sprintf("%s/%s/%s/%s", resource, namespace, name, key)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if parsed, exist := c.parsedKeys[parsedKey]; exist && parsed.resourceVersion == resourceVersion {
+		return parsed.keys, nil
+	}
+
+	keys, err := parse(data[key])
+	if err != nil {
+		return nil, err
+	}
+	c.parsedKeys[parsedKey] = parsedKeys{resourceVersion: resourceVersion, keys: keys}
+	return keys, nil
+}
+
+// getCertPool gets the cert pool of the system CAs and the CA certificates of the source.
+// The certificates are parsed again only if the object is updated
+func (c *keyCache) getCertPool(source *whv1.CABundleSource) (*x509.CertPool, error) {
+	id, resourceVersion, bundle, err := c.getCABundleData(source)
+	if err != nil {
+		return nil, err
+	}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	if parsed, exist := c.certPools[id]; exist && parsed.resourceVersion == resourceVersion {
+		return parsed.pool, nil
+	}
+
+	pool, err := x509.SystemCertPool()
+	if err != nil {
+		keylog.Error(err, "failed to load the system cert pool")
+		pool = x509.NewCertPool()
+	}
+	if !pool.AppendCertsFromPEM(bundle) {
+		return nil, fmt.Errorf("there is no CA certificate in %s", id)
+	}
+	c.certPools[id] = parsedCertPool{resourceVersion: resourceVersion, pool: pool}
+	return pool, nil
+}
+
+// getCABundle gets the PEM encoded CA certificates of the source from the cache
+func (c *keyCache) getCABundle(source *whv1.CABundleSource) ([]byte, error) {
+	_, _, bundle, err := c.getCABundleData(source)
+	return bundle, err
+}
+
+// getCABundleData gets the ID, the resource version and the PEM encoded CA certificates of the source from the cache
+func (c *keyCache) getCABundleData(source *whv1.CABundleSource) (string, string, []byte, error) {
+	resource, ref := corev1.ResourceSecrets, source.SecretRef
+	if ref == nil {
+		resource, ref = corev1.ResourceConfigMaps, source.ConfigMapRef
+	}
+	if ref == nil {
+		return "", "", nil, fmt.Errorf("CA bundle should have either secretRef or configMapRef")
+	}
+	key := ref.Key
+	if key == "" {
+		key = caBundleKey
+	}
+
+	resourceVersion, data, err := c.getData(resource, ref.Namespace, ref.Name)
+	if err != nil {
+		return "", "", nil, err
+	}
+	return fmt.Sprintf("%s/%s/%s/%s", resource, ref.Namespace, ref.Name, key), resourceVersion, data[key], nil
+}
+
+// getData gets the resource version and the data of the secret or the config map from the cache
+func (c *keyCache) getData(resource corev1.ResourceName, namespace, name string) (string, map[string][]byte, error) {
+	switch resource {
+	case corev1.ResourceSecrets:
+		secret, err := c.getSecret(namespace, name)
+		if err != nil {
+			return "", nil, err
+		}
+		return secret.ResourceVersion, secret.Data, nil
+	case corev1.ResourceConfigMaps:
+		key := types.NamespacedName{Namespace: namespace, Name: name}
+		cachedClient, err := c.getCachedClient(resource, namespace, name)
+		if err != nil {
+			return "", nil, err
+		}
+		cm := &corev1.ConfigMap{}
+		if err := cachedClient.Get(key, cm); err != nil {
+			return "", nil, c.getError(resource, key, err)
+		}
+		data := map[string][]byte{}
+		for k, v := range cm.BinaryData {
+			data[k] = v
+		}
+		for k, v := range cm.Data {
+			data[k] = []byte(v)
+		}
+		return cm.ResourceVersion, data, nil
+	default:
+		return "", nil, fmt.Errorf("resource %s is not supported", resource)
+	}
+}
+
+// getSecret gets the secret from the cache
+func (c *keyCache) getSecret(namespace, name string) (*corev1.Secret, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	cachedClient, err := c.getCachedClient(corev1.ResourceSecrets, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	secret := &corev1.Secret{}
+	if err := cachedClient.Get(key, secret); err != nil {
+		return nil, c.getError(corev1.ResourceSecrets, key, err)
+	}
+	return secret, nil
+}
+
+func (c *keyCache) getError(resource corev1.ResourceName, key types.NamespacedName, err error) error {
+	if errors.IsNotFound(err) {
+		return &missingKeyObjectError{resource: resource, namespace: key.Namespace, name: key.Name}
+	}
+	return fmt.Errorf("couldn't get %s %s by %s", resource, key, err)
+}
+
+// getCachedClient gets the cached client of the object. It starts to watch the object if it is not watched yet, and waits for its cache
+// to be synced without holding the lock. Concurrent readers of the object share the sync, which fails if it is not done in time
+func (c *keyCache) getCachedClient(resource corev1.ResourceName, namespace, name string) (watcher.CachedClient, error) {
+	if c.offlineClients != nil {
+		return c.offlineClients[resource], nil
+	}
+
+	cacheKey := fmt.Sprintf("%s/%s/%s", resource, namespace, name)
+
+	c.lock.Lock()
+	if w, exist := c.cachedClients[cacheKey]; exist {
+		w.lastUsed = c.now()
+		c.lock.Unlock()
+		return w.cachedClient, nil
+	}
+	pending, syncing := c.syncs[cacheKey]
+	if !syncing {
+		pending = &cachedClientSync{done: make(chan struct{})}
+		c.syncs[cacheKey] = pending
+	}
+	c.lock.Unlock()
+
+	if !syncing {
+		c.sync(cacheKey, pending, resource, namespace, name)
+	}
+	<-pending.done
+	return pending.cachedClient, pending.err
+}
+
+// sync starts to watch the object and waits until its cache is synced. The watch is stopped if it is not synced in time,
+// so that the object is watched again by the next reader
+func (c *keyCache) sync(cacheKey string, s *cachedClientSync, resource corev1.ResourceName, namespace, name string) {
+	keylog.Info(fmt.Sprintf("Start to watch %s", cacheKey))
+	synced := make(chan struct{}, 1)
+	cachedClient, stop := c.watch(resource, namespace, name, synced)
+
+	timer := time.NewTimer(c.syncTimeout)
+	defer timer.Stop()
+
+	select {
+	case <-synced:
+		s.cachedClient = cachedClient
+	case <-timer.C:
+		stop()
+		s.err = fmt.Errorf("timed out waiting for the cache of %s to be synced", cacheKey)
+	}
+
+	c.lock.Lock()
+	if s.err == nil {
+		now := c.now()
+		c.evictIdle(now)
+		c.cachedClients[cacheKey] = &watchedObject{cachedClient: cachedClient, stop: stop, lastUsed: now}
+	}
+	delete(c.syncs, cacheKey)
+	c.lock.Unlock()
+
+	close(s.done)
+}
+
+// evictIdle stops watching the objects which are not read for cachedClientIdleTimeout, and drops the keys parsed from them.
+// It should be called with the lock held
+func (c *keyCache) evictIdle(now time.Time) {
+	for cacheKey, w := range c.cachedClients {
+		if now.Sub(w.lastUsed) <= cachedClientIdleTimeout {
+			continue
+		}
+		keylog.Info(fmt.Sprintf("Stop watching %s", cacheKey))
+		if w.stop != nil {
+			w.stop()
+		}
+		delete(c.cachedClients, cacheKey)
+
+		prefix := cacheKey + "/"
+		for id := range c.parsedKeys {
+			if strings.HasPrefix(id, prefix) {
+				delete(c.parsedKeys, id)
+			}
+		}
+		for id := range c.certPools {
+			if strings.HasPrefix(id, prefix) {
+				delete(c.certPools, id)
+			}
+		}
+		for id := range c.rekorKeys {
+			if strings.HasPrefix(id, prefix) {
+				delete(c.rekorKeys, id)
+			}
+		}
+	}
+}
+
+// startWatch starts to watch the object. synced is sent when its cache is synced, and the returned function stops the watch
+func (c *keyCache) startWatch(resource corev1.ResourceName, namespace, name string, synced chan struct{}) (watcher.CachedClient, func()) {
+	var obj runtime.Object
+	switch resource {
+	case corev1.ResourceSecrets:
+		obj = &corev1.Secret{}
+	case resourceServiceAccounts:
+		obj = &corev1.ServiceAccount{}
+	default:
+		obj = &corev1.ConfigMap{}
+	}
+
+	w := watcher.New(namespace, string(resource), obj, c.watchCli, fields.ParseSelectorOrDie(fmt.Sprintf("metadata.name=%s", name)))
+	go w.Start(synced)
+	return watcher.NewCachedClient(w), w.Stop
+}
@@ -0,0 +1,407 @@
+package pods
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type keyCacheTestCase struct {
+	source *whv1.CosignKeySource
+
+	expectedKeyIDs   []string
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestKeyCache_GetPublicKeys(t *testing.T) {
+	publicKey := testPublicKeyPem(t)
+
+	kc := testKeyCache(t, map[string]runtime.Object{
+		"secrets/test/cosign-secret": &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "cosign-secret", Namespace: "test"},
+			Data:       map[string][]byte{"cosign.pub": []byte(publicKey + publicKey), "cosign.key": []byte("private")},
+		},
+		"configmaps/test/cosign-cm": &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "cosign-cm", Namespace: "test"},
+			Data:       map[string]string{"release.pub": publicKey},
+		},
+	})
+	kc.cachedClients["secrets/test/missing"] = &watchedObject{cachedClient: &notFoundCachedClient{}}
+
+	tc := map[string]keyCacheTestCase{
+		"secret": {
+			source:         &whv1.CosignKeySource{SecretRef: &whv1.KeyObjectReference{Namespace: "test", Name: "cosign-secret"}},
+			expectedKeyIDs: []string{"secrets/test/cosign-secret/cosign.pub#0", "secrets/test/cosign-secret/cosign.pub#1"},
+		},
+		"configMapWithKey": {
+			source:         &whv1.CosignKeySource{ConfigMapRef: &whv1.KeyObjectReference{Namespace: "test", Name: "cosign-cm", Key: "release.pub"}},
+			expectedKeyIDs: []string{"configmaps/test/cosign-cm/release.pub"},
+		},
+		"configMapNoKey": {
+			source:           &whv1.CosignKeySource{ConfigMapRef: &whv1.KeyObjectReference{Namespace: "test", Name: "cosign-cm"}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "there is no cosign public key in configmaps/test/cosign-cm/cosign.pub",
+		},
+		"inline": {
+			source:         &whv1.CosignKeySource{PublicKey: publicKey},
+			expectedKeyIDs: []string{"inline"},
+		},
+		"missingSecret": {
+			source:           &whv1.CosignKeySource{SecretRef: &whv1.KeyObjectReference{Namespace: "test", Name: "missing"}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "secrets test/missing referenced by the registry security policy does not exist",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			keys, err := kc.getPublicKeys(c.source)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+			} else {
+				require.NoError(t, err)
+				var ids []string
+				for _, k := range keys {
+					ids = append(ids, k.ID)
+				}
+				require.Equal(t, c.expectedKeyIDs, ids)
+			}
+		})
+	}
+}
+
+func TestKeyCache_ParseOnce(t *testing.T) {
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "cosign-secret", Namespace: "test", ResourceVersion: "1"},
+		Data:       map[string][]byte{"cosign.pub": []byte(testPublicKeyPem(t))},
+	}
+	kc := testKeyCache(t, map[string]runtime.Object{"secrets/test/cosign-secret": secret})
+	ref := &whv1.KeyObjectReference{Namespace: "test", Name: "cosign-secret"}
+
+	keys, err := kc.getObjectPublicKeys(corev1.ResourceSecrets, ref)
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+
+	// Keys are not parsed again if the secret is not updated
+	secret.Data["cosign.pub"] = []byte("malformed")
+	cachedKeys, err := kc.getObjectPublicKeys(corev1.ResourceSecrets, ref)
+	require.NoError(t, err)
+	require.Equal(t, keys, cachedKeys)
+
+	// Keys are parsed again as soon as the secret is updated
+	secret.ResourceVersion = "2"
+	newPublicKey := testPublicKeyPem(t)
+	secret.Data["cosign.pub"] = []byte(newPublicKey)
+	updatedKeys, err := kc.getObjectPublicKeys(corev1.ResourceSecrets, ref)
+	require.NoError(t, err)
+	require.Len(t, updatedKeys, 1)
+	require.NotEqual(t, keys[0].PublicKey, updatedKeys[0].PublicKey)
+}
+
+type certPoolTestCase struct {
+	source *whv1.CABundleSource
+
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestKeyCache_GetCertPool(t *testing.T) {
+	testSrv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+	defer testSrv.Close()
+	caBundle := pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: testSrv.Certificate().Raw})
+
+	kc := testKeyCache(t, map[string]runtime.Object{
+		"secrets/test/ca-secret": &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca-secret", Namespace: "test"},
+			Data:       map[string][]byte{"ca.crt": caBundle},
+		},
+		"configmaps/test/ca-cm": &corev1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{Name: "ca-cm", Namespace: "test"},
+			Data:       map[string]string{"registry.crt": string(caBundle), "ca.crt": "malformed"},
+		},
+	})
+	kc.cachedClients["configmaps/test/missing"] = &watchedObject{cachedClient: &notFoundCachedClient{}}
+
+	tc := map[string]certPoolTestCase{
+		"secret": {
+			source: &whv1.CABundleSource{SecretRef: &whv1.KeyObjectReference{Namespace: "test", Name: "ca-secret"}},
+		},
+		"configMapWithKey": {
+			source: &whv1.CABundleSource{ConfigMapRef: &whv1.KeyObjectReference{Namespace: "test", Name: "ca-cm", Key: "registry.crt"}},
+		},
+		"configMapNoCert": {
+			source:           &whv1.CABundleSource{ConfigMapRef: &whv1.KeyObjectReference{Namespace: "test", Name: "ca-cm"}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "there is no CA certificate in configmaps/test/ca-cm/ca.crt",
+		},
+		"missingConfigMap": {
+			source:           &whv1.CABundleSource{ConfigMapRef: &whv1.KeyObjectReference{Namespace: "test", Name: "missing"}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "configmaps test/missing referenced by the registry security policy does not exist",
+		},
+		"noRef": {
+			source:           &whv1.CABundleSource{},
+			expectedErrOccur: true,
+			expectedErrMsg:   "CA bundle should have either secretRef or configMapRef",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			pool, err := kc.getCertPool(c.source)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+
+			// The server is trusted with the pool
+			cli := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{RootCAs: pool}}}
+			resp, err := cli.Get(testSrv.URL)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+		})
+	}
+}
+
+type rekorPublicKeysTestCase struct {
+	name string
+
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestKeyCache_GetRekorPublicKeys(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	require.NoError(t, err)
+	rsaDer, err := x509.MarshalPKIXPublicKey(&rsaKey.PublicKey)
+	require.NoError(t, err)
+
+	kc := testKeyCache(t, map[string]runtime.Object{
+		"secrets/test/rekor": &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "rekor", Namespace: "test"},
+			Data:       map[string][]byte{"rekor.pub": []byte(testPublicKeyPem(t))},
+		},
+		"secrets/test/rsa": &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "rsa", Namespace: "test"},
+			Data:       map[string][]byte{"rekor.pub": pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: rsaDer})},
+		},
+	})
+
+	tc := map[string]rekorPublicKeysTestCase{
+		"ecdsa": {
+			name: "rekor",
+		},
+		"rsa": {
+			name:             "rsa",
+			expectedErrOccur: true,
+			expectedErrMsg:   "rekor public key is not an ECDSA key",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			keys, err := kc.getRekorPublicKeys("test", c.name)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, keys, 1)
+
+			// Keys are parsed once
+			cached, err := kc.getRekorPublicKeys("test", c.name)
+			require.NoError(t, err)
+			require.Same(t, keys[0], cached[0])
+		})
+	}
+}
+
+func TestKeyCache_GetCachedClient(t *testing.T) {
+	slowClient := &watcherfake.CachedClient{Cache: map[string]runtime.Object{"test/slow": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "slow"}}}}
+	release := make(chan struct{})
+	var lock sync.Mutex
+	watched := map[string]int{}
+	stopped := map[string]int{}
+
+	kc := testKeyCache(t, map[string]runtime.Object{"secrets/test/watched": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "watched"}}})
+	kc.syncTimeout = 5 * time.Second
+	kc.watch = func(_ corev1.ResourceName, _, name string, synced chan struct{}) (watcher.CachedClient, func()) {
+		lock.Lock()
+		watched[name]++
+		lock.Unlock()
+		if name == "slow" {
+			go func() {
+				<-release
+				synced <- struct{}{}
+			}()
+		}
+		// Other objects are never synced
+		return slowClient, func() {
+			lock.Lock()
+			stopped[name]++
+			lock.Unlock()
+		}
+	}
+	watchCount := func(name string) int {
+		lock.Lock()
+		defer lock.Unlock()
+		return watched[name]
+	}
+
+	t.Run("sharedSync", func(t *testing.T) {
+		type result struct {
+			cachedClient watcher.CachedClient
+			err          error
+		}
+		results := make(chan result, 3)
+		for i := 0; i < 3; i++ {
+			go func() {
+				cachedClient, err := kc.getCachedClient(corev1.ResourceSecrets, "test", "slow")
+				results <- result{cachedClient: cachedClient, err: err}
+			}()
+		}
+		require.Eventually(t, func() bool { return watchCount("slow") == 1 }, 5*time.Second, time.Millisecond)
+
+		// Other objects are read while the object is being synced
+		_, err := kc.getSecret("test", "watched")
+		require.NoError(t, err)
+
+		close(release)
+		for i := 0; i < 3; i++ {
+			r := <-results
+			require.NoError(t, r.err)
+			require.Equal(t, slowClient, r.cachedClient)
+		}
+		require.Equal(t, 1, watchCount("slow"), "watched")
+
+		_, err = kc.getSecret("test", "slow")
+		require.NoError(t, err)
+		require.Equal(t, 1, watchCount("slow"), "watched again")
+	})
+
+	t.Run("timeout", func(t *testing.T) {
+		kc.syncTimeout = 10 * time.Millisecond
+
+		_, err := kc.getSecret("test", "never")
+		require.Error(t, err)
+		require.Equal(t, "timed out waiting for the cache of secrets/test/never to be synced", err.Error())
+		require.Equal(t, 1, stopped["never"], "stopped")
+
+		// It is watched again by the next reader
+		_, err = kc.getSecret("test", "never")
+		require.Error(t, err)
+		require.Equal(t, 2, watchCount("never"), "watched")
+		require.Empty(t, kc.syncs, "syncs")
+	})
+}
+
+func TestKeyCache_EvictIdle(t *testing.T) {
+	publicKey := testPublicKeyPem(t)
+	kc := testKeyCache(t, map[string]runtime.Object{
+		"secrets/test/old": &corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "old", Namespace: "test"},
+			Data:       map[string][]byte{"cosign.pub": []byte(publicKey)},
+		},
+		"secrets/test/recent": &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: "recent", Namespace: "test"}},
+	})
+
+	start := time.Now()
+	clock := start
+	kc.now = func() time.Time { return clock }
+	stopped := map[string]int{}
+	for _, name := range []string{"old", "recent"} {
+		name := name
+		kc.cachedClients["secrets/test/"+name].lastUsed = start
+		kc.cachedClients["secrets/test/"+name].stop = func() { stopped[name]++ }
+	}
+	watched := map[string]int{}
+	kc.watch = func(_ corev1.ResourceName, namespace, name string, synced chan struct{}) (watcher.CachedClient, func()) {
+		watched[name]++
+		synced <- struct{}{}
+		return &watcherfake.CachedClient{Cache: map[string]runtime.Object{
+			namespace + "/" + name: &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: namespace}},
+		}}, func() {}
+	}
+
+	_, err := kc.getPublicKeys(&whv1.CosignKeySource{SecretRef: &whv1.KeyObjectReference{Namespace: "test", Name: "old"}})
+	require.NoError(t, err)
+	require.Contains(t, kc.parsedKeys, "secrets/test/old/cosign.pub")
+
+	clock = start.Add(cachedClientIdleTimeout / 2)
+	_, err = kc.getSecret("test", "recent")
+	require.NoError(t, err)
+
+	// Idle objects are not watched anymore, when a new object is watched
+	clock = start.Add(cachedClientIdleTimeout + time.Minute)
+	_, err = kc.getSecret("test", "new")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"old": 1}, stopped, "stopped")
+	require.NotContains(t, kc.cachedClients, "secrets/test/old")
+	require.NotContains(t, kc.parsedKeys, "secrets/test/old/cosign.pub")
+	require.Contains(t, kc.cachedClients, "secrets/test/recent")
+	require.Contains(t, kc.cachedClients, "secrets/test/new")
+
+	// Evicted objects are watched again
+	_, err = kc.getSecret("test", "old")
+	require.NoError(t, err)
+	require.Equal(t, map[string]int{"new": 1, "old": 1}, watched, "watched")
+}
+
+// notFoundCachedClient is a watcher.CachedClient, which does not have any object
+type notFoundCachedClient struct{}
+
+func (c *notFoundCachedClient) Get(key types.NamespacedName, _ runtime.Object) error {
+	return errors.NewNotFound(schema.GroupResource{}, key.Name)
+}
+
+func (c *notFoundCachedClient) List(_ watcher.Selector, _ runtime.Object) error {
+	return nil
+}
+
+// testKeyCache creates a keyCache whose objects are already watched. objs are keyed by <resource>/<namespace>/<name>
+func testKeyCache(t *testing.T, objs map[string]runtime.Object) *keyCache {
+	kc := &keyCache{cachedClients: map[string]*watchedObject{}, syncs: map[string]*cachedClientSync{}, parsedKeys: map[string]parsedKeys{}, certPools: map[string]parsedCertPool{}, rekorKeys: map[string]parsedRekorKeys{}, syncTimeout: cachedClientSyncTimeout, now: time.Now}
+	for key, obj := range objs {
+		metaObj, err := meta.Accessor(obj)
+		require.NoError(t, err)
+		kc.cachedClients[key] = &watchedObject{
+			cachedClient: &watcherfake.CachedClient{Cache: map[string]runtime.Object{metaObj.GetNamespace() + "/" + metaObj.GetName(): obj}},
+			lastUsed:     time.Now(),
+		}
+	}
+	return kc
+}
+
+func testPublicKeyPem(t *testing.T) string {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+}
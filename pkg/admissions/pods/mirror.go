@@ -0,0 +1,152 @@
+package pods
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	ctrl "sigs.k8s.io/controller-runtime"
+)
+
+const (
+	mirrorConfigMap = "image-validation-webhook-mirrors"
+
+	mirrorsKey = "mirrors"
+
+	mirrorSeparator = "="
+)
+
+var mlog = ctrl.Log.WithName("mirror.go")
+
+// Mirrors stores the mappings of the upstream registries to their mirrors, e.g., proxy caches or containerd mirrors.
+// Policies are matched with the upstream registry, and signatures are looked up on the mirror, which the nodes pull the images from
+type Mirrors struct {
+	mirrors []mirror
+
+	lock sync.Mutex
+
+	cachedClient watcher.CachedClient
+}
+
+// mirror maps an upstream registry to the repositories of a mirror host, under the path prefix
+type mirror struct {
+	upstream string
+	host     string
+	prefix   string
+}
+
+func newMirrors(cfg *rest.Config) (*Mirrors, error) {
+	m := &Mirrors{}
+
+	// Create watcher client for corev1
+	watchCli, err := k8s.NewGroupVersionClient(cfg, corev1.SchemeGroupVersion)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initiate watcher
+	w := watcher.New(registryNamespace, string(corev1.ResourceConfigMaps), &corev1.ConfigMap{}, watchCli, fields.ParseSelectorOrDie(fmt.Sprintf("metadata.name=%s", mirrorConfigMap)))
+	m.cachedClient = watcher.NewCachedClient(w)
+
+	w.SetHandler(m)
+
+	waitCh := make(chan struct{})
+
+	// Start to watch mirror config map
+	go w.Start(waitCh)
+
+	// Block until it's ready
+	<-waitCh
+
+	return m, nil
+}
+
+// Handle handles a mirror configmap update event
+func (m *Mirrors) Handle(object runtime.Object) error {
+	cm, ok := object.(*corev1.ConfigMap)
+	if !ok {
+		return fmt.Errorf("object is not a ConfigMap")
+	}
+
+	mlog.Info("Mirrors are updated. Parsing...")
+	return m.Unmarshal(cm.Data[mirrorsKey])
+}
+
+// Unmarshal parses mirrors from line-separated list of <upstream>=<mirror host>[/<path prefix>]
+func (m *Mirrors) Unmarshal(list string) error {
+	var mirrors []mirror
+	for _, line := range parseLineSeparatedList(list) {
+		tokens := strings.Split(line, mirrorSeparator)
+		if len(tokens) != 2 {
+			return fmt.Errorf("mirror %s is not in <upstream>=<mirror host>[/<path prefix>] form", line)
+		}
+		upstream := strings.TrimSpace(tokens[0])
+		target := strings.Trim(strings.TrimSpace(tokens[1]), "/")
+		if upstream == "" || target == "" {
+			return fmt.Errorf("mirror %s is not in <upstream>=<mirror host>[/<path prefix>] form", line)
+		}
+		host, prefix := target, ""
+		if i := strings.Index(target, "/"); i >= 0 {
+			host, prefix = target[:i], target[i+1:]
+		}
+		mirrors = append(mirrors, mirror{upstream: normalizeHost(upstream), host: normalizeHost(host), prefix: prefix})
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+	m.mirrors = mirrors
+	return nil
+}
+
+// resolve returns the image references of the upstream registry and of the mirror. The image can be referenced by either of them.
+// Both are the same as the image, if no mirror matches it
+func (m *Mirrors) resolve(ref imageRef) (imageRef, imageRef) {
+	if m == nil {
+		return ref, ref
+	}
+
+	m.lock.Lock()
+	defer m.lock.Unlock()
+
+	host := normalizeHost(ref.host)
+	for _, mr := range m.mirrors {
+		// The image is referenced by the mirror
+		if host == mr.host && (mr.prefix == "" || strings.HasPrefix(ref.name, mr.prefix+"/")) {
+			upstream := ref
+			upstream.host = mr.upstream
+			if mr.prefix != "" {
+				upstream.name = strings.TrimPrefix(ref.name, mr.prefix+"/")
+			}
+			return upstream, ref
+		}
+		// The image is referenced by the upstream registry
+		if host == mr.upstream {
+			mirrored := ref
+			mirrored.host = mr.host
+			if mr.upstream == image.DefaultHostname && !strings.Contains(mirrored.name, "/") {
+				mirrored.name = "library/" + mirrored.name
+			}
+			if mr.prefix != "" {
+				mirrored.name = mr.prefix + "/" + mirrored.name
+			}
+			return ref, mirrored
+		}
+	}
+	return ref, ref
+}
+
+// normalizeHost returns docker.io for the hosts of docker hub, including the empty one
+func normalizeHost(host string) string {
+	switch host {
+	case "", image.LegacyDefaultDomain, image.DefaultServerHostName:
+		return image.DefaultHostname
+	}
+	return host
+}
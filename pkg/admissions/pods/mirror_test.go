@@ -0,0 +1,108 @@
+package pods
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type mirrorsUnmarshalTestCase struct {
+	list string
+
+	expectedMirrors []mirror
+	expectedErrMsg  string
+}
+
+func TestMirrors_Unmarshal(t *testing.T) {
+	tc := map[string]mirrorsUnmarshalTestCase{
+		"prefix": {
+			list:            "docker.io=harbor.domain.io/dockerhub-proxy/",
+			expectedMirrors: []mirror{{upstream: "docker.io", host: "harbor.domain.io", prefix: "dockerhub-proxy"}},
+		},
+		"noPrefix": {
+			list:            "\n quay.io = quay-mirror.domain.io \nindex.docker.io=mirror.gcr.io\n",
+			expectedMirrors: []mirror{{upstream: "quay.io", host: "quay-mirror.domain.io"}, {upstream: "docker.io", host: "mirror.gcr.io"}},
+		},
+		"empty": {
+			list: "",
+		},
+		"noMirror": {
+			list:           "docker.io",
+			expectedErrMsg: "mirror docker.io is not in <upstream>=<mirror host>[/<path prefix>] form",
+		},
+		"emptyUpstream": {
+			list:           "=harbor.domain.io",
+			expectedErrMsg: "mirror =harbor.domain.io is not in <upstream>=<mirror host>[/<path prefix>] form",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			m := &Mirrors{}
+			err := m.Unmarshal(c.list)
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedMirrors, m.mirrors)
+		})
+	}
+}
+
+type mirrorsResolveTestCase struct {
+	image string
+
+	expectedUpstream string
+	expectedMirrored string
+}
+
+func TestMirrors_Resolve(t *testing.T) {
+	m := &Mirrors{}
+	require.NoError(t, m.Unmarshal("docker.io=harbor.domain.io/dockerhub-proxy\nquay.io=quay-mirror.domain.io"))
+
+	tc := map[string]mirrorsResolveTestCase{
+		"dockerHubOfficial": {
+			image:            "alpine:3",
+			expectedUpstream: "alpine:3",
+			expectedMirrored: "harbor.domain.io/dockerhub-proxy/library/alpine:3",
+		},
+		"dockerHub": {
+			image:            "docker.io/tmax-cloud/alpine:3",
+			expectedUpstream: "docker.io/tmax-cloud/alpine:3",
+			expectedMirrored: "harbor.domain.io/dockerhub-proxy/tmax-cloud/alpine:3",
+		},
+		"mirror": {
+			image:            "harbor.domain.io/dockerhub-proxy/library/alpine:3@sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748",
+			expectedUpstream: "docker.io/library/alpine:3@sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748",
+			expectedMirrored: "harbor.domain.io/dockerhub-proxy/library/alpine:3@sha256:def822f9851ca422481ec6fee59a9966f12b351c62ccb9aca841526ffaa9f748",
+		},
+		"mirrorOtherProject": {
+			image:            "harbor.domain.io/project/alpine:3",
+			expectedUpstream: "harbor.domain.io/project/alpine:3",
+			expectedMirrored: "harbor.domain.io/project/alpine:3",
+		},
+		"mirrorNoPrefix": {
+			image:            "quay-mirror.domain.io/coreos/etcd:v3",
+			expectedUpstream: "quay.io/coreos/etcd:v3",
+			expectedMirrored: "quay-mirror.domain.io/coreos/etcd:v3",
+		},
+		"noMirror": {
+			image:            "gcr.io/distroless/static:nonroot",
+			expectedUpstream: "gcr.io/distroless/static:nonroot",
+			expectedMirrored: "gcr.io/distroless/static:nonroot",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			ref, err := parseImage(c.image)
+			require.NoError(t, err)
+
+			upstream, mirrored := m.resolve(*ref)
+			require.Equal(t, c.expectedUpstream, upstream.String())
+			require.Equal(t, c.expectedMirrored, mirrored.String())
+		})
+	}
+}
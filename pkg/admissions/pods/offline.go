@@ -0,0 +1,129 @@
+package pods
+
+import (
+	"fmt"
+
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/rest"
+)
+
+// NewValidator creates a validator, which watches the policies, the whitelist, the mirrors and the keys of the cluster.
+// The registries and the notary servers verify the signatures if the verifier is nil
+func NewValidator(cfg *rest.Config, clientSet kubernetes.Interface, verifier Verifier) (Validator, error) {
+	v, err := newValidator(cfg, clientSet, clientSet.CoreV1().RESTClient())
+	if err != nil {
+		return nil, err
+	}
+	v.verifier = verifier
+	return v, nil
+}
+
+// NewOfflineValidator creates a validator, which reads the policies, the whitelist, the mirrors and the keys from the objects instead of the cluster.
+// The objects are RegistrySecurityPolicies, ClusterRegistrySecurityPolicies, CosignKeySets, ConfigMaps, Secrets and ServiceAccounts, and the others are ignored.
+// Namespaced objects without namespaces are in the default namespace, except the config maps of the whitelist, the mirrors and
+// the global pull secrets, which are in registry-system namespace
+func NewOfflineValidator(objs []runtime.Object, defaultNamespace string, verifier Verifier) (Validator, error) {
+	var policies, clusterPolicies, keySets, configMaps, secrets, serviceAccounts []runtime.Object
+	var whitelistCM, mirrorCM *corev1.ConfigMap
+	for _, obj := range objs {
+		switch o := obj.(type) {
+		case *whv1.RegistrySecurityPolicy:
+			if o.Namespace == "" {
+				o = o.DeepCopy()
+				o.Namespace = defaultNamespace
+			}
+			policies = append(policies, o)
+		case *whv1.ClusterRegistrySecurityPolicy:
+			clusterPolicies = append(clusterPolicies, o)
+		case *whv1.CosignKeySet:
+			if o.Namespace == "" {
+				o = o.DeepCopy()
+				o.Namespace = defaultNamespace
+			}
+			keySets = append(keySets, o)
+		case *corev1.ConfigMap:
+			if o.Namespace == "" {
+				o = o.DeepCopy()
+				o.Namespace = defaultNamespace
+				if isWebhookConfigMap(o.Name) {
+					o.Namespace = registryNamespace
+				}
+			}
+			if o.Namespace == registryNamespace && o.Name == whitelistConfigMap {
+				whitelistCM = o
+			}
+			if o.Namespace == registryNamespace && o.Name == mirrorConfigMap {
+				mirrorCM = o
+			}
+			configMaps = append(configMaps, o)
+		case *corev1.Secret:
+			if o.Namespace == "" {
+				o = o.DeepCopy()
+				o.Namespace = defaultNamespace
+			}
+			secrets = append(secrets, o)
+		case *corev1.ServiceAccount:
+			if o.Namespace == "" {
+				o = o.DeepCopy()
+				o.Namespace = defaultNamespace
+			}
+			serviceAccounts = append(serviceAccounts, o)
+		}
+	}
+
+	var cachedClients []watcher.CachedClient
+	for _, resourceObjs := range [][]runtime.Object{policies, clusterPolicies, keySets, configMaps, secrets, serviceAccounts} {
+		cachedClient, err := watcher.NewStaticCachedClient(resourceObjs...)
+		if err != nil {
+			return nil, err
+		}
+		cachedClients = append(cachedClients, cachedClient)
+	}
+
+	// The whitelist updates its config map with the client
+	client := fake.NewSimpleClientset(configMaps...)
+
+	v := &validator{
+		registryPolicyCache: &RegistryPolicyCache{
+			namespaceCachedClient: cachedClients[0],
+			clusterCachedClient:   cachedClients[1],
+			keySetCachedClient:    cachedClients[2],
+		},
+		whiteList: &WhiteList{clientSet: client},
+		mirrors:   &Mirrors{},
+		keyCache: &keyCache{
+			offlineClients: map[corev1.ResourceName]watcher.CachedClient{
+				corev1.ResourceConfigMaps: cachedClients[3],
+				corev1.ResourceSecrets:    cachedClients[4],
+				resourceServiceAccounts:   cachedClients[5],
+			},
+			cachedClients: map[string]*watchedObject{},
+			parsedKeys:    map[string]parsedKeys{},
+			certPools:     map[string]parsedCertPool{},
+			rekorKeys:     map[string]parsedRekorKeys{},
+		},
+		verifier: verifier,
+	}
+
+	if whitelistCM != nil {
+		if err := v.whiteList.ParseOrUpdateWhiteList(whitelistCM); err != nil {
+			return nil, fmt.Errorf("couldn't parse whitelist by %s", err)
+		}
+	}
+	if mirrorCM != nil {
+		if err := v.mirrors.Unmarshal(mirrorCM.Data[mirrorsKey]); err != nil {
+			return nil, fmt.Errorf("couldn't parse mirrors by %s", err)
+		}
+	}
+	return v, nil
+}
+
+// isWebhookConfigMap checks if the config map is the one of the webhook, i.e., the whitelist, the mirrors or the global pull secrets
+func isWebhookConfigMap(name string) bool {
+	return name == whitelistConfigMap || name == mirrorConfigMap || name == pullSecretConfigMap
+}
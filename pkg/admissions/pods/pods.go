@@ -5,6 +5,9 @@ import (
 	"fmt"
 	"io/ioutil"
 	"net/http"
+	"sort"
+	"strings"
+	"sync"
 
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
@@ -18,6 +21,9 @@ import (
 
 const (
 	registryNamespace = "registry-system"
+
+	// cosignKeysAnnotation is an audit annotation key of the cosign keys which verified the images
+	cosignKeysAnnotation = "cosign-keys"
 )
 
 var (
@@ -25,18 +31,40 @@ var (
 )
 
 func init() {
-	// Add validating-mutating-admission handler initiator
-	server.AddHandlerInitiator("/validate", []string{http.MethodPost}, NewPodsAdmissionHandler)
+	// Add mutating admission handler initiator, which pins the images by their digests
+	server.AddHandlerInitiator("/mutate", []string{http.MethodPost}, NewPodsMutatingHandler)
+	// Add validating admission handler initiator, which checks the final pod after all the mutations
+	server.AddHandlerInitiator("/validate", []string{http.MethodPost}, NewPodsValidatingHandler)
 }
 
-// ImageAdmission is ...
+// ImageAdmission handles the admission reviews of pods, either by pinning the images by their digests or by validating them
 type ImageAdmission struct {
 	validator Validator
+
+	// mutating is true if the images are pinned by the patch. Otherwise, pods whose images are not pinned are rejected
+	mutating bool
+}
+
+// shared validator of the handlers, so that the caches are not duplicated
+var (
+	sharedValidator     *validator
+	sharedValidatorErr  error
+	sharedValidatorOnce sync.Once
+)
+
+// NewPodsMutatingHandler initiates a new image digest pinning admission handler
+func NewPodsMutatingHandler(cfg *server.HandlerConfig) (http.Handler, error) {
+	v, err := getValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return &ImageAdmission{validator: v, mutating: true}, nil
 }
 
-// NewPodsAdmissionHandler initiates a new image validation admission handler
-func NewPodsAdmissionHandler(cfg *server.HandlerConfig) (http.Handler, error) {
-	v, err := newValidator(cfg.RestCfg, cfg.ClientSet, cfg.RestClient)
+// NewPodsValidatingHandler initiates a new image validation admission handler
+func NewPodsValidatingHandler(cfg *server.HandlerConfig) (http.Handler, error) {
+	v, err := getValidator(cfg)
 	if err != nil {
 		return nil, err
 	}
@@ -44,6 +72,14 @@ func NewPodsAdmissionHandler(cfg *server.HandlerConfig) (http.Handler, error) {
 	return &ImageAdmission{validator: v}, nil
 }
 
+// getValidator returns the validator shared by the handlers, which is initiated at the first call
+func getValidator(cfg *server.HandlerConfig) (*validator, error) {
+	sharedValidatorOnce.Do(func() {
+		sharedValidator, sharedValidatorErr = newValidator(cfg.RestCfg, cfg.ClientSet, cfg.RestClient)
+	})
+	return sharedValidator, sharedValidatorErr
+}
+
 func (a *ImageAdmission) ServeHTTP(w http.ResponseWriter, req *http.Request) {
 	body, err := ioutil.ReadAll(req.Body)
 	if err != nil {
@@ -97,16 +133,59 @@ func (a *ImageAdmission) HandleAdmission(review *admissionv1beta1.AdmissionRevie
 	infoMsg := fmt.Sprintf("Start to handle review of pod %s(%s) in %s", pod.Name, pod.GenerateName, pod.Namespace)
 	plog.Info(infoMsg)
 
+	// Only the containers whose images are added or changed are validated for the updates, including the ones of the
+	// ephemeralcontainers subresource, through which ephemeral containers are added to the running pod, e.g., by kubectl debug
+	target := pod
+	if review.Request.Operation == admissionv1beta1.Update {
+		oldPod := &core.Pod{}
+		if err := json.Unmarshal(review.Request.OldObject.Raw, oldPod); err != nil {
+			errMsg := fmt.Sprintf("unmarshaling old object of request failed with %s", err)
+			plog.Error(err, errMsg)
+			setReviewResponseNotAllowed(review, fmt.Sprintf("Internal webhook server error: %s", err))
+			return err
+		}
+
+		if reasons := droppedDigests(pod, oldPod); len(reasons) > 0 {
+			plog.Info("Pod is invalid")
+			setReviewResponseNotAllowed(review, fmt.Sprintf("Pod is not valid: \n%s", strings.Join(reasons, "\n")))
+			return nil
+		}
+
+		target = changedContainers(pod, oldPod)
+		if len(target.Spec.InitContainers)+len(target.Spec.Containers)+len(target.Spec.EphemeralContainers) == 0 {
+			plog.Info("Pod is valid, as no image is changed")
+			review.Response = &admissionv1beta1.AdmissionResponse{
+				Allowed: true,
+				Result:  &metav1.Status{},
+			}
+			return nil
+		}
+	}
+
 	// Validate image signers
-	isValid, invalidReason, err := a.validator.CheckIsValidAndAddDigest(pod)
+	original := pod.DeepCopy()
+	result, err := a.validator.CheckIsValidAndAddDigest(target)
 	if err != nil {
 		errMsg := fmt.Sprintf("Error while validating images by %s", err)
 		plog.Error(err, errMsg)
 		setReviewResponseNotAllowed(review, fmt.Sprintf("Internal webhook server error: %s", err))
 		return err
-	} else if isValid {
+	} else if result.Valid {
+		if target != pod {
+			setChangedContainerImages(pod, target)
+		}
+
+		// The final pod should have been pinned by the mutating webhook, including the containers injected by the others
+		if !a.mutating {
+			if reasons := unpinnedImages(original, pod); len(reasons) > 0 {
+				plog.Info("Pod is invalid")
+				setReviewResponseNotAllowed(review, fmt.Sprintf("Pod is not valid: \n%s", strings.Join(reasons, "\n")))
+				return nil
+			}
+		}
+
 		plog.Info("Pod is valid")
-		patch, err := createPatch(pod)
+		patch, err := createPatch(original, pod)
 		if err != nil {
 			errMsg := fmt.Sprintf("Couldn't make patched pod by %s", err)
 			plog.Error(err, errMsg)
@@ -114,21 +193,140 @@ func (a *ImageAdmission) HandleAdmission(review *admissionv1beta1.AdmissionRevie
 			return err
 		}
 
-		patchType := admissionv1beta1.PatchTypeJSONPatch
 		review.Response = &admissionv1beta1.AdmissionResponse{
-			Allowed:   true,
-			Result:    &metav1.Status{},
-			Patch:     patch,
-			PatchType: &patchType,
+			Allowed: true,
+			Result:  &metav1.Status{},
+		}
+		if patch != nil {
+			patchType := admissionv1beta1.PatchTypeJSONPatch
+			review.Response.Patch = patch
+			review.Response.PatchType = &patchType
+		}
+
+		// Report the cosign keys which verified the images
+		if len(result.KeyIDs) > 0 {
+			review.Response.AuditAnnotations = map[string]string{cosignKeysAnnotation: formatKeyIDs(result.KeyIDs)}
 		}
 	} else {
 		plog.Info("Pod is invalid")
-		setReviewResponseNotAllowed(review, fmt.Sprintf("Pod is not valid: \n%s", invalidReason))
+		setReviewResponseNotAllowed(review, fmt.Sprintf("Pod is not valid: \n%s", result.Reason))
 	}
 
 	return nil
 }
 
+// unpinnedImages returns the reasons for the images which are not pinned by their digests, i.e., the ones the validator pinned
+func unpinnedImages(original, pinned *core.Pod) []string {
+	var reasons []string
+	check := func(originalContainers, pinnedContainers []core.Container) {
+		for i := range originalContainers {
+			if originalContainers[i].Image != pinnedContainers[i].Image {
+				reasons = append(reasons, fmt.Sprintf("image '%s' is not pinned by its digest", originalContainers[i].Image))
+			}
+		}
+	}
+	check(original.Spec.InitContainers, pinned.Spec.InitContainers)
+	check(original.Spec.Containers, pinned.Spec.Containers)
+	check(ephemeralContainersOf(original), ephemeralContainersOf(pinned))
+	return reasons
+}
+
+// changedContainers returns a copy of the pod, which has only the containers whose images are added or changed from the old pod.
+// Containers are identified by their names, which are unique in the pod
+func changedContainers(pod, oldPod *core.Pod) *core.Pod {
+	changed := pod.DeepCopy()
+	changed.Spec.InitContainers = nil
+	changed.Spec.Containers = nil
+	changed.Spec.EphemeralContainers = nil
+
+	oldImages := containerImages(oldPod.Spec.InitContainers)
+	for _, c := range pod.Spec.InitContainers {
+		if image, exist := oldImages[c.Name]; !exist || image != c.Image {
+			changed.Spec.InitContainers = append(changed.Spec.InitContainers, *c.DeepCopy())
+		}
+	}
+	oldImages = containerImages(oldPod.Spec.Containers)
+	for _, c := range pod.Spec.Containers {
+		if image, exist := oldImages[c.Name]; !exist || image != c.Image {
+			changed.Spec.Containers = append(changed.Spec.Containers, *c.DeepCopy())
+		}
+	}
+	oldImages = containerImages(ephemeralContainersOf(oldPod))
+	for _, c := range pod.Spec.EphemeralContainers {
+		if image, exist := oldImages[c.Name]; !exist || image != c.Image {
+			changed.Spec.EphemeralContainers = append(changed.Spec.EphemeralContainers, *c.DeepCopy())
+		}
+	}
+	return changed
+}
+
+// setChangedContainerImages sets the images of the changed containers, which may be pinned by their digests, back to the pod
+func setChangedContainerImages(pod, changed *core.Pod) {
+	images := containerImages(changed.Spec.InitContainers)
+	for i, c := range pod.Spec.InitContainers {
+		if image, exist := images[c.Name]; exist {
+			pod.Spec.InitContainers[i].Image = image
+		}
+	}
+	images = containerImages(changed.Spec.Containers)
+	for i, c := range pod.Spec.Containers {
+		if image, exist := images[c.Name]; exist {
+			pod.Spec.Containers[i].Image = image
+		}
+	}
+	images = containerImages(ephemeralContainersOf(changed))
+	for i, c := range pod.Spec.EphemeralContainers {
+		if image, exist := images[c.Name]; exist {
+			pod.Spec.EphemeralContainers[i].Image = image
+		}
+	}
+}
+
+// droppedDigests returns the reasons for the containers whose images were pinned by digests but are changed to ones without digests.
+// Such updates are rejected, as they would replace the verified images with whatever the tags point to
+func droppedDigests(pod, oldPod *core.Pod) []string {
+	var reasons []string
+	check := func(containers, oldContainers []core.Container) {
+		oldImages := containerImages(oldContainers)
+		for _, c := range containers {
+			oldImage, exist := oldImages[c.Name]
+			if !exist || oldImage == c.Image {
+				continue
+			}
+			oldRef, err := parseImage(oldImage)
+			if err != nil || oldRef.digest == "" {
+				continue
+			}
+			if ref, err := parseImage(c.Image); err != nil || ref.digest == "" {
+				reasons = append(reasons, fmt.Sprintf("image '%s' of container '%s' drops the digest of the pinned image '%s'", c.Image, c.Name, oldImage))
+			}
+		}
+	}
+	check(pod.Spec.InitContainers, oldPod.Spec.InitContainers)
+	check(pod.Spec.Containers, oldPod.Spec.Containers)
+	check(ephemeralContainersOf(pod), ephemeralContainersOf(oldPod))
+	return reasons
+}
+
+// containerImages returns the images of the containers by their names
+func containerImages(containers []core.Container) map[string]string {
+	images := map[string]string{}
+	for _, c := range containers {
+		images[c.Name] = c.Image
+	}
+	return images
+}
+
+// formatKeyIDs formats the key IDs as <image>=<key ID>, separated by commas and sorted by the images
+func formatKeyIDs(keyIDs map[string]string) string {
+	var pairs []string
+	for image, keyID := range keyIDs {
+		pairs = append(pairs, fmt.Sprintf("%s=%s", image, keyID))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
+
 func setReviewResponseNotAllowed(review *admissionv1beta1.AdmissionReview, message string) {
 	review.Response = &admissionv1beta1.AdmissionResponse{
 		Allowed: false,
@@ -155,26 +353,36 @@ type patchOperation struct {
 	Value interface{} `json:"value,omitempty"`
 }
 
-func createPatch(patchPod *core.Pod) ([]byte, error) {
-	if patchPod == nil {
+// createPatch creates a JSON patch, which replaces only the images changed from the original pod, e.g., pinned by their digests.
+// Other fields of the containers are not touched, as they may be set by other mutating webhooks. It is nil if no image is changed
+func createPatch(original, patched *core.Pod) ([]byte, error) {
+	if original == nil || patched == nil {
 		return nil, fmt.Errorf("couldn't create patch")
 	}
 
-	patch := []patchOperation{
-		{
-			Op:    "replace",
-			Path:  "/spec/containers",
-			Value: patchPod.Spec.Containers,
-		},
+	var patch []patchOperation
+	patch = append(patch, imagePatch("/spec/initContainers", original.Spec.InitContainers, patched.Spec.InitContainers)...)
+	patch = append(patch, imagePatch("/spec/containers", original.Spec.Containers, patched.Spec.Containers)...)
+	patch = append(patch, imagePatch("/spec/ephemeralContainers", ephemeralContainersOf(original), ephemeralContainersOf(patched))...)
+	if len(patch) == 0 {
+		return nil, nil
 	}
 
-	if len(patchPod.Spec.InitContainers) > 0 {
+	return json.Marshal(&patch)
+}
+
+// imagePatch creates the operations replacing the images of the containers at the path, which are changed from the original ones
+func imagePatch(path string, original, patched []core.Container) []patchOperation {
+	var patch []patchOperation
+	for i := range patched {
+		if i < len(original) && original[i].Image == patched[i].Image {
+			continue
+		}
 		patch = append(patch, patchOperation{
 			Op:    "replace",
-			Path:  "/spec/initContainers",
-			Value: patchPod.Spec.InitContainers,
+			Path:  fmt.Sprintf("%s/%d/image", path, i),
+			Value: patched[i].Image,
 		})
 	}
-
-	return json.Marshal(&patch)
+	return patch
 }
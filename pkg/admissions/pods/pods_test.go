@@ -2,7 +2,10 @@ package pods
 
 import (
 	"encoding/json"
+	"flag"
 	"fmt"
+	"io/ioutil"
+	"path/filepath"
 	"strings"
 	"testing"
 
@@ -21,8 +24,15 @@ type imageAdmissionHandlerTestCase struct {
 	gvr      metav1.GroupVersionResource
 	resource runtime.Object
 
-	expectedAllowed       bool
-	expectedResultMessage string
+	operation   admissionv1beta1.Operation
+	subResource string
+	oldResource runtime.Object
+	validating  bool
+
+	expectedAllowed          bool
+	expectedResultMessage    string
+	expectedAuditAnnotations map[string]string
+	expectedPatch            string
 }
 
 func TestImageAdmission_HandleAdmission(t *testing.T) {
@@ -54,11 +64,125 @@ func TestImageAdmission_HandleAdmission(t *testing.T) {
 			},
 			expectedAllowed: true,
 		},
+		"podSignedWithCosign": {
+			gvk: metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr: metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource: &corev1.Pod{
+				ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "testns"},
+				Spec: corev1.PodSpec{
+					InitContainers: []corev1.Container{
+						{Name: "test-init", Image: "test-cosign-init:test"},
+					},
+					Containers: []corev1.Container{
+						{Name: "test-cont", Image: "test-cosign:test"},
+					},
+				},
+			},
+			expectedAllowed:          true,
+			expectedAuditAnnotations: map[string]string{"cosign-keys": "test-cosign-init:test=testns/keys/test-key,test-cosign:test=testns/keys/test-key"},
+		},
+		"ephemeralContainerNotSigned": {
+			gvk:         metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:         metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:    testEphemeralContainersPod("test-not-signed:debug"),
+			operation:   admissionv1beta1.Update,
+			subResource: "ephemeralcontainers",
+			oldResource: testEphemeralContainersPod(),
+
+			expectedAllowed:       false,
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:debug' is not signed",
+		},
+		"ephemeralContainerSigned": {
+			gvk:         metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:         metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:    testEphemeralContainersPod("test-not-signed:old", "test-pinned:debug"),
+			operation:   admissionv1beta1.Update,
+			subResource: "ephemeralcontainers",
+			oldResource: testEphemeralContainersPod("test-not-signed:old"),
+
+			expectedAllowed: true,
+			expectedPatch:   `[{"op":"replace","path":"/spec/ephemeralContainers/1/image","value":"test-pinned:debug@sha256:1111"}]`,
+		},
+		"updateImageNotSigned": {
+			gvk:         metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:         metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:    testUpdatedPod("test-signed:init", "test-not-signed:new"),
+			operation:   admissionv1beta1.Update,
+			oldResource: testUpdatedPod("test-signed:init", "test-signed:old"),
+
+			expectedAllowed:       false,
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:new' is not signed",
+		},
+		"updateTwoImagesSecondNotSigned": {
+			gvk:         metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:         metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:    testUpdatedPod("test-pinned:new", "test-not-signed:new"),
+			operation:   admissionv1beta1.Update,
+			oldResource: testUpdatedPod("test-signed:init", "test-signed:old"),
+
+			expectedAllowed:       false,
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:new' is not signed",
+		},
+		"updateImageSigned": {
+			gvk:         metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:         metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:    testUpdatedPod("test-not-signed:init", "test-pinned:new"),
+			operation:   admissionv1beta1.Update,
+			oldResource: testUpdatedPod("test-not-signed:init", "test-signed:old"),
+
+			expectedAllowed: true,
+			expectedPatch:   `[{"op":"replace","path":"/spec/containers/0/image","value":"test-pinned:new@sha256:1111"}]`,
+		},
+		"updateImageUnchanged": {
+			gvk:         metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:         metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:    testUpdatedPod("test-not-signed:init", "test-not-signed:old"),
+			operation:   admissionv1beta1.Update,
+			oldResource: testUpdatedPod("test-not-signed:init", "test-not-signed:old"),
+
+			expectedAllowed: true,
+		},
+		"updateImageDigestDropped": {
+			gvk:         metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:         metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:    testUpdatedPod("test-signed:init", "test-signed:new"),
+			operation:   admissionv1beta1.Update,
+			oldResource: testUpdatedPod("test-signed:init", "test-signed:old@sha256:1111"),
+
+			expectedAllowed:       false,
+			expectedResultMessage: "Pod is not valid: \nimage 'test-signed:new' of container 'test-cont' drops the digest of the pinned image 'test-signed:old@sha256:1111'",
+		},
+		"validatePinned": {
+			gvk:        metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:        metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:   testUpdatedPod("test-signed:init", "test-pinned:test@sha256:1111"),
+			validating: true,
+
+			expectedAllowed: true,
+		},
+		"validateNotPinned": {
+			gvk:        metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:        metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:   testUpdatedPod("test-pinned:init", "test-pinned:test@sha256:1111"),
+			validating: true,
+
+			expectedAllowed:       false,
+			expectedResultMessage: "Pod is not valid: \nimage 'test-pinned:init' is not pinned by its digest",
+		},
+		"validateNotSigned": {
+			gvk:        metav1.GroupVersionKind{Group: "", Version: "v1", Kind: "Pod"},
+			gvr:        metav1.GroupVersionResource{Group: "", Version: "v1", Resource: "pods"},
+			resource:   testUpdatedPod("test-signed:init", "test-not-signed:test@sha256:1111"),
+			validating: true,
+
+			expectedAllowed:       false,
+			expectedResultMessage: "Pod is not valid: \nimage 'test-not-signed:test@sha256:1111' is not signed",
+		},
 	}
 
 	for name, c := range tc {
 		t.Run(name, func(t *testing.T) {
-			im := &ImageAdmission{validator: &dummyValidator{}}
+			im := &ImageAdmission{validator: &dummyValidator{}, mutating: !c.validating}
 
 			metaObj, err := meta.Accessor(c.resource)
 			require.NoError(t, err)
@@ -73,33 +197,211 @@ func TestImageAdmission_HandleAdmission(t *testing.T) {
 					RequestResource: &c.gvr,
 					Name:            metaObj.GetName(),
 					Namespace:       metaObj.GetNamespace(),
-					Operation:       admissionv1beta1.Create,
+					SubResource:     c.subResource,
+					Operation:       c.operation,
 					UserInfo:        authenticationv1.UserInfo{Username: "test-user"},
 					Object:          runtime.RawExtension{Object: c.resource},
 				},
 			}
+			if review.Request.Operation == "" {
+				review.Request.Operation = admissionv1beta1.Create
+			}
 			review.Request.Object.Raw, err = json.Marshal(c.resource)
 			require.NoError(t, err)
+			if c.oldResource != nil {
+				review.Request.OldObject.Raw, err = json.Marshal(c.oldResource)
+				require.NoError(t, err)
+			}
 
 			require.NoError(t, im.HandleAdmission(review))
 			require.Equal(t, review.Response.Allowed, c.expectedAllowed)
 			require.Equal(t, review.Response.Result.Message, c.expectedResultMessage)
+			require.Equal(t, c.expectedAuditAnnotations, review.Response.AuditAnnotations)
+			if c.expectedPatch != "" {
+				require.Equal(t, c.expectedPatch, string(review.Response.Patch))
+			}
+		})
+	}
+}
+
+// testUpdatedPod returns a pod, which has an init container and a container of the images
+func testUpdatedPod(initImage, image string) *corev1.Pod {
+	return &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "testns"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "test-init", Image: initImage},
+			},
+			Containers: []corev1.Container{
+				{Name: "test-cont", Image: image},
+			},
+		},
+	}
+}
+
+// testEphemeralContainersPod returns a running pod, which has the ephemeral containers of the images
+func testEphemeralContainersPod(images ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "testns"},
+		Spec: corev1.PodSpec{
+			Containers: []corev1.Container{
+				{Name: "test-cont", Image: "test-signed:test"},
+			},
+		},
+	}
+	for i, image := range images {
+		pod.Spec.EphemeralContainers = append(pod.Spec.EphemeralContainers, corev1.EphemeralContainer{
+			EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: fmt.Sprintf("debug-%d", i), Image: image},
 		})
 	}
+	return pod
 }
 
+// dummyValidator rejects test-not-signed images, fails on test-error images and pins test-pinned images without digests by a
+// dummy digest. test-moving images are pinned by another digest each time, as if their tags are moved. Decisions are recorded
+// for the images it reaches
 type dummyValidator struct{}
 
-func (d *dummyValidator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, error) {
-	var containers []corev1.Container
-	containers = append(containers, pod.Spec.InitContainers...)
-	containers = append(containers, pod.Spec.Containers...)
+func (d *dummyValidator) CheckIsValidAndAddDigest(pod *corev1.Pod) (*Result, error) {
+	var images []*string
+	for i := range pod.Spec.InitContainers {
+		images = append(images, &pod.Spec.InitContainers[i].Image)
+	}
+	for i := range pod.Spec.Containers {
+		images = append(images, &pod.Spec.Containers[i].Image)
+	}
+	for i := range pod.Spec.EphemeralContainers {
+		images = append(images, &pod.Spec.EphemeralContainers[i].Image)
+	}
 
-	for _, c := range containers {
-		if strings.HasPrefix(c.Image, "test-not-signed") {
-			return false, fmt.Sprintf("image '%s' is not signed", c.Image), nil
+	keyIDs := map[string]string{}
+	decisions := map[string]*ImageDecision{}
+	for _, image := range images {
+		decision := decisionOf(decisions, *image)
+		decision.PolicyMatched = true
+		if strings.HasPrefix(*image, "test-error") {
+			return nil, fmt.Errorf("image '%s' cannot be validated", *image)
+		}
+		if strings.HasPrefix(*image, "test-not-signed") {
+			reason := fmt.Sprintf("image '%s' is not signed", *image)
+			decision.Cosign = &VerifierResult{Reason: reason}
+			decision.Reason = reason
+			return &Result{Valid: false, Reason: reason, Decisions: decisions}, nil
 		}
+		if strings.HasPrefix(*image, "test-cosign") {
+			keyIDs[*image] = pod.Namespace + "/keys/test-key"
+			decision.Cosign = &VerifierResult{Verified: true, KeyID: keyIDs[*image]}
+		}
+		if strings.HasPrefix(*image, "test-pinned") && !strings.Contains(*image, "@") {
+			decision.Notary = &VerifierResult{Verified: true}
+			decision.Digest = "sha256:1111"
+			*image += "@sha256:1111"
+		}
+		if strings.HasPrefix(*image, "test-moving") {
+			digest := "sha256:2222"
+			if i := strings.Index(*image, "@"); i >= 0 {
+				*image, digest = (*image)[:i], "sha256:3333"
+			}
+			decision.Digest = digest
+			*image += "@" + digest
+		}
+	}
+
+	for _, decision := range decisions {
+		decision.Valid = true
 	}
+	return &Result{Valid: true, KeyIDs: keyIDs, Decisions: decisions}, nil
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files of the tests")
+
+type createPatchTestCase struct {
+	original *corev1.Pod
+	pin      map[string]string
 
-	return true, "", nil
+	expectedErrOccur bool
+}
+
+func TestCreatePatch(t *testing.T) {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "testns"},
+		Spec: corev1.PodSpec{
+			InitContainers: []corev1.Container{
+				{Name: "init-0", Image: "registry.io/init:0"},
+			},
+			Containers: []corev1.Container{
+				{Name: "cont-0", Image: "registry.io/app:0", Env: []corev1.EnvVar{{Name: "SIDECAR", Value: "injected"}}},
+				{Name: "cont-1", Image: "registry.io/app:1"},
+				{Name: "cont-2", Image: "registry.io/app@sha256:2222"},
+			},
+			EphemeralContainers: []corev1.EphemeralContainer{
+				{EphemeralContainerCommon: corev1.EphemeralContainerCommon{Name: "debug-0", Image: "registry.io/debug:0"}},
+			},
+		},
+	}
+
+	tc := map[string]createPatchTestCase{
+		"noChange": {
+			original: pod,
+		},
+		"container": {
+			original: pod,
+			pin:      map[string]string{"cont-1": "registry.io/app:1@sha256:1111"},
+		},
+		"allContainers": {
+			original: pod,
+			pin: map[string]string{
+				"init-0":  "registry.io/init:0@sha256:0000",
+				"cont-0":  "registry.io/app:0@sha256:0000",
+				"cont-1":  "registry.io/app:1@sha256:1111",
+				"debug-0": "registry.io/debug:0@sha256:0000",
+			},
+		},
+		"ephemeralContainer": {
+			original: pod,
+			pin:      map[string]string{"debug-0": "registry.io/debug:0@sha256:0000"},
+		},
+		"nilPod": {
+			expectedErrOccur: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			var patched *corev1.Pod
+			if c.original != nil {
+				patched = c.original.DeepCopy()
+				for i, cont := range patched.Spec.InitContainers {
+					if image, ok := c.pin[cont.Name]; ok {
+						patched.Spec.InitContainers[i].Image = image
+					}
+				}
+				for i, cont := range patched.Spec.Containers {
+					if image, ok := c.pin[cont.Name]; ok {
+						patched.Spec.Containers[i].Image = image
+					}
+				}
+				for i, cont := range patched.Spec.EphemeralContainers {
+					if image, ok := c.pin[cont.Name]; ok {
+						patched.Spec.EphemeralContainers[i].Image = image
+					}
+				}
+			}
+
+			patch, err := createPatch(c.original, patched)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+
+			golden := filepath.Join("testdata", "patches", name+".json")
+			if *updateGolden {
+				require.NoError(t, ioutil.WriteFile(golden, append(patch, '\n'), 0644))
+			}
+			expected, err := ioutil.ReadFile(golden)
+			require.NoError(t, err)
+			require.Equal(t, strings.TrimSuffix(string(expected), "\n"), string(patch))
+		})
+	}
 }
@@ -4,19 +4,29 @@ import (
 	"fmt"
 
 	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
+	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/types"
 	"k8s.io/client-go/rest"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
+const (
+	// resourceCosignKeySets is a resource name of the cosign key sets
+	resourceCosignKeySets corev1.ResourceName = "cosignkeysets"
+)
+
 // RegistryPolicyCache is a cache of type.RegistrySecurityPolicy
 type RegistryPolicyCache struct {
 	restClient rest.Interface
 
 	clusterCachedClient   watcher.CachedClient
 	namespaceCachedClient watcher.CachedClient
+	keySetCachedClient    watcher.CachedClient
 }
 
 var (
@@ -33,23 +43,28 @@ func newRegistryPolicyCache(cfg *rest.Config, restClient rest.Interface) (*Regis
 	// Initiate watcher
 	nw := watcher.New("", "registrysecuritypolicies", &whv1.RegistrySecurityPolicy{}, watchCli, fields.Everything())
 	cw := watcher.New("", "clusterregistrysecuritypolicies", &whv1.ClusterRegistrySecurityPolicy{}, watchCli, fields.Everything())
+	kw := watcher.New("", string(resourceCosignKeySets), &whv1.CosignKeySet{}, watchCli, fields.Everything())
 
 	p := &RegistryPolicyCache{
 		restClient:            restClient,
 		clusterCachedClient:   watcher.NewCachedClient(cw),
 		namespaceCachedClient: watcher.NewCachedClient(nw),
+		keySetCachedClient:    watcher.NewCachedClient(kw),
 	}
 
 	waitChCluster := make(chan struct{})
 	waitChNamespace := make(chan struct{})
+	waitChKeySet := make(chan struct{})
 
-	// Start to watch RegistrySecurityPolicy
+	// Start to watch RegistrySecurityPolicy and CosignKeySet
 	go cw.Start(waitChCluster)
 	go nw.Start(waitChNamespace)
+	go kw.Start(waitChKeySet)
 
 	// Block until it's ready
 	<-waitChCluster
 	<-waitChNamespace
+	<-waitChKeySet
 
 	return p, nil
 }
@@ -93,3 +108,20 @@ func (c *RegistryPolicyCache) doesMatchPolicy(registry string, namespace string)
 
 	return false, whv1.RegistrySpec{}
 }
+
+// getKeySet gets the CosignKeySet of the reference, formatted as k8s://<namespace>/<name>, from the cache
+func (c *RegistryPolicyCache) getKeySet(ref string) (*whv1.CosignKeySet, error) {
+	namespace, name, err := cosigns.ParseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+
+	keySet := &whv1.CosignKeySet{}
+	if err := c.keySetCachedClient.Get(types.NamespacedName{Namespace: namespace, Name: name}, keySet); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, &missingKeyObjectError{resource: resourceCosignKeySets, namespace: namespace, name: name}
+		}
+		return nil, fmt.Errorf("couldn't get cosign key set %s/%s by %s", namespace, name, err)
+	}
+	return keySet, nil
+}
@@ -8,6 +8,7 @@ import (
 
 	"github.com/stretchr/testify/require"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
@@ -99,6 +100,59 @@ func TestRegistryPolicyCache_doesMatchPolicy(t *testing.T) {
 	}
 }
 
+type getKeySetTestCase struct {
+	ref string
+
+	expectedErrOccur bool
+	expectedErrMsg   string
+	expectedReason   string
+}
+
+func TestRegistryPolicyCache_GetKeySet(t *testing.T) {
+	keySetCachedClient, err := watcher.NewStaticCachedClient(&whv1.CosignKeySet{ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "key-set"}})
+	require.NoError(t, err)
+	cache := RegistryPolicyCache{keySetCachedClient: keySetCachedClient}
+
+	tc := map[string]getKeySetTestCase{
+		"keySet": {
+			ref: "k8s://test/key-set",
+		},
+		"missingKeySet": {
+			ref:              "k8s://test/missing",
+			expectedErrOccur: true,
+			expectedErrMsg:   "cosignkeysets test/missing referenced by the registry security policy does not exist",
+			expectedReason:   "Cosign: Image 'test-image' cannot be verified: cosignkeysets test/missing referenced by the registry security policy does not exist",
+		},
+		"invalidRef": {
+			ref:              "k8s://missing",
+			expectedErrOccur: true,
+			expectedErrMsg:   "Cosign: kubernetes specification should be in the format k8s://<namespace>/<secret>",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			keySet, err := cache.getKeySet(c.ref)
+			if !c.expectedErrOccur {
+				require.NoError(t, err)
+				require.Equal(t, "key-set", keySet.Name)
+				return
+			}
+			require.Error(t, err)
+			require.Equal(t, c.expectedErrMsg, err.Error())
+
+			// Missing key sets deny the image, as the missing secrets do
+			_, reason, err := policyError("Cosign", "test-image", err)
+			if c.expectedReason == "" {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedReason, reason)
+		})
+	}
+}
+
 func testPolicyRestClient() *restfake.RESTClient {
 	_ = whv1.AddToScheme(scheme.Scheme)
 	return &restfake.RESTClient{
@@ -0,0 +1,139 @@
+package pods
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+const (
+	pullSecretConfigMap = "image-validation-webhook-pull-secrets"
+
+	globalPullSecretsKey = "global-pull-secrets"
+
+	// resourceServiceAccounts is a resource name of the service accounts
+	resourceServiceAccounts corev1.ResourceName = "serviceaccounts"
+
+	// defaultServiceAccount is a service account of the pods, which do not specify one
+	defaultServiceAccount = "default"
+)
+
+// podKeychain is the keychain of the pull secrets of a pod. The pull secrets are resolved only once, when the signatures of an
+// image are looked up for the first time, so that they are not read for the pods whose images are whitelisted or not sign-checked
+type podKeychain struct {
+	validator *validator
+	pod       *corev1.Pod
+
+	resolved bool
+	keychain *utils.Keychain
+	err      error
+}
+
+// get gets the keychain, resolving the pull secrets at the first call
+func (k *podKeychain) get() (*utils.Keychain, error) {
+	if !k.resolved {
+		k.resolved = true
+		var pullSecrets []*corev1.Secret
+		pullSecrets, k.err = k.validator.getPullSecrets(k.pod)
+		if k.err == nil {
+			k.keychain = utils.NewKeychain(pullSecrets)
+		}
+	}
+	return k.keychain, k.err
+}
+
+// getPullSecrets gets the pull secrets of the pod, in the order of the pod's own ones, the ones of its service account and the global ones.
+// Pull secrets of the service account are injected to the pod after the admission, so they are resolved here.
+// All of them are read from the cache, and missing ones are ignored, as the kubelet does
+func (h *validator) getPullSecrets(pod *corev1.Pod) ([]*corev1.Secret, error) {
+	var refs []types.NamespacedName
+	for _, pullSecret := range pod.Spec.ImagePullSecrets {
+		refs = append(refs, types.NamespacedName{Namespace: pod.Namespace, Name: pullSecret.Name})
+	}
+
+	saName := pod.Spec.ServiceAccountName
+	if saName == "" {
+		saName = defaultServiceAccount
+	}
+	sa, err := h.keyCache.getServiceAccount(pod.Namespace, saName)
+	if err != nil && !isMissingKeyObject(err) {
+		return nil, err
+	}
+	if sa != nil {
+		for _, pullSecret := range sa.ImagePullSecrets {
+			refs = append(refs, types.NamespacedName{Namespace: pod.Namespace, Name: pullSecret.Name})
+		}
+	}
+
+	globalRefs, err := h.keyCache.getGlobalPullSecretRefs()
+	if err != nil {
+		return nil, err
+	}
+	refs = append(refs, globalRefs...)
+
+	var secrets []*corev1.Secret
+	added := map[types.NamespacedName]bool{}
+	for _, ref := range refs {
+		if added[ref] {
+			continue
+		}
+		added[ref] = true
+
+		secret, err := h.keyCache.getSecret(ref.Namespace, ref.Name)
+		if isMissingKeyObject(err) {
+			validatorLog.Info("Pull secret does not exist", "secret", ref.String())
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		secrets = append(secrets, secret)
+	}
+	return secrets, nil
+}
+
+// getServiceAccount gets the service account from the cache
+func (c *keyCache) getServiceAccount(namespace, name string) (*corev1.ServiceAccount, error) {
+	key := types.NamespacedName{Namespace: namespace, Name: name}
+	cachedClient, err := c.getCachedClient(resourceServiceAccounts, namespace, name)
+	if err != nil {
+		return nil, err
+	}
+	sa := &corev1.ServiceAccount{}
+	if err := cachedClient.Get(key, sa); err != nil {
+		return nil, c.getError(resourceServiceAccounts, key, err)
+	}
+	return sa, nil
+}
+
+// getGlobalPullSecretRefs gets the references of the global pull secrets, which are applied to all the pods.
+// They are listed as <namespace>/<name> in the pull secret config map, which is optional
+func (c *keyCache) getGlobalPullSecretRefs() ([]types.NamespacedName, error) {
+	_, data, err := c.getData(corev1.ResourceConfigMaps, registryNamespace, pullSecretConfigMap)
+	if isMissingKeyObject(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var refs []types.NamespacedName
+	for _, line := range parseLineSeparatedList(string(data[globalPullSecretsKey])) {
+		tokens := strings.Split(line, "/")
+		if len(tokens) != 2 || tokens[0] == "" || tokens[1] == "" {
+			return nil, fmt.Errorf("global pull secret %s is not in <namespace>/<name> form", line)
+		}
+		refs = append(refs, types.NamespacedName{Namespace: tokens[0], Name: tokens[1]})
+	}
+	return refs, nil
+}
+
+// isMissingKeyObject checks if the error is for the object which does not exist
+func isMissingKeyObject(err error) bool {
+	var missingErr *missingKeyObjectError
+	return errors.As(err, &missingErr)
+}
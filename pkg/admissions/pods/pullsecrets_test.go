@@ -0,0 +1,159 @@
+package pods
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
+	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+)
+
+type pullSecretsTestCase struct {
+	pod           *corev1.Pod
+	globalSecrets string
+
+	expectedSecrets []string
+	expectedErrMsg  string
+}
+
+func TestValidator_GetPullSecrets(t *testing.T) {
+	secret := func(namespace, name string) *corev1.Secret {
+		return &corev1.Secret{ObjectMeta: metav1.ObjectMeta{Namespace: namespace, Name: name}, Type: corev1.SecretTypeDockerConfigJson}
+	}
+
+	tc := map[string]pullSecretsTestCase{
+		"podOnly": {
+			pod:             testPullSecretPod("other-sa", "pod-secret"),
+			expectedSecrets: []string{"test/pod-secret"},
+		},
+		"serviceAccount": {
+			pod:             testPullSecretPod("puller", "pod-secret"),
+			expectedSecrets: []string{"test/pod-secret", "test/sa-secret"},
+		},
+		"defaultServiceAccount": {
+			pod:             testPullSecretPod(""),
+			expectedSecrets: []string{"test/default-secret"},
+		},
+		"duplicated": {
+			pod:             testPullSecretPod("puller", "sa-secret"),
+			expectedSecrets: []string{"test/sa-secret"},
+		},
+		"global": {
+			pod:             testPullSecretPod("puller"),
+			globalSecrets:   "registry-system/global-secret\nregistry-system/missing-secret",
+			expectedSecrets: []string{"test/sa-secret", "registry-system/global-secret"},
+		},
+		"globalNotInForm": {
+			pod:            testPullSecretPod("other-sa"),
+			globalSecrets:  "global-secret",
+			expectedErrMsg: "global pull secret global-secret is not in <namespace>/<name> form",
+		},
+		"missingPodSecret": {
+			pod:             testPullSecretPod("puller", "missing-secret", "pod-secret"),
+			expectedSecrets: []string{"test/pod-secret", "test/sa-secret"},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			kc := testKeyCache(t, map[string]runtime.Object{
+				"serviceaccounts/test/puller": &corev1.ServiceAccount{
+					ObjectMeta:       metav1.ObjectMeta{Namespace: "test", Name: "puller"},
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "sa-secret"}, {Name: "missing-secret"}},
+				},
+				"serviceaccounts/test/default": &corev1.ServiceAccount{
+					ObjectMeta:       metav1.ObjectMeta{Namespace: "test", Name: "default"},
+					ImagePullSecrets: []corev1.LocalObjectReference{{Name: "default-secret"}},
+				},
+				"secrets/test/pod-secret":               secret("test", "pod-secret"),
+				"secrets/test/sa-secret":                secret("test", "sa-secret"),
+				"secrets/test/default-secret":           secret("test", "default-secret"),
+				"secrets/registry-system/global-secret": secret("registry-system", "global-secret"),
+				"configmaps/registry-system/image-validation-webhook-pull-secrets": &corev1.ConfigMap{
+					ObjectMeta: metav1.ObjectMeta{Namespace: registryNamespace, Name: pullSecretConfigMap},
+					Data:       map[string]string{globalPullSecretsKey: c.globalSecrets},
+				},
+			})
+			kc.cachedClients["serviceaccounts/test/other-sa"] = &watchedObject{cachedClient: &notFoundCachedClient{}}
+			kc.cachedClients["secrets/test/missing-secret"] = &watchedObject{cachedClient: &notFoundCachedClient{}}
+			kc.cachedClients["secrets/registry-system/missing-secret"] = &watchedObject{cachedClient: &notFoundCachedClient{}}
+
+			v := &validator{keyCache: kc}
+			secrets, err := v.getPullSecrets(c.pod)
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			var names []string
+			for _, s := range secrets {
+				names = append(names, fmt.Sprintf("%s/%s", s.Namespace, s.Name))
+			}
+			require.Equal(t, c.expectedSecrets, names)
+		})
+	}
+}
+
+// TestValidator_PullSecretsNotRead checks that the pull secrets are read only for the images whose signatures are looked up
+func TestValidator_PullSecretsNotRead(t *testing.T) {
+	v := &validator{
+		registryPolicyCache: &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
+			Cache: map[string]runtime.Object{
+				"test/policy": &whv1.RegistrySecurityPolicy{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "policy"},
+					Spec: whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{
+						{Registry: "registry.io"},
+						{Registry: "signed.io", SignCheck: true},
+					}},
+				},
+			},
+		}},
+		whiteList: &WhiteList{byImages: []imageRef{{host: "whitelisted.io", name: "*"}}},
+		mirrors:   &Mirrors{},
+		keyCache: &keyCache{offlineClients: map[corev1.ResourceName]watcher.CachedClient{
+			corev1.ResourceConfigMaps: &errorCachedClient{},
+			corev1.ResourceSecrets:    &errorCachedClient{},
+			resourceServiceAccounts:   &errorCachedClient{},
+		}},
+	}
+
+	pod := testPullSecretPod("puller", "pod-secret")
+	pod.Spec.Containers = []corev1.Container{{Name: "app", Image: "registry.io/app:v1"}, {Name: "tool", Image: "whitelisted.io/tool:v1"}}
+	result, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.True(t, result.Valid, result.Reason)
+
+	pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: "signed", Image: "signed.io/app:v1"})
+	_, err = v.CheckIsValidAndAddDigest(pod)
+	require.Error(t, err)
+	require.Equal(t, "couldn't get serviceaccounts test/puller by cache is not available", err.Error())
+}
+
+// errorCachedClient is a watcher.CachedClient, which fails to get any object
+type errorCachedClient struct{}
+
+func (c *errorCachedClient) Get(_ types.NamespacedName, _ runtime.Object) error {
+	return fmt.Errorf("cache is not available")
+}
+
+func (c *errorCachedClient) List(_ watcher.Selector, _ runtime.Object) error {
+	return fmt.Errorf("cache is not available")
+}
+
+func testPullSecretPod(serviceAccount string, pullSecrets ...string) *corev1.Pod {
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: "test"},
+		Spec:       corev1.PodSpec{ServiceAccountName: serviceAccount},
+	}
+	for _, s := range pullSecrets {
+		pod.Spec.ImagePullSecrets = append(pod.Spec.ImagePullSecrets, corev1.LocalObjectReference{Name: s})
+	}
+	return pod
+}
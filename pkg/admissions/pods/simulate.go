@@ -0,0 +1,204 @@
+package pods
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	core "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	simulateLog = logf.Log.WithName("pods/simulate.go")
+)
+
+func init() {
+	// Add policy simulation handler initiator, which explains the decision on a pod without admitting it
+	server.AddHandlerInitiator("/simulate", []string{http.MethodPost}, NewSimulationHandler)
+}
+
+// SimulationRequest is a pod spec to be validated in the namespace
+type SimulationRequest struct {
+	// Namespace is the namespace where the pod would be created
+	Namespace string `json:"namespace"`
+	// Spec is the spec of the pod
+	Spec core.PodSpec `json:"spec"`
+}
+
+// SimulationResponse is the decision of the webhook on the pod of a SimulationRequest
+type SimulationResponse struct {
+	// Allowed is true if the webhook admits the pod
+	Allowed bool `json:"allowed"`
+	// Reason is why the pod is not allowed
+	Reason string `json:"reason,omitempty"`
+	// Error is the error which occurred while validating the images, which the webhook rejects the pod by
+	Error string `json:"error,omitempty"`
+	// NamespaceWhitelisted is true if the images are not validated, as the namespace is whitelisted
+	NamespaceWhitelisted bool `json:"namespaceWhitelisted,omitempty"`
+	// Images are the decisions of the mutating webhook on the images, in the order of the init containers, the containers and the
+	// ephemeral containers
+	Images []*ImageDecision `json:"images"`
+	// Patch is the JSON patch, which the mutating webhook pins the images of the pod by their digests with
+	Patch json.RawMessage `json:"patch,omitempty"`
+}
+
+// SimulationHandler validates the pods of the requests as the webhook does, and responds how they are validated.
+// Nothing is admitted, so that users find out why images are blocked without creating pods
+type SimulationHandler struct {
+	validator Validator
+	client    kubernetes.Interface
+}
+
+// NewSimulationHandler initiates a new policy simulation handler. Only the users who can create pods in the namespace are allowed
+func NewSimulationHandler(cfg *server.HandlerConfig) (http.Handler, error) {
+	v, err := getValidator(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	return server.Authenticated(cfg.ClientSet, &SimulationHandler{validator: v, client: cfg.ClientSet}), nil
+}
+
+func (s *SimulationHandler) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	simReq := &SimulationRequest{}
+	if err := json.NewDecoder(req.Body).Decode(simReq); err != nil {
+		http.Error(w, fmt.Sprintf("Couldn't decode request by %s", err), http.StatusBadRequest)
+		return
+	}
+	if simReq.Namespace == "" {
+		http.Error(w, "Namespace is required", http.StatusBadRequest)
+		return
+	}
+
+	// The user should be able to create the pod, so that the policies and the keys of other namespaces are not exposed
+	user, ok := server.UserFrom(req.Context())
+	if !ok {
+		http.Error(w, "Request is not authenticated", http.StatusUnauthorized)
+		return
+	}
+	allowed, err := s.canCreatePods(req.Context(), user, simReq.Namespace)
+	if err != nil {
+		simulateLog.Error(err, "Couldn't review access")
+		http.Error(w, "Couldn't authorize request", http.StatusInternalServerError)
+		return
+	}
+	if !allowed {
+		http.Error(w, fmt.Sprintf("User %s cannot create pods in namespace %s", user.Username, simReq.Namespace), http.StatusForbidden)
+		return
+	}
+
+	simulateLog.Info(fmt.Sprintf("Simulating pod in %s for %s", simReq.Namespace, user.Username))
+	resp, err := s.Simulate(simReq)
+	if err != nil {
+		errMsg := fmt.Sprintf("Couldn't simulate request by %s", err)
+		simulateLog.Error(err, errMsg)
+		http.Error(w, errMsg, http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(resp); err != nil {
+		simulateLog.Error(err, "")
+	}
+}
+
+// Simulate validates the pod of the request as the webhooks do. The mutating webhook pins the images of the pod, and the validating
+// webhook validates the pinned pod again, rejecting the images which are still not pinned. The images are pinned only in the patch
+func (s *SimulationHandler) Simulate(req *SimulationRequest) (*SimulationResponse, error) {
+	pod := &core.Pod{
+		ObjectMeta: metav1.ObjectMeta{Namespace: req.Namespace},
+		Spec:       *req.Spec.DeepCopy(),
+	}
+	original := pod.DeepCopy()
+
+	resp := &SimulationResponse{Images: []*ImageDecision{}}
+	result, err := s.validator.CheckIsValidAndAddDigest(pod)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp, nil
+	}
+
+	resp.Reason = result.Reason
+	resp.NamespaceWhitelisted = result.NamespaceWhitelisted
+	resp.Images = imageDecisionsOf(original, result.Decisions)
+	if !result.Valid {
+		return resp, nil
+	}
+
+	patch, err := createPatch(original, pod)
+	if err != nil {
+		return nil, err
+	}
+	resp.Patch = patch
+
+	// The validating webhook sees the pod patched by the mutating webhook
+	pinned := pod.DeepCopy()
+	result, err = s.validator.CheckIsValidAndAddDigest(pinned)
+	if err != nil {
+		resp.Error = err.Error()
+		return resp, nil
+	}
+	if !result.Valid {
+		resp.Reason = result.Reason
+		return resp, nil
+	}
+	if reasons := unpinnedImages(pod, pinned); len(reasons) > 0 {
+		resp.Reason = strings.Join(reasons, "\n")
+		return resp, nil
+	}
+
+	resp.Allowed = true
+	return resp, nil
+}
+
+// imageDecisionsOf returns the decisions on the images of the pod, in the order of the containers. Each image appears once
+func imageDecisionsOf(pod *core.Pod, decisions map[string]*ImageDecision) []*ImageDecision {
+	images := []*ImageDecision{}
+	seen := map[string]bool{}
+	var containers []core.Container
+	containers = append(containers, pod.Spec.InitContainers...)
+	containers = append(containers, pod.Spec.Containers...)
+	containers = append(containers, ephemeralContainersOf(pod)...)
+	for _, c := range containers {
+		d, exist := decisions[c.Image]
+		if !exist || seen[c.Image] {
+			continue
+		}
+		seen[c.Image] = true
+		images = append(images, d)
+	}
+	return images
+}
+
+// canCreatePods checks if the user can create pods in the namespace, with the SubjectAccessReview API
+func (s *SimulationHandler) canCreatePods(ctx context.Context, user authenticationv1.UserInfo, namespace string) (bool, error) {
+	extra := map[string]authorizationv1.ExtraValue{}
+	for k, v := range user.Extra {
+		extra[k] = authorizationv1.ExtraValue(v)
+	}
+	review, err := s.client.AuthorizationV1().SubjectAccessReviews().Create(ctx, &authorizationv1.SubjectAccessReview{
+		Spec: authorizationv1.SubjectAccessReviewSpec{
+			ResourceAttributes: &authorizationv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      "create",
+				Resource:  "pods",
+			},
+			User:   user.Username,
+			UID:    user.UID,
+			Groups: user.Groups,
+			Extra:  extra,
+		},
+	}, metav1.CreateOptions{})
+	if err != nil {
+		return false, err
+	}
+	return review.Status.Allowed, nil
+}
@@ -0,0 +1,162 @@
+package pods
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/server"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	authorizationv1 "k8s.io/api/authorization/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type simulationHandlerTestCase struct {
+	token string
+	body  string
+
+	expectedStatusCode int
+	expectedResponse   *SimulationResponse
+	expectedOutput     string
+}
+
+func TestSimulationHandler(t *testing.T) {
+	testCli := fake.NewSimpleClientset()
+	// Tokens are the names of the users, who can create pods only in their own namespaces
+	testCli.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: review.Spec.Token}}
+		return true, review, nil
+	})
+	testCli.PrependReactor("create", "subjectaccessreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authorizationv1.SubjectAccessReview)
+		attrs := review.Spec.ResourceAttributes
+		if review.Spec.User == "broken" {
+			return true, nil, fmt.Errorf("subject access review is not available")
+		}
+		review.Status.Allowed = attrs.Verb == "create" && attrs.Resource == "pods" && attrs.Namespace == review.Spec.User
+		return true, review, nil
+	})
+
+	h := server.Authenticated(testCli, &SimulationHandler{validator: &dummyValidator{}, client: testCli})
+
+	tc := map[string]simulationHandlerTestCase{
+		"allowed": {
+			token:              "testns",
+			body:               `{"namespace":"testns","spec":{"initContainers":[{"name":"init","image":"test-cosign"}],"containers":[{"name":"app","image":"test-pinned:1"},{"name":"sidecar","image":"test-cosign"}]}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedResponse: &SimulationResponse{
+				Allowed: true,
+				Images: []*ImageDecision{
+					{Image: "test-cosign", Valid: true, PolicyMatched: true, Cosign: &VerifierResult{Verified: true, KeyID: "testns/keys/test-key"}},
+					{Image: "test-pinned:1", Valid: true, PolicyMatched: true, Notary: &VerifierResult{Verified: true}, Digest: "sha256:1111"},
+				},
+				Patch: json.RawMessage(`[{"op":"replace","path":"/spec/containers/0/image","value":"test-pinned:1@sha256:1111"}]`),
+			},
+		},
+		"denied": {
+			token:              "testns",
+			body:               `{"namespace":"testns","spec":{"containers":[{"name":"app","image":"test-not-signed"},{"name":"sidecar","image":"test-pinned:1"}]}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedResponse: &SimulationResponse{
+				Reason: "image 'test-not-signed' is not signed",
+				Images: []*ImageDecision{
+					{Image: "test-not-signed", Reason: "image 'test-not-signed' is not signed", PolicyMatched: true, Cosign: &VerifierResult{Reason: "image 'test-not-signed' is not signed"}},
+				},
+			},
+		},
+		"movedAfterPinned": {
+			token:              "testns",
+			body:               `{"namespace":"testns","spec":{"containers":[{"name":"app","image":"test-moving:1"}]}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedResponse: &SimulationResponse{
+				Reason: "image 'test-moving:1@sha256:2222' is not pinned by its digest",
+				Images: []*ImageDecision{
+					{Image: "test-moving:1", Valid: true, PolicyMatched: true, Digest: "sha256:2222"},
+				},
+				Patch: json.RawMessage(`[{"op":"replace","path":"/spec/containers/0/image","value":"test-moving:1@sha256:2222"}]`),
+			},
+		},
+		"validationError": {
+			token:              "testns",
+			body:               `{"namespace":"testns","spec":{"containers":[{"name":"app","image":"test-error"}]}}`,
+			expectedStatusCode: http.StatusOK,
+			expectedResponse: &SimulationResponse{
+				Error:  "image 'test-error' cannot be validated",
+				Images: []*ImageDecision{},
+			},
+		},
+		"unauthenticated": {
+			body:               `{"namespace":"testns","spec":{"containers":[{"name":"app","image":"test-pinned:1"}]}}`,
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedOutput:     "Bearer token is required\n",
+		},
+		"forbidden": {
+			token:              "otherns",
+			body:               `{"namespace":"testns","spec":{"containers":[{"name":"app","image":"test-pinned:1"}]}}`,
+			expectedStatusCode: http.StatusForbidden,
+			expectedOutput:     "User otherns cannot create pods in namespace testns\n",
+		},
+		"authorizationError": {
+			token:              "broken",
+			body:               `{"namespace":"testns","spec":{"containers":[{"name":"app","image":"test-pinned:1"}]}}`,
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedOutput:     "Couldn't authorize request\n",
+		},
+		"noNamespace": {
+			token:              "testns",
+			body:               `{"spec":{"containers":[{"name":"app","image":"test-pinned:1"}]}}`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedOutput:     "Namespace is required\n",
+		},
+		"invalidBody": {
+			token:              "testns",
+			body:               `{"namespace":`,
+			expectedStatusCode: http.StatusBadRequest,
+			expectedOutput:     "Couldn't decode request by unexpected EOF\n",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/simulate", bytes.NewBufferString(c.body))
+			if c.token != "" {
+				req.Header.Set("Authorization", "Bearer "+c.token)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			output, err := ioutil.ReadAll(w.Result().Body)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedStatusCode, w.Code, "code")
+
+			if c.expectedResponse == nil {
+				require.Equal(t, c.expectedOutput, string(output), "output")
+				return
+			}
+			resp := &SimulationResponse{}
+			require.NoError(t, json.Unmarshal(output, resp))
+			require.Equal(t, c.expectedResponse, resp, "response")
+		})
+	}
+}
+
+func TestSimulationHandler_Simulate(t *testing.T) {
+	s := &SimulationHandler{validator: &dummyValidator{}}
+	req := &SimulationRequest{
+		Namespace: "testns",
+		Spec:      corev1.PodSpec{Containers: []corev1.Container{{Name: "app", Image: "test-pinned:1"}}},
+	}
+
+	_, err := s.Simulate(req)
+	require.NoError(t, err)
+	require.Equal(t, "test-pinned:1", req.Spec.Containers[0].Image, "request is not pinned")
+}
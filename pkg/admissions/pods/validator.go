@@ -2,16 +2,20 @@ package pods
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"strings"
 
 	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sigstore/cosign/pkg/oci"
 	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
 	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
 	corev1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/client-go/kubernetes"
 	"k8s.io/client-go/kubernetes/scheme"
 	"k8s.io/client-go/rest"
@@ -33,21 +37,93 @@ func init() {
 
 // Validator validates pods if the images are signed
 type Validator interface {
-	CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, error)
+	CheckIsValidAndAddDigest(pod *corev1.Pod) (*Result, error)
+}
+
+// Result is a result of validating the images of a pod
+type Result struct {
+	// Valid is true if the images of the pod are valid
+	Valid bool
+	// Reason is a reason why the images are invalid
+	Reason string
+	// KeyIDs are the IDs of the cosign keys which verified the images, keyed by the images
+	KeyIDs map[string]string
+	// NamespaceWhitelisted is true if the images are not validated, as the namespace of the pod is whitelisted
+	NamespaceWhitelisted bool
+	// Decisions are the decisions on the images, keyed by the images. Every image of the pod is checked, so each of them has a decision.
+	// They are empty if the namespace is whitelisted
+	Decisions map[string]*ImageDecision
+}
+
+// ImageDecision is how an image is validated, i.e., the policy matching it and the results of the verifiers
+type ImageDecision struct {
+	// Image is the image of the container
+	Image string `json:"image"`
+	// Valid is true if the image is valid
+	Valid bool `json:"valid"`
+	// Reason is why the image is invalid
+	Reason string `json:"reason,omitempty"`
+	// Whitelisted is true if the image is whitelisted, so that it is not validated
+	Whitelisted bool `json:"whitelisted,omitempty"`
+	// MirroredImage is the image on the mirror, where the signatures are looked up. It is empty if no mirror matches the image
+	MirroredImage string `json:"mirroredImage,omitempty"`
+	// PolicyMatched is true if a registry security policy allows the registry of the image, or if there is no policy at all
+	PolicyMatched bool `json:"policyMatched"`
+	// Policy is the registry security policy matching the image. It is nil if there is no policy at all
+	Policy *whv1.RegistrySpec `json:"policy,omitempty"`
+	// Notary and Cosign are the results of the signature verifiers, which are nil if they are not run
+	Notary *VerifierResult `json:"notary,omitempty"`
+	Cosign *VerifierResult `json:"cosign,omitempty"`
+	// Attestations is the result of verifying the attestations required by the policy
+	Attestations *VerifierResult `json:"attestations,omitempty"`
+	// Digest is the signed digest which the image is pinned by
+	Digest string `json:"digest,omitempty"`
+}
+
+// VerifierResult is a result of verifying the signatures or the attestations of an image
+type VerifierResult struct {
+	// Verified is true if the image is verified
+	Verified bool `json:"verified"`
+	// Reason is why the image is not verified
+	Reason string `json:"reason,omitempty"`
+	// KeyID is the ID of the cosign key which verified the image
+	KeyID string `json:"keyID,omitempty"`
+}
+
+// decisionOf returns the decision on the image, which is added to the decisions if it does not exist
+func decisionOf(decisions map[string]*ImageDecision, image string) *ImageDecision {
+	d, exist := decisions[image]
+	if !exist {
+		d = &ImageDecision{Image: image}
+		decisions[image] = d
+	}
+	return d
+}
+
+// recordPolicy records the policy matching the image and the mirror, where the signatures are looked up
+func (d *ImageDecision) recordPolicy(upstreamRef, mirroredRef imageRef, valid bool, policy whv1.RegistrySpec) {
+	if upstreamRef.host != mirroredRef.host {
+		d.MirroredImage = mirroredRef.String()
+	}
+	d.PolicyMatched = valid
+	if valid && policy.Registry != "" {
+		d.Policy = policy.DeepCopy()
+	}
 }
 
 // validator handles overall process to check signs
 type validator struct {
-	client kubernetes.Interface
-
 	registryPolicyCache *RegistryPolicyCache
 	whiteList           *WhiteList
+	mirrors             *Mirrors
+	keyCache            *keyCache
+
+	// verifier verifies the signatures. The registries and the notary servers are used if it is nil
+	verifier Verifier
 }
 
 func newValidator(cfg *rest.Config, clientSet kubernetes.Interface, restClient rest.Interface) (*validator, error) {
-	v := &validator{
-		client: clientSet,
-	}
+	v := &validator{}
 
 	var err error
 
@@ -63,230 +139,452 @@ func newValidator(cfg *rest.Config, clientSet kubernetes.Interface, restClient r
 		return nil, err
 	}
 
+	// Initiate Mirrors cache
+	v.mirrors, err = newMirrors(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	// Initiate cosign public key cache
+	v.keyCache, err = newKeyCache(cfg)
+	if err != nil {
+		return nil, err
+	}
+
 	return v, nil
 }
 
-// CheckIsValidAndAddDigest checks if images of initContainers and containers are valid
-func (h *validator) CheckIsValidAndAddDigest(pod *corev1.Pod) (bool, string, error) {
+// CheckIsValidAndAddDigest checks if images of initContainers, containers and ephemeralContainers are valid.
+// Every image is checked, so that the decisions have all of them, and the valid images are pinned by their signed digests
+func (h *validator) CheckIsValidAndAddDigest(pod *corev1.Pod) (*Result, error) {
 	// Check namespace whitelist
 	if h.whiteList.IsNamespaceWhiteListed(pod.Namespace) {
+		return &Result{Valid: true, NamespaceWhitelisted: true}, nil
+	}
+
+	// Pull secrets are used to look up the signatures, so they are read only for the sign-checked images
+	keychain := &podKeychain{validator: h, pod: pod}
+
+	var reasons []string
+	keyIDs := map[string]string{}
+	decisions := map[string]*ImageDecision{}
+	check := func(containers []corev1.Container) error {
+		for i := range containers {
+			decision := decisionOf(decisions, containers[i].Image)
+			isValid, reason, err := h.imageValid(&containers[i], pod.Namespace, keychain, keyIDs, decisions)
+			if err != nil {
+				return err
+			}
+			decision.Valid, decision.Reason = isValid, reason
+			if !isValid {
+				reasons = append(reasons, reason)
+			}
+		}
+		return nil
+	}
+
+	if err := check(pod.Spec.InitContainers); err != nil {
+		return nil, err
+	}
+	if err := check(pod.Spec.Containers); err != nil {
+		return nil, err
+	}
+	ephemeralContainers := ephemeralContainersOf(pod)
+	if err := check(ephemeralContainers); err != nil {
+		return nil, err
+	}
+	setEphemeralContainerImages(pod, ephemeralContainers)
+
+	if len(reasons) > 0 {
+		return &Result{Valid: false, Reason: strings.Join(reasons, "\n"), Decisions: decisions}, nil
+	}
+	return &Result{Valid: true, KeyIDs: keyIDs, Decisions: decisions}, nil
+}
+
+// imageValid checks if the image of the container is valid. Signatures are checked with notary first, and with cosign if notary
+// does not verify them. The IDs of the cosign keys which verified the image are put in keyIDs, and how it is validated is put in decisions
+func (h *validator) imageValid(container *corev1.Container, namespace string, podKeychain *podKeychain, keyIDs map[string]string, decisions map[string]*ImageDecision) (bool, string, error) {
+	decision := decisionOf(decisions, container.Image)
+	// Check if it's whitelisted
+	if h.whiteList.IsImageWhiteListed(container.Image) {
+		decision.Whitelisted = true
 		return true, "", nil
 	}
 
-	// TODO: Check both Notary and Cosign Signature
-	var reasonRes []string
-	// Image validating with notary
-	if isValid, reason, err := h.notaryImageValid(pod); err != nil {
+	ref, err := parseImage(container.Image)
+	if err != nil {
 		return false, "", err
-		// if image valid with notary, return true
-	} else if isValid {
+	}
+	upstreamRef, mirroredRef := h.mirrors.resolve(*ref)
+
+	// Check if it meets registry security policy
+	valid, policy := h.matchPolicy(upstreamRef, mirroredRef, namespace)
+	decision.recordPolicy(upstreamRef, mirroredRef, valid, policy)
+	if !valid {
+		return false, fmt.Sprintf("Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", container.Image), nil
+	}
+	// There is no policy at all, or the signatures of the registry are not checked
+	if policy.Registry == "" || !policy.SignCheck {
 		return true, "", nil
-		// if image invalid, reason append reason array
-	} else {
-		if reason != "" {
-			reasonRes = append(reasonRes, reason)
-		}
 	}
-	// Image validating with cosign
-	if isValid, reason, err := h.cosignImageValid(pod); err != nil {
+	keychain, err := podKeychain.get()
+	if err != nil {
 		return false, "", err
-		// if image valid with cosign, return true
-	} else if isValid {
-		return true, "", nil
-		// if image invalid, reason append reason array
-	} else {
-		if reason != "" {
-			reasonRes = append(reasonRes, reason)
-		}
 	}
-	// The image signature is invalid.
-	reasons := strings.Join(reasonRes, "\n")
-	return false, reasons, nil
+
+	isValid, notaryReason, err := h.notaryImageValid(container, *ref, mirroredRef, policy, keychain, decision)
+	if err != nil || isValid {
+		return isValid, "", err
+	}
+	if decision.Notary == nil {
+		decision.Notary = &VerifierResult{Reason: notaryReason}
+	}
+	isValid, cosignReason, err := h.cosignImageValid(container, *ref, mirroredRef, policy, keychain, keyIDs, decision)
+	if err != nil || isValid {
+		return isValid, "", err
+	}
+	if decision.Cosign == nil {
+		decision.Cosign = &VerifierResult{Reason: cosignReason}
+	}
+	return false, notaryReason + "\n" + cosignReason, nil
+}
+
+// ephemeralContainersOf returns the ephemeral containers of the pod as containers, so that they are validated in the same way.
+// EphemeralContainerCommon has the same fields as Container
+func ephemeralContainersOf(pod *corev1.Pod) []corev1.Container {
+	var containers []corev1.Container
+	for _, c := range pod.Spec.EphemeralContainers {
+		containers = append(containers, corev1.Container(c.EphemeralContainerCommon))
+	}
+	return containers
 }
 
-// notaryImageValid check if image is valid(signing) that using notary(DCT)
-func (h *validator) notaryImageValid(pod *corev1.Pod) (bool, string, error) {
-	// Check initContainers
-	if isValid, reason, err := h.addDigestWhenImageValid(pod.Spec.InitContainers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
+// setEphemeralContainerImages sets the images of the containers, which may be pinned by their digests, back to the ephemeral containers
+func setEphemeralContainerImages(pod *corev1.Pod, containers []corev1.Container) {
+	for i := range containers {
+		pod.Spec.EphemeralContainers[i].Image = containers[i].Image
+	}
+}
+
+// cosignImageValid checks if the image of the container is signed with the cosign keys of the policy. The ID of the key which verified
+// the image is put in keyIDs. The signatures are verified against the digest the tag is resolved to, and the image is pinned by it
+func (h *validator) cosignImageValid(container *corev1.Container, ref, mirroredRef imageRef, policy whv1.RegistrySpec, keychain *utils.Keychain, keyIDs map[string]string, decision *ImageDecision) (bool, string, error) {
+	keys, err := h.getCosignKeys(policy)
+	if err != nil {
+		return policyError("Cosign", container.Image, err)
+	}
+	// Signatures are looked up on the mirror
+	imgRef, err := name.ParseReference(mirroredRef.String())
+	if err != nil {
+		validatorLog.Error(err, "")
 		return false, "", err
-	} else if !isValid {
-		return false, reason, nil
 	}
-	// Check containers
-	if isValid, reason, err := h.addDigestWhenImageValid(pod.Spec.Containers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
+	tlog, err := h.getTransparencyLog(policy)
+	if err != nil {
+		return policyError("Cosign", container.Image, err)
+	}
+	signerPolicy, err := signer.NewPolicy(policy)
+	if err != nil {
 		return false, "", err
-	} else if !isValid {
+	}
+	if err := signerPolicy.CheckKeys(len(keys)); err != nil {
+		reason := fmt.Sprintf("Cosign: Image '%s' cannot be verified: %s", container.Image, err)
+		decision.Cosign = &VerifierResult{Reason: reason}
+		return false, reason, nil
+	}
+	tlsConfig, err := h.getTLSConfig(policy)
+	if err != nil {
+		return policyError("Cosign", container.Image, err)
+	}
+	// The tag is resolved once, so that the signatures, the attestations and the pinned image have the same digest.
+	// Credentials are tried in order until one of them works
+	var digestRef name.Digest
+	for _, kc := range keychain.Keychains(mirroredRef.repository()) {
+		digestRef, err = h.getVerifier().ResolveDigest(imgRef, cosigns.RegistryOpts(tlsConfig, kc)...)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		validatorLog.Error(err, "")
+		reason := fmt.Sprintf("Cosign: Image '%s''s digest cannot be resolved: %s", container.Image, err)
+		decision.Cosign = &VerifierResult{Reason: reason}
 		return false, reason, nil
 	}
 
+	// If the image signature is not valid, an error is raised. Credentials are tried in order until one of them works
+	var sig []oci.Signature
+	var key *cosigns.Key
+	for _, kc := range keychain.Keychains(mirroredRef.repository()) {
+		sig, key, err = h.getVerifier().VerifyCosignSignatures(context.TODO(), digestRef, signerPolicy, keys, tlog, cosigns.RegistryOpts(tlsConfig, kc)...)
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		// if signer annotation is incorrect, Signer is Invalid
+		var mismatchErr *signer.MismatchError
+		reason := fmt.Sprintf("Cosign: Image '%s' is invalid", container.Image)
+		if errors.As(err, &mismatchErr) {
+			reason = fmt.Sprintf("Cosign: Image '%s's signer is invalid", container.Image)
+		}
+		decision.Cosign = &VerifierResult{Reason: reason}
+		return false, reason, nil
+	}
+
+	if sig == nil {
+		reason := fmt.Sprintf("Cosign: Image '%s' signature is empty", container.Image)
+		decision.Cosign = &VerifierResult{Reason: reason}
+		return false, reason, nil
+	}
+	decision.Cosign = &VerifierResult{Verified: true, KeyID: key.ID}
+
+	if isValid, reason, err := h.attestationValid(container.Image, digestRef.Context().String(), digestRef.DigestStr(), policy, keychain, decision); err != nil || !isValid {
+		return isValid, reason, err
+	}
+	keyIDs[container.Image] = key.ID
+
+	decision.Digest = digestRef.DigestStr()
+	ref.digest = digestRef.DigestStr()
+	container.Image = ref.String()
+
 	return true, "", nil
 }
 
-// cosignImageValid check if image is valid(signing) that using cosign
-func (h *validator) cosignImageValid(pod *corev1.Pod) (bool, string, error) {
-	// Check initContainers
-	if isValid, reason, err := h.addDigestWhenImageValidCosign(pod.Spec.InitContainers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
+// notaryImageValid checks if the image of the container is signed with notary(DCT) by the signers of the policy. The image is pinned by
+// the signed digest
+func (h *validator) notaryImageValid(container *corev1.Container, ref, mirroredRef imageRef, policy whv1.RegistrySpec, keychain *utils.Keychain, decision *ImageDecision) (bool, string, error) {
+	// Get trust info of the image
+	trustPinning, err := notary.NewTrustPinConfig(policy.NotaryTrustPins, h.keyCache.getCABundle)
+	if err != nil {
+		return policyError("Notary", container.Image, err)
+	}
+	tlsConfig, err := h.getTLSConfig(policy)
+	if err != nil {
+		return policyError("Notary", container.Image, err)
+	}
+	// Signatures are looked up on the mirror. Credentials matching it are tried in order until one of them works, as the kubelet does
+	var sig *notary.Signature
+	for _, cred := range keychain.Lookup(mirroredRef.repository()) {
+		sig, err = h.getVerifier().FetchNotarySignature(mirroredRef.String(), notary.FetchOptions{
+			BasicAuth:     utils.BasicAuth(cred),
+			IdentityToken: cred.IdentityToken,
+			NotaryServer:  policy.Notary,
+			TrustPinning:  trustPinning,
+			TLSConfig:     tlsConfig,
+		})
+		if err == nil {
+			break
+		}
+	}
+	if err != nil {
+		validatorLog.Error(err, "")
 		return false, "", err
-	} else if !isValid {
+	}
+	// sig is nil if it's not signed
+	if sig == nil {
+		reason := fmt.Sprintf("Notary: Image '%s' is invalid", container.Image)
+		decision.Notary = &VerifierResult{Reason: reason}
 		return false, reason, nil
 	}
-	// Check containers
-	if isValid, reason, err := h.addDigestWhenImageValidCosign(pod.Spec.Containers, pod.Namespace, pod.Spec.ImagePullSecrets); err != nil {
+
+	signerPolicy, err := signer.NewPolicy(policy)
+	if err != nil {
 		return false, "", err
-	} else if !isValid {
+	}
+
+	// Only the signed target of the tag being deployed is checked, and its digest should be the same as the user-specified one
+	tag := ref.tag
+	if tag == "" && ref.digest == "" {
+		tag = image.DefaultTag
+	}
+	signedTag, err := sig.MatchSigner(tag, ref.digest, signerPolicy)
+	if err != nil {
+		var mismatchErr *signer.MismatchError
+		var reason string
+		switch {
+		case errors.Is(err, notary.ErrDigestMismatch):
+			reason = fmt.Sprintf("Notary: Image '%s''s digest is different from the signed digest", container.Image)
+		case errors.As(err, &mismatchErr):
+			reason = fmt.Sprintf("Notary: Image '%s's signer is invalid", container.Image)
+		default:
+			reason = fmt.Sprintf("Notary: Image '%s' is invalid", container.Image)
+		}
+		decision.Notary = &VerifierResult{Reason: reason}
 		return false, reason, nil
 	}
+	decision.Notary = &VerifierResult{Verified: true}
+
+	// Attestations are required even if the image is signed with notary. They are verified against the signed digest
+	if isValid, reason, err := h.attestationValid(container.Image, mirroredRef.repository(), signedTag.Digest, policy, keychain, decision); err != nil || !isValid {
+		return isValid, reason, err
+	}
+
+	decision.Digest = signedTag.Digest
+	ref.digest = signedTag.Digest
+	container.Image = ref.String()
+
 	return true, "", nil
 }
 
-func (h *validator) addDigestWhenImageValidCosign(containers []corev1.Container, namespace string, pullSecrets []corev1.LocalObjectReference) (bool, string, error) {
-	for _, container := range containers {
-		// Check if it`s whitelisted
-		if h.whiteList.IsImageWhiteListed(container.Image) {
-			continue
-		}
+// attestationValid checks if the image of the repository and the verified digest has all the attestations required by the policy.
+// The result is recorded in the decision
+func (h *validator) attestationValid(image, repository, digest string, policy whv1.RegistrySpec, keychain *utils.Keychain, decision *ImageDecision) (bool, string, error) {
+	if len(policy.Attestations) == 0 {
+		return true, "", nil
+	}
 
-		ref, err := parseImage(container.Image)
-		if err != nil {
-			return false, "", err
-		}
+	digestRef, err := name.NewDigest(fmt.Sprintf("%s@%s", repository, digest))
+	if err != nil {
+		validatorLog.Error(err, "")
+		return false, "", err
+	}
 
-		// Check if it meets registry security policy
-		if valid, policy := h.registryPolicyCache.doesMatchPolicy(ref.host, namespace); valid && policy.Registry == "" {
-			return true, "", nil
-		} else if valid {
-			if !policy.SignCheck {
-				return true, "", nil
-			}
-			// Get Cosign Key pair from secret object
-			secret, err := cosigns.GetKeyPairSecret(context.TODO(), h.client, policy.CosignKeyRef)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
-			}
-			// Get Public Key from Secret
-			keys, err := cosigns.GetPublicKey(secret.Data)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
-			}
-			// Valid Image
-			imgRef, err := name.ParseReference(container.Image)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
-			}
-			// If the image signature is not valid, an error is raised
-			sig, err := cosigns.Valid(context.TODO(), imgRef, policy.Signer, keys)
-			if err != nil {
-				// if signer annotation is incorrect, Signer is Invalid
-				if strings.Contains(err.Error(), "missing or incorrect annotation") {
-					return false, fmt.Sprintf("Cosign: Image '%s's signer is invalid", container.Image), nil
-				}
-				return false, fmt.Sprintf("Cosign: Image '%s' is invalid", container.Image), nil
+	keys, err := h.getCosignKeys(policy)
+	if err != nil {
+		return policyError("Cosign", image, err)
+	}
 
-			}
+	tlog, err := h.getTransparencyLog(policy)
+	if err != nil {
+		return policyError("Cosign", image, err)
+	}
 
-			if sig == nil {
-				return false, fmt.Sprintf("Cosign: Image '%s' signature is empty", container.Image), nil
-			}
+	tlsConfig, err := h.getTLSConfig(policy)
+	if err != nil {
+		return policyError("Cosign", image, err)
+	}
 
-			return true, "", nil
+	// Credentials are tried in order until one of them works
+	for _, kc := range keychain.Keychains(digestRef.Context().String()) {
+		if err = h.getVerifier().VerifyCosignAttestations(context.TODO(), digestRef, policy.Attestations, keys, tlog, cosigns.RegistryOpts(tlsConfig, kc)...); err == nil {
+			break
 		}
-		// Does NOT match registry security policy
-		return false, fmt.Sprintf("Cosign: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", container.Image), nil
 	}
+	if err != nil {
+		reason := fmt.Sprintf("Cosign: Image '%s''s attestation is invalid: %s", image, err)
+		decision.Attestations = &VerifierResult{Reason: reason}
+		return false, reason, nil
+	}
+	decision.Attestations = &VerifierResult{Verified: true}
 	return true, "", nil
 }
 
-func (h *validator) addDigestWhenImageValid(containers []corev1.Container, namespace string, pullSecrets []corev1.LocalObjectReference) (bool, string, error) {
-	for i, container := range containers {
-		// Check if it's whitelisted
-		if h.whiteList.IsImageWhiteListed(container.Image) {
-			continue
-		}
-
-		ref, err := parseImage(container.Image)
-		if err != nil {
-			return false, "", err
-		}
-
-		// Get registry basic auth
-		basicAuth, err := h.getBasicAuthForRegistry(ref.host, namespace, pullSecrets)
-		if err != nil {
-			return false, "", err
-		}
+// getVerifier returns the verifier of the signatures
+func (h *validator) getVerifier() Verifier {
+	if h.verifier == nil {
+		return &registryVerifier{}
+	}
+	return h.verifier
+}
 
-		// Check if it meets registry security policy
-		if valid, policy := h.registryPolicyCache.doesMatchPolicy(ref.host, namespace); valid && policy.Registry == "" {
-			return true, "", nil
-		} else if valid {
-			if !policy.SignCheck {
-				return true, "", nil
-			}
-			// Get trust info of the image
-			sig, err := notary.FetchSignature(container.Image, basicAuth, policy.Notary)
-			if err != nil {
-				validatorLog.Error(err, "")
-				return false, "", err
-			}
-			// sig is nil if it's not signed
-			if sig == nil {
-				return false, fmt.Sprintf("Notary: Image '%s' is invalid", container.Image), nil
-			}
+// matchPolicy matches the registry security policy of the image, which is referenced by the upstream registry or by its mirror.
+// The policy of the upstream registry is preferred, so that the policies written against the upstream registry are applied to the mirror
+func (h *validator) matchPolicy(upstreamRef, mirroredRef imageRef, namespace string) (bool, whv1.RegistrySpec) {
+	if valid, policy := h.registryPolicyCache.doesMatchPolicy(upstreamRef.host, namespace); valid || upstreamRef.host == mirroredRef.host {
+		return valid, policy
+	}
+	return h.registryPolicyCache.doesMatchPolicy(mirroredRef.host, namespace)
+}
 
-			// If signer is different from signer policy, return false & invalid
-			isMatchSigner := sig.MatchSigner(policy.Signer)
-			if !isMatchSigner {
-				return false, fmt.Sprintf("Notary: Image '%s's signer is invalid", container.Image), nil
-			}
+// errNoCosignKeys is an error for the policy which has no cosign keys, e.g., a policy only for notary
+var errNoCosignKeys = errors.New("the registry security policy has no cosign keys")
 
-			digest := sig.GetDigest(ref.tag)
+// policyError makes the image invalid if the key objects referenced by the policy do not exist, or if the policy has no cosign keys.
+// Otherwise, it returns the error
+func policyError(verifier, image string, err error) (bool, string, error) {
+	if errors.Is(err, errNoCosignKeys) {
+		return false, fmt.Sprintf("%s: Image '%s' is not signed, as %s", verifier, image, err), nil
+	}
+	var missingErr *missingKeyObjectError
+	if errors.As(err, &missingErr) {
+		return false, fmt.Sprintf("%s: Image '%s' cannot be verified: %s", verifier, image, err), nil
+	}
+	return false, "", err
+}
 
-			// If digest is different from user-specified one, return error
-			if ref.digest != "" && ref.digest != digest {
-				return false, fmt.Sprintf("Notary: Image '%s''s digest is different from the signed digest", container.Image), nil
-			}
+// getCosignKeys gets cosign public keys of the policy, from the key reference, the key source and the key set
+func (h *validator) getCosignKeys(policy whv1.RegistrySpec) ([]cosigns.Key, error) {
+	if policy.CosignKeyRef == "" && policy.CosignKey == nil && policy.CosignKeySetRef == "" {
+		return nil, errNoCosignKeys
+	}
 
-			ref.digest = digest
-			containers[i].Image = ref.String()
+	var keys []cosigns.Key
 
-			return true, "", nil
+	if policy.CosignKeyRef != "" {
+		// Get Public Key from cosign.pub of the secret
+		namespace, name, err := cosigns.ParseRef(policy.CosignKeyRef)
+		if err != nil {
+			validatorLog.Error(err, "")
+			return nil, err
 		}
-		// Does NOT match registry security policy
-		return false, fmt.Sprintf("Notary: Image '%s' does not meet registry security policy. Please check the RegistrySecurityPolicy", container.Image), nil
+		refKeys, err := h.keyCache.getPublicKeys(&whv1.CosignKeySource{SecretRef: &whv1.KeyObjectReference{Namespace: namespace, Name: name}})
+		if err != nil {
+			validatorLog.Error(err, "")
+			return nil, err
+		}
+		keys = append(keys, refKeys...)
 	}
-	return true, "", nil
-}
 
-func (h *validator) getBasicAuthForRegistry(host, namespace string, pullSecrets []corev1.LocalObjectReference) (string, error) {
-	for _, pullSecret := range pullSecrets {
-		secret, err := h.client.CoreV1().Secrets(namespace).Get(context.Background(), pullSecret.Name, metav1.GetOptions{})
+	if policy.CosignKey != nil {
+		// Get Public Key from the secret, the config map or inline PEM
+		sourceKeys, err := h.keyCache.getPublicKeys(policy.CosignKey)
 		if err != nil {
-			return "", fmt.Errorf("couldn't get secret named %s by %s", pullSecret.Name, err)
+			validatorLog.Error(err, "")
+			return nil, err
 		}
-		imagePullSecret, err := utils.NewImagePullSecret(secret)
+		keys = append(keys, sourceKeys...)
+	}
+
+	if policy.CosignKeySetRef != "" {
+		// Get keys with their validity periods from CosignKeySet
+		keySet, err := h.registryPolicyCache.getKeySet(policy.CosignKeySetRef)
 		if err != nil {
-			return "", err
+			validatorLog.Error(err, "")
+			return nil, err
 		}
-		basicAuth, err := imagePullSecret.GetHostBasicAuth(h.findRegistryServer(host))
+		keySetKeys, err := cosigns.GetKeySetKeys(keySet)
 		if err != nil {
-			return "", err
+			validatorLog.Error(err, "")
+			return nil, err
 		}
-		if basicAuth == "" {
-			continue
-		}
-
-		return basicAuth, nil
+		keys = append(keys, keySetKeys...)
 	}
+	return keys, nil
+}
 
-	// DO NOT return error - the image may be public
-	return "", nil
+// getTLSConfig gets the TLS config, which verifies the TLS certificates of the registry and the notary server of the policy.
+// It returns nil if the policy does not have a CA bundle, so that only the system CAs are trusted
+func (h *validator) getTLSConfig(policy whv1.RegistrySpec) (*tls.Config, error) {
+	if policy.InsecureSkipTLSVerify {
+		validatorLog.Info("TLS verification is skipped", "registry", policy.Registry)
+		return &tls.Config{InsecureSkipVerify: true}, nil
+	}
+	if policy.CABundle == nil {
+		return nil, nil
+	}
+	pool, err := h.keyCache.getCertPool(policy.CABundle)
+	if err != nil {
+		return nil, err
+	}
+	return &tls.Config{RootCAs: pool}, nil
 }
 
-func (h *validator) findRegistryServer(registry string) string {
-	if registry == "docker.io" {
-		return "https://registry-1.docker.io"
+// getTransparencyLog gets the rekor transparency log of the policy. It returns nil if the policy does not have one
+func (h *validator) getTransparencyLog(policy whv1.RegistrySpec) (*cosigns.TransparencyLog, error) {
+	if policy.TransparencyLog == nil {
+		return nil, nil
+	}
+
+	namespace, name, err := cosigns.ParseRef(policy.TransparencyLog.PublicKeyRef)
+	if err != nil {
+		validatorLog.Error(err, "")
+		return nil, err
+	}
+	publicKeys, err := h.keyCache.getRekorPublicKeys(namespace, name)
+	if err != nil {
+		validatorLog.Error(err, "")
+		return nil, err
 	}
-	return "https://" + registry
+	return &cosigns.TransparencyLog{PublicKeys: publicKeys, RequireBundle: policy.TransparencyLog.RequireBundle}, nil
 }
@@ -3,6 +3,8 @@ package pods
 import (
 	"bytes"
 	"context"
+	"crypto/tls"
+	"encoding/base64"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
@@ -12,12 +14,19 @@ import (
 	"strings"
 	"testing"
 
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sigstore/cosign/pkg/oci"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	"github.com/tmax-cloud/image-validating-webhook/internal/k8s"
 	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
 	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
 	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/watcher"
 	watcherfake "github.com/tmax-cloud/image-validating-webhook/pkg/watcher/fake"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
@@ -42,17 +51,28 @@ const (
 	testImageWhitelisted = "image-whitelisted"
 
 	testSecretDcj = "test-dcj"
+
+	testServiceAccount = "test-puller"
+
+	testUpstreamHost = "upstream.registry.io"
+	testMirrorPrefix = "upstream-proxy"
 )
 
 type handlerTestCase struct {
 	namespace  string
+	host       string
 	image      string
 	pullSecret string
 
+	serviceAccount string
+	// extraImages are the images of the other containers. <host> is replaced with the host of the registry
+	extraImages []string
+
 	expectedValid    bool
 	expectedReason   string
 	expectedErrOccur bool
 	expectedErrMsg   string
+	expectedMirrored bool
 }
 
 var testSrvHost string
@@ -87,6 +107,8 @@ func TestHandler_IsValid(t *testing.T) {
 	require.NoError(t, err)
 	_, err = testSrv.SignImage(testSrv.URL, u.Host, testImageSignCheck, testTag, testDummyDigest)
 	require.NoError(t, err)
+	_, err = testSrv.SignImage(testSrv.URL, u.Host, testMirrorPrefix+"/"+testImageSignCheck, testTag, testDummyDigest)
+	require.NoError(t, err)
 
 	tc := map[string]handlerTestCase{
 		"whitelisted": {
@@ -120,32 +142,75 @@ func TestHandler_IsValid(t *testing.T) {
 			expectedErrOccur: false,
 			expectedErrMsg:   "",
 		},
+		"serviceAccountPullSecret": {
+			namespace:      testCheckSign,
+			image:          fmt.Sprintf("%s:%s", testImageSignCheck, testTag),
+			serviceAccount: testServiceAccount,
+			expectedValid:  true,
+		},
+		"mirror": {
+			namespace:        testCheckSign,
+			host:             testUpstreamHost,
+			image:            fmt.Sprintf("%s:%s", testImageSignCheck, testTag),
+			pullSecret:       testSecretDcj,
+			expectedValid:    true,
+			expectedMirrored: true,
+		},
+		"multiContainer": {
+			namespace:     testCheckSign,
+			image:         fmt.Sprintf("%s:%s", testImageSignCheck, testTag),
+			pullSecret:    testSecretDcj,
+			extraImages:   []string{"evil.io/x:latest", fmt.Sprintf("<host>/%s:%s", testImageNotSigned, testTag)},
+			expectedValid: false,
+			expectedReason: "Image 'evil.io/x:latest' does not meet registry security policy. Please check the RegistrySecurityPolicy\n" +
+				fmt.Sprintf("Notary: Image '<host>/%s:%s' is invalid\n", testImageNotSigned, testTag) +
+				fmt.Sprintf("Cosign: Image '<host>/%s:%s' is not signed, as the registry security policy has no cosign keys", testImageNotSigned, testTag),
+		},
 	}
 
-	validator := testValidator(testCli, testRestCli)
+	validator := testValidator(testRestCli)
+	validator.keyCache = testPullSecretKeyCache(t, testCli)
+	validator.mirrors = &Mirrors{}
+	require.NoError(t, validator.mirrors.Unmarshal(fmt.Sprintf("%s=%s/%s", testUpstreamHost, u.Host, testMirrorPrefix)))
 
 	for name, c := range tc {
 		t.Run(name, func(t *testing.T) {
-			imgURI := fmt.Sprintf("%s/%s", u.Host, c.image)
+			host := c.host
+			if host == "" {
+				host = u.Host
+			}
+			imgURI := fmt.Sprintf("%s/%s", host, c.image)
 
 			pod := generateTestPod(imgURI, c.namespace, c.pullSecret)
-			valid, reason, err := validator.CheckIsValidAndAddDigest(pod)
+			pod.Spec.ServiceAccountName = c.serviceAccount
+			for i, img := range c.extraImages {
+				pod.Spec.Containers = append(pod.Spec.Containers, corev1.Container{Name: fmt.Sprintf("extra-%d", i), Image: strings.ReplaceAll(img, "<host>", host)})
+			}
+			result, err := validator.CheckIsValidAndAddDigest(pod)
 			if c.expectedErrOccur {
 				require.Error(t, err)
 				require.Equal(t, c.expectedErrMsg, err.Error())
 			} else {
 				require.NoError(t, err)
-				require.Equal(t, c.expectedValid, valid)
-				if !valid {
-					require.Equal(t, c.expectedReason, reason, "reason")
+				require.Equal(t, c.expectedValid, result.Valid)
+				if !result.Valid {
+					require.Equal(t, strings.ReplaceAll(c.expectedReason, "<host>", host), result.Reason, "reason")
+					require.Len(t, result.Decisions, len(pod.Spec.Containers), "decisions")
 				} else {
+					decision := result.Decisions[imgURI]
+					require.NotNil(t, decision, "decision")
+					require.Equal(t, c.expectedMirrored, decision.MirroredImage != "", "mirrored image")
 					// Whitelisted image does not get digest
 					if !strings.Contains(pod.Spec.Containers[0].Image, testImageWhitelisted) {
 						ref, _ := parseImage(imgURI)
 						if !strings.Contains(pod.Spec.Containers[0].Image, testImageNoSignCheck) {
-							ref.digest = fmt.Sprintf("%x", testDummyDigest)
+							ref.digest = fmt.Sprintf("sha256:%x", testDummyDigest)
+							require.True(t, decision.Notary.Verified, "notary verified")
 						}
+						require.Equal(t, ref.digest, decision.Digest, "decision digest")
 						require.Equal(t, ref.String(), pod.Spec.Containers[0].Image, "image digest")
+					} else {
+						require.True(t, decision.Whitelisted, "whitelisted")
 					}
 				}
 			}
@@ -153,8 +218,8 @@ func TestHandler_IsValid(t *testing.T) {
 	}
 }
 
-func testValidator(testCli kubernetes.Interface, testRestCli rest.Interface) *validator {
-	validator := &validator{client: testCli}
+func testValidator(testRestCli rest.Interface) *validator {
+	validator := &validator{}
 	validator.registryPolicyCache = &RegistryPolicyCache{restClient: testRestCli, clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
 		Cache: map[string]runtime.Object{
 			testNoCheckSign + "/policy1": &whv1.RegistrySecurityPolicy{
@@ -183,6 +248,15 @@ func testValidator(testCli kubernetes.Interface, testRestCli rest.Interface) *va
 							Registry:  testSrvHost,
 							Notary:    notarySrv,
 							SignCheck: true,
+							// The mock server has a self-signed certificate
+							InsecureSkipTLSVerify: true,
+						},
+						{
+							// Images of the upstream registry are pulled from the mirror
+							Registry:              testUpstreamHost,
+							Notary:                notarySrv,
+							SignCheck:             true,
+							InsecureSkipTLSVerify: true,
 						},
 					},
 				},
@@ -194,6 +268,26 @@ func testValidator(testCli kubernetes.Interface, testRestCli rest.Interface) *va
 	return validator
 }
 
+// testPullSecretKeyCache creates a keyCache, where the test service account has the test pull secret
+func testPullSecretKeyCache(t *testing.T, cli kubernetes.Interface) *keyCache {
+	dcj, err := cli.CoreV1().Secrets(testCheckSign).Get(context.Background(), testSecretDcj, metav1.GetOptions{})
+	require.NoError(t, err)
+	dcj.Namespace = testCheckSign
+
+	kc := testKeyCache(t, map[string]runtime.Object{
+		fmt.Sprintf("serviceaccounts/%s/%s", testCheckSign, testServiceAccount): &corev1.ServiceAccount{
+			ObjectMeta:       metav1.ObjectMeta{Name: testServiceAccount, Namespace: testCheckSign},
+			ImagePullSecrets: []corev1.LocalObjectReference{{Name: testSecretDcj}},
+		},
+		fmt.Sprintf("secrets/%s/%s", testCheckSign, testSecretDcj): dcj,
+	})
+	for _, ns := range []string{testCheckSign, testNoCheckSign} {
+		kc.cachedClients[fmt.Sprintf("serviceaccounts/%s/%s", ns, defaultServiceAccount)] = &watchedObject{cachedClient: &notFoundCachedClient{}}
+	}
+	kc.cachedClients[fmt.Sprintf("configmaps/%s/%s", registryNamespace, pullSecretConfigMap)] = &watchedObject{cachedClient: &notFoundCachedClient{}}
+	return kc
+}
+
 func createTestWhiteListConfigMap(cli kubernetes.Interface) error {
 	ns, err := k8s.Namespace()
 	if err != nil {
@@ -221,7 +315,7 @@ func createTestSecret(cli kubernetes.Interface, registryHost string) error {
 	auth := utils.DockerConfigJSON{
 		Auths: map[string]utils.DockerLoginCredential{
 			registryHost: {
-				utils.DockerConfigAuthKey: "dummy",
+				utils.DockerConfigAuthKey: base64.StdEncoding.EncodeToString([]byte("dummy:dummy")),
 			},
 		},
 	}
@@ -248,6 +342,124 @@ func createTestSecret(cli kubernetes.Interface, registryHost string) error {
 	return nil
 }
 
+func TestValidator_CosignSignedTagOfNotaryRepository(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	// The repository has trust data, but v2 is signed only with cosign
+	const testRegistry = "test.registry"
+	_, err = testSrv.SignImage(testSrv.URL, testRegistry, "cosign-only", "v1", strings.Repeat("1", 32))
+	require.NoError(t, err)
+
+	v := testCosignValidator(t, whv1.RegistrySpec{
+		Registry:  testRegistry,
+		Notary:    testSrv.URL,
+		SignCheck: true,
+		CosignKey: &whv1.CosignKeySource{PublicKey: testPublicKeyPem(t)},
+	}, testSrv.TLSConfig())
+
+	pod := generateTestPod(testRegistry+"/cosign-only:v2", "test", "")
+	result, err := v.CheckIsValidAndAddDigest(pod)
+	require.NoError(t, err)
+	require.True(t, result.Valid, result.Reason)
+	require.Equal(t, testRegistry+"/cosign-only:v2@sha256:"+strings.Repeat("2", 64), pod.Spec.Containers[0].Image, "pinned image")
+	decision := result.Decisions[testRegistry+"/cosign-only:v2"]
+	require.False(t, decision.Notary.Verified, "notary verified")
+	require.True(t, decision.Cosign.Verified, "cosign verified")
+}
+
+func TestValidator_CosignThresholdOverKeys(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	// Cosign signers are counted by distinct keys, so two signers cannot be verified with a key
+	const testRegistry = "test.registry"
+	v := testCosignValidator(t, whv1.RegistrySpec{
+		Registry:        testRegistry,
+		Notary:          testSrv.URL,
+		SignCheck:       true,
+		CosignKey:       &whv1.CosignKeySource{PublicKey: testPublicKeyPem(t)},
+		Signer:          []string{"*@tmax.co.kr"},
+		SignerMatchType: "glob",
+		SignerThreshold: 2,
+	}, testSrv.TLSConfig())
+
+	img := testRegistry + "/cosign-only:v1"
+	result, err := v.CheckIsValidAndAddDigest(generateTestPod(img, "test", ""))
+	require.NoError(t, err)
+	require.False(t, result.Valid)
+	require.Equal(t, fmt.Sprintf("Notary: Image '%s' is invalid\nCosign: Image '%s' cannot be verified: signer threshold 2 is greater than the number of cosign keys 1", img, img), result.Reason)
+}
+
+func TestValidator_NotaryTrustPinMissingCABundle(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	// The CA bundle of the trust pin is read from the config map, which does not exist
+	const testRegistry = "test.registry"
+	v := testCosignValidator(t, whv1.RegistrySpec{
+		Registry:  testRegistry,
+		Notary:    testSrv.URL,
+		SignCheck: true,
+		NotaryTrustPins: []whv1.NotaryTrustPin{{
+			GUNPrefix: testRegistry + "/",
+			CABundle:  &whv1.CABundleSource{ConfigMapRef: &whv1.KeyObjectReference{Namespace: "test", Name: "notary-ca"}},
+		}},
+	}, testSrv.TLSConfig())
+
+	img := testRegistry + "/cosign-only:v1"
+	result, err := v.CheckIsValidAndAddDigest(generateTestPod(img, "test", ""))
+	require.NoError(t, err)
+	require.False(t, result.Valid)
+	require.Equal(t, fmt.Sprintf("Notary: Image '%s' cannot be verified: trust pin of %s/ is invalid: configmaps test/notary-ca referenced by the registry security policy does not exist\n"+
+		"Cosign: Image '%s' is not signed, as the registry security policy has no cosign keys", img, testRegistry, img), result.Reason)
+}
+
+// testCosignValidator creates a validator with the policy of the namespace test, which fetches the notary signatures from the mock
+// notary server and accepts any image signed with cosign
+func testCosignValidator(t *testing.T, policy whv1.RegistrySpec, tlsConfig *tls.Config) *validator {
+	return &validator{
+		registryPolicyCache: &RegistryPolicyCache{clusterCachedClient: &watcherfake.CachedClient{}, namespaceCachedClient: &watcherfake.CachedClient{
+			Cache: map[string]runtime.Object{
+				"test/policy": &whv1.RegistrySecurityPolicy{
+					ObjectMeta: metav1.ObjectMeta{Namespace: "test", Name: "policy"},
+					Spec:       whv1.RegistrySecurityPolicySpec{Registries: []whv1.RegistrySpec{policy}},
+				},
+			},
+		}},
+		whiteList: &WhiteList{},
+		mirrors:   &Mirrors{},
+		keyCache: &keyCache{offlineClients: map[corev1.ResourceName]watcher.CachedClient{
+			corev1.ResourceConfigMaps: &notFoundCachedClient{},
+			corev1.ResourceSecrets:    &notFoundCachedClient{},
+			resourceServiceAccounts:   &notFoundCachedClient{},
+		}},
+		verifier: &notaryCosignVerifier{tlsConfig: tlsConfig},
+	}
+}
+
+// notaryCosignVerifier fetches the notary signatures from the mock notary server, and accepts any image signed with cosign
+type notaryCosignVerifier struct {
+	registryVerifier
+	tlsConfig *tls.Config
+}
+
+func (v *notaryCosignVerifier) FetchNotarySignature(image string, opts notary.FetchOptions) (*notary.Signature, error) {
+	opts.TLSConfig = v.tlsConfig
+	return notary.FetchSignature(image, opts)
+}
+
+func (v *notaryCosignVerifier) ResolveDigest(ref name.Reference, _ ...ociremote.Option) (name.Digest, error) {
+	return name.NewDigest(ref.Context().String() + "@sha256:" + strings.Repeat("2", 64))
+}
+
+func (v *notaryCosignVerifier) VerifyCosignSignatures(_ context.Context, _ name.Reference, _ *signer.Policy, keys []cosigns.Key, _ *cosigns.TransparencyLog, _ ...ociremote.Option) ([]oci.Signature, *cosigns.Key, error) {
+	return []oci.Signature{nil}, &keys[0], nil
+}
+
 func generateTestPod(img, ns, secretName string) *corev1.Pod {
 	pod := &corev1.Pod{
 		ObjectMeta: metav1.ObjectMeta{Namespace: ns},
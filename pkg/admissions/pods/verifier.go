@@ -0,0 +1,49 @@
+package pods
+
+import (
+	"context"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sigstore/cosign/pkg/oci"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+	cosigns "github.com/tmax-cloud/image-validating-webhook/pkg/cosign"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/notary"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// Verifier fetches and verifies the signatures of the images. The validator uses the registries and the notary servers by default,
+// and a stub may replace them, e.g., to check the manifests offline
+type Verifier interface {
+	// FetchNotarySignature fetches the notary signature of the image. It is nil if the image is not signed
+	FetchNotarySignature(image string, opts notary.FetchOptions) (*notary.Signature, error)
+	// ResolveDigest resolves the digest of the image, which the cosign signatures are verified against and the image is pinned by
+	ResolveDigest(ref name.Reference, opts ...ociremote.Option) (name.Digest, error)
+	// VerifyCosignSignatures verifies the cosign signatures of the image with the keys, and returns the key which verified them
+	VerifyCosignSignatures(ctx context.Context, ref name.Reference, signerPolicy *signer.Policy, keys []cosigns.Key, tlog *cosigns.TransparencyLog, opts ...ociremote.Option) ([]oci.Signature, *cosigns.Key, error)
+	// VerifyCosignAttestations verifies that the image of the digest has all the required attestations
+	VerifyCosignAttestations(ctx context.Context, ref name.Digest, required []whv1.AttestationSpec, keys []cosigns.Key, tlog *cosigns.TransparencyLog, opts ...ociremote.Option) error
+}
+
+// registryVerifier verifies the signatures fetched from the registries and the notary servers
+type registryVerifier struct{}
+
+// FetchNotarySignature fetches the signature from the notary server
+func (v *registryVerifier) FetchNotarySignature(image string, opts notary.FetchOptions) (*notary.Signature, error) {
+	return notary.FetchSignature(image, opts)
+}
+
+// ResolveDigest resolves the digest of the image from the registry. It is not looked up if the image has one
+func (v *registryVerifier) ResolveDigest(ref name.Reference, opts ...ociremote.Option) (name.Digest, error) {
+	return ociremote.ResolveDigest(ref, opts...)
+}
+
+// VerifyCosignSignatures verifies the signatures fetched from the registry
+func (v *registryVerifier) VerifyCosignSignatures(ctx context.Context, ref name.Reference, signerPolicy *signer.Policy, keys []cosigns.Key, tlog *cosigns.TransparencyLog, opts ...ociremote.Option) ([]oci.Signature, *cosigns.Key, error) {
+	return cosigns.Valid(ctx, ref, signerPolicy, keys, tlog, opts...)
+}
+
+// VerifyCosignAttestations verifies the attestations fetched from the registry
+func (v *registryVerifier) VerifyCosignAttestations(ctx context.Context, ref name.Digest, required []whv1.AttestationSpec, keys []cosigns.Key, tlog *cosigns.TransparencyLog, opts ...ociremote.Option) error {
+	return cosigns.ValidAttestations(ctx, ref, required, keys, tlog, opts...)
+}
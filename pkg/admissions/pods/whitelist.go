@@ -301,6 +301,12 @@ func (r *imageRef) String() string {
 	return b.String()
 }
 
+// repository returns the repository of the image, i.e., <host>/<name>, which the credentials are matched with
+func (r *imageRef) repository() string {
+	repo := imageRef{host: r.host, name: r.name}
+	return repo.String()
+}
+
 func parseImages(images []string) ([]imageRef, error) {
 	var results []imageRef
 	for _, i := range images {
@@ -3,6 +3,7 @@ package auth
 import (
 	"fmt"
 	"net/http"
+	"sync"
 	"time"
 )
 
@@ -37,13 +38,53 @@ type TokenResponse struct {
 type RegistryTransport struct {
 	Base  http.RoundTripper
 	Token *Token
+
+	// Refresh gets a new token, when the bearer token is rejected, e.g., as it is expired.
+	// The request is retried once with the new token. It is not retried if Refresh is nil
+	Refresh func(rejected *Token) (*Token, error)
+
+	lock sync.Mutex
 }
 
 // RoundTrip returns base response of cloned request
 func (t *RegistryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	token := t.getToken()
+	baseResp, err := t.roundTrip(req, token)
+	if err != nil {
+		return nil, err
+	}
+
+	// Retry once with a new token, if the bearer token is rejected
+	if baseResp.StatusCode != http.StatusUnauthorized || t.Refresh == nil || token == nil || token.Type != TokenTypeBearer {
+		return baseResp, nil
+	}
+	if req.Body != nil && req.GetBody == nil {
+		return baseResp, nil
+	}
+	newToken, err := t.Refresh(token)
+	if err != nil {
+		return baseResp, nil
+	}
+	_ = baseResp.Body.Close()
+	t.setToken(newToken)
+
+	retryReq := req
+	if req.GetBody != nil {
+		body, err := req.GetBody()
+		if err != nil {
+			return nil, err
+		}
+		retryReq = cloneRequest(req)
+		retryReq.Body = body
+	}
+	return t.roundTrip(retryReq, newToken)
+}
+
+// roundTrip sends the request with the token
+func (t *RegistryTransport) roundTrip(req *http.Request, token *Token) (*http.Response, error) {
 	clonedReq := cloneRequest(req)
-	if t.Token != nil {
-		clonedReq.Header.Set("Authorization", fmt.Sprintf("%s %s", t.Token.Type, t.Token.Value))
+	if token != nil {
+		clonedReq.Header.Set("Authorization", fmt.Sprintf("%s %s", token.Type, token.Value))
 	}
 
 	baseResp, err := t.Base.RoundTrip(clonedReq)
@@ -54,6 +95,18 @@ func (t *RegistryTransport) RoundTrip(req *http.Request) (*http.Response, error)
 	return baseResp, err
 }
 
+func (t *RegistryTransport) getToken() *Token {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	return t.Token
+}
+
+func (t *RegistryTransport) setToken(token *Token) {
+	t.lock.Lock()
+	defer t.lock.Unlock()
+	t.Token = token
+}
+
 // cloneRequest returns a clone of the provided *http.Request.
 // The clone is a shallow copy of the struct and its Header map.
 func cloneRequest(r *http.Request) *http.Request {
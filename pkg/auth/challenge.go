@@ -0,0 +1,132 @@
+package auth
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// Challenge is a challenge of the WWW-Authenticate header, e.g., Bearer realm="https://auth.docker.io/token",service="registry.docker.io"
+type Challenge struct {
+	// Scheme is the auth scheme in lower case
+	Scheme string
+	// Parameters are the auth params, whose keys are in lower case
+	Parameters map[string]string
+}
+
+// IsScheme checks if the challenge is of the token type
+func (c *Challenge) IsScheme(tokenType TokenType) bool {
+	return strings.EqualFold(c.Scheme, string(tokenType))
+}
+
+// ParseChallenges parses all the challenges of the WWW-Authenticate headers of the unauthorized response.
+// A header may have several challenges, separated by commas as RFC 7235 defines
+func ParseChallenges(resp *http.Response) []Challenge {
+	if resp.StatusCode != http.StatusUnauthorized {
+		return nil
+	}
+
+	var challenges []Challenge
+	for _, h := range resp.Header.Values("WWW-Authenticate") {
+		challenges = append(challenges, parseChallengeHeader(h)...)
+	}
+	return challenges
+}
+
+// SelectChallenge selects the challenge to answer. A bearer challenge with a realm is preferred to a basic one,
+// as the registries which advertise both issue the tokens of the narrower scope. Other schemes are not supported
+func SelectChallenge(challenges []Challenge) (*Challenge, error) {
+	var basic *Challenge
+	var schemes []string
+	for i, c := range challenges {
+		schemes = append(schemes, c.Scheme)
+		if c.IsScheme(TokenTypeBearer) && c.Parameters["realm"] != "" {
+			return &challenges[i], nil
+		}
+		if c.IsScheme(TokenTypeBasic) && basic == nil {
+			basic = &challenges[i]
+		}
+	}
+	if basic != nil {
+		return basic, nil
+	}
+	if len(challenges) == 0 {
+		return nil, fmt.Errorf("header does not contain WWW-Authenticate")
+	}
+	return nil, fmt.Errorf("there is no supported challenge in WWW-Authenticate: %s", strings.Join(schemes, ", "))
+}
+
+// parseChallengeHeader parses the challenges of a WWW-Authenticate header.
+// Each challenge is an auth scheme followed by comma-separated auth params, and the next challenge starts with a token which is not followed by '='
+func parseChallengeHeader(header string) []Challenge {
+	var challenges []Challenge
+	s := header
+	for {
+		s = skipSeparators(s)
+		scheme, rest := readToken(s)
+		if scheme == "" {
+			return challenges
+		}
+		c := Challenge{Scheme: strings.ToLower(scheme), Parameters: map[string]string{}}
+		s = rest
+
+		for {
+			key, rest := readToken(skipSeparators(s))
+			rest = strings.TrimLeft(rest, " \t")
+			if key == "" || !strings.HasPrefix(rest, "=") {
+				break
+			}
+			var value string
+			value, s = readTokenOrQuoted(strings.TrimLeft(rest[1:], " \t"))
+			c.Parameters[strings.ToLower(key)] = value
+		}
+		challenges = append(challenges, c)
+	}
+}
+
+// skipSeparators skips the spaces and the commas
+func skipSeparators(s string) string {
+	return strings.TrimLeft(s, " \t,")
+}
+
+// readToken reads a token of RFC 7230
+func readToken(s string) (string, string) {
+	i := 0
+	for ; i < len(s); i++ {
+		if !isTokenChar(s[i]) {
+			break
+		}
+	}
+	return s[:i], s[i:]
+}
+
+// readTokenOrQuoted reads either a token or a quoted string, whose escaped characters are unescaped
+func readTokenOrQuoted(s string) (string, string) {
+	if !strings.HasPrefix(s, `"`) {
+		return readToken(s)
+	}
+
+	var b strings.Builder
+	for i := 1; i < len(s); i++ {
+		switch s[i] {
+		case '"':
+			return b.String(), s[i+1:]
+		case '\\':
+			if i+1 < len(s) {
+				i++
+			}
+		}
+		b.WriteByte(s[i])
+	}
+	// Unterminated quoted string
+	return b.String(), ""
+}
+
+// isTokenChar checks if the character is allowed in a token
+func isTokenChar(c byte) bool {
+	switch {
+	case 'a' <= c && c <= 'z', 'A' <= c && c <= 'Z', '0' <= c && c <= '9':
+		return true
+	}
+	return strings.IndexByte("!#$%&'*+-.^_`|~", c) >= 0
+}
@@ -0,0 +1,97 @@
+package auth
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+)
+
+type challengeTestCase struct {
+	headers []string
+
+	expectedChallenges []Challenge
+	expectedSelected   string
+	expectedErrMsg     string
+}
+
+func TestParseChallenges(t *testing.T) {
+	tc := map[string]challengeTestCase{
+		"bearer": {
+			headers: []string{`Bearer realm="https://auth.docker.io/token",service="registry.docker.io"`},
+			expectedChallenges: []Challenge{
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://auth.docker.io/token", "service": "registry.docker.io"}},
+			},
+			expectedSelected: "bearer",
+		},
+		"noService": {
+			headers: []string{`Bearer realm="https://harbor.domain.io/service/token"`},
+			expectedChallenges: []Challenge{
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://harbor.domain.io/service/token"}},
+			},
+			expectedSelected: "bearer",
+		},
+		"basicFirstInHeaders": {
+			headers: []string{`Basic realm="Registry Realm"`, `Bearer realm="https://acr.io/oauth2/token",service="acr.io"`},
+			expectedChallenges: []Challenge{
+				{Scheme: "basic", Parameters: map[string]string{"realm": "Registry Realm"}},
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://acr.io/oauth2/token", "service": "acr.io"}},
+			},
+			expectedSelected: "bearer",
+		},
+		"basicFirstInHeader": {
+			headers: []string{`Basic realm="Registry \"Realm\", v2", BEARER Realm="https://acr.io/oauth2/token", service=acr.io`},
+			expectedChallenges: []Challenge{
+				{Scheme: "basic", Parameters: map[string]string{"realm": `Registry "Realm", v2`}},
+				{Scheme: "bearer", Parameters: map[string]string{"realm": "https://acr.io/oauth2/token", "service": "acr.io"}},
+			},
+			expectedSelected: "bearer",
+		},
+		"basicOnly": {
+			headers: []string{`Basic realm="Registry Realm"`},
+			expectedChallenges: []Challenge{
+				{Scheme: "basic", Parameters: map[string]string{"realm": "Registry Realm"}},
+			},
+			expectedSelected: "basic",
+		},
+		"bearerWithoutRealm": {
+			headers: []string{`Bearer service="registry.docker.io"`, `Basic`},
+			expectedChallenges: []Challenge{
+				{Scheme: "bearer", Parameters: map[string]string{"service": "registry.docker.io"}},
+				{Scheme: "basic", Parameters: map[string]string{}},
+			},
+			expectedSelected: "basic",
+		},
+		"unsupported": {
+			headers: []string{`Negotiate abc==`},
+			expectedChallenges: []Challenge{
+				{Scheme: "negotiate", Parameters: map[string]string{"abc": ""}},
+			},
+			expectedErrMsg: "there is no supported challenge in WWW-Authenticate: negotiate",
+		},
+		"noHeader": {
+			expectedErrMsg: "header does not contain WWW-Authenticate",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			resp := &http.Response{StatusCode: http.StatusUnauthorized, Header: http.Header{}}
+			for _, h := range c.headers {
+				resp.Header.Add("WWW-Authenticate", h)
+			}
+
+			challenges := ParseChallenges(resp)
+			require.Equal(t, c.expectedChallenges, challenges)
+
+			selected, err := SelectChallenge(challenges)
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedSelected, selected.Scheme)
+		})
+	}
+}
@@ -0,0 +1,275 @@
+package auth
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	regclient "github.com/docker/distribution/registry/client"
+)
+
+const (
+	// defaultTokenExpiresIn is the lifetime of a token whose expires_in is not given, as the token spec of docker defines
+	defaultTokenExpiresIn = 60 * time.Second
+
+	// tokenExpiryLeeway is the time before the expiry, from which a cached token is not used anymore
+	tokenExpiryLeeway = 5 * time.Second
+
+	// tokenRetention is the time after the expiry, until which the refresh token of an expired token is kept
+	tokenRetention = 1 * time.Hour
+
+	// oauthClientID is the client id of the webhook for the OAuth2 token requests
+	oauthClientID = "image-validating-webhook"
+)
+
+// TokenRequest is a request of a bearer token to the token server
+type TokenRequest struct {
+	Realm   string
+	Service string
+	Scope   string
+
+	// BasicAuth is username:password string encrypted by base64. The token is requested anonymously if it is empty
+	BasicAuth string
+	// RefreshToken is the refresh token of OAuth2, e.g., the identity token of the docker config.
+	// The token is requested by the refresh token grant if it is set
+	RefreshToken string
+}
+
+// PullScope returns the scope for pulling the repository, which is the only one the webhook needs
+func PullScope(repository string) string {
+	return fmt.Sprintf("repository:%s:pull", repository)
+}
+
+// TokenCache caches the bearer tokens until they expire, which is safe for concurrent use.
+// Tokens are keyed by the realm, the service, the scope and the credential of the requests
+type TokenCache struct {
+	lock   sync.Mutex
+	tokens map[tokenKey]*cachedToken
+
+	// now is for the test purpose
+	now func() time.Time
+}
+
+// tokenKey is a key of the cached token. The credential is hashed, so that it is not kept in the cache
+type tokenKey struct {
+	realm      string
+	service    string
+	scope      string
+	credential [sha256.Size]byte
+}
+
+// cachedToken is a token of the cache with its expiry.
+// The refresh token issued with the token is used to get the next token, instead of the credential
+type cachedToken struct {
+	token        *Token
+	expires      time.Time
+	refreshToken string
+}
+
+// NewTokenCache creates a token cache
+func NewTokenCache() *TokenCache {
+	return &TokenCache{
+		tokens: map[tokenKey]*cachedToken{},
+		now:    time.Now,
+	}
+}
+
+// Get returns the cached token of the request. A new token is fetched from the token server if there is none or if it is expired.
+// The refresh token issued with the expired token is tried first, if there is one
+func (c *TokenCache) Get(client *http.Client, req TokenRequest) (*Token, error) {
+	key := newTokenKey(req)
+
+	c.lock.Lock()
+	cached, exist := c.tokens[key]
+	c.lock.Unlock()
+	if exist && c.now().Before(cached.expires) {
+		return cached.token, nil
+	}
+
+	now := c.now()
+	var resp *TokenResponse
+	var err error
+	if exist && cached.refreshToken != "" && req.RefreshToken == "" {
+		refreshReq := req
+		refreshReq.RefreshToken = cached.refreshToken
+		resp, err = FetchToken(client, refreshReq)
+	}
+	if resp == nil {
+		resp, err = FetchToken(client, req)
+	}
+	if err != nil {
+		return nil, err
+	}
+	token := &Token{Type: TokenTypeBearer, Value: resp.token()}
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	refreshToken := resp.RefreshToken
+	if refreshToken == "" && exist {
+		refreshToken = cached.refreshToken
+	}
+	c.tokens[key] = &cachedToken{token: token, expires: resp.expires(now).Add(-tokenExpiryLeeway), refreshToken: refreshToken}
+	// Drop the tokens expired long ago, so that the cache does not grow
+	for k, t := range c.tokens {
+		if now.Sub(t.expires) > tokenRetention {
+			delete(c.tokens, k)
+		}
+	}
+	return token, nil
+}
+
+// Invalidate expires the cached token of the request if it is the rejected one, e.g., as it is expired earlier than it says.
+// A token which is fetched again in the meantime is kept
+func (c *TokenCache) Invalidate(req TokenRequest, rejected *Token) {
+	key := newTokenKey(req)
+
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	if cached, exist := c.tokens[key]; exist && (rejected == nil || *cached.token == *rejected) {
+		// The refresh token is kept, as only the token is rejected
+		cached.expires = c.now()
+	}
+}
+
+// newTokenKey returns the key of the request
+func newTokenKey(req TokenRequest) tokenKey {
+	return tokenKey{
+		realm:      req.Realm,
+		service:    req.Service,
+		scope:      req.Scope,
+		credential: sha256.Sum256([]byte(req.BasicAuth + ":" + req.RefreshToken)),
+	}
+}
+
+// FetchToken fetches a bearer token from the token server, as the token spec of the docker registry defines.
+// The token is requested by OAuth2 POST with the refresh token grant if there is a refresh token.
+// Otherwise, it is requested by GET with the basic auth, and by OAuth2 POST with the password grant if the server does not support GET
+func FetchToken(client *http.Client, req TokenRequest) (*TokenResponse, error) {
+	if req.RefreshToken != "" {
+		return fetchOAuthToken(client, req, url.Values{
+			"grant_type":    {"refresh_token"},
+			"refresh_token": {req.RefreshToken},
+		})
+	}
+
+	token, status, err := fetchBasicToken(client, req)
+	if err != nil && req.BasicAuth != "" && (status == http.StatusNotFound || status == http.StatusMethodNotAllowed) {
+		username, password, err := decodeBasicAuth(req.BasicAuth)
+		if err != nil {
+			return nil, err
+		}
+		return fetchOAuthToken(client, req, url.Values{
+			"grant_type":  {"password"},
+			"username":    {username},
+			"password":    {password},
+			"access_type": {"offline"},
+		})
+	}
+	return token, err
+}
+
+// fetchBasicToken fetches a token by GET with the basic auth. The status code is returned with the error
+func fetchBasicToken(client *http.Client, req TokenRequest) (*TokenResponse, int, error) {
+	tokenReq, err := http.NewRequest(http.MethodGet, req.Realm, nil)
+	if err != nil {
+		return nil, 0, err
+	}
+	tokenQ := tokenReq.URL.Query()
+	if req.Service != "" {
+		tokenQ.Add("service", req.Service)
+	}
+	if req.Scope != "" {
+		tokenQ.Add("scope", req.Scope)
+	}
+	if req.BasicAuth != "" {
+		tokenReq.Header.Set("Authorization", fmt.Sprintf("%s %s", TokenTypeBasic, req.BasicAuth))
+		// Refresh token is requested, so that the next token is requested without the credential
+		tokenQ.Add("offline_token", "true")
+		tokenQ.Add("client_id", oauthClientID)
+	}
+	tokenReq.URL.RawQuery = tokenQ.Encode()
+
+	return doTokenRequest(client, tokenReq, req.Realm)
+}
+
+// fetchOAuthToken fetches a token by OAuth2 POST with the grant
+func fetchOAuthToken(client *http.Client, req TokenRequest, form url.Values) (*TokenResponse, error) {
+	form.Set("client_id", oauthClientID)
+	if req.Service != "" {
+		form.Set("service", req.Service)
+	}
+	if req.Scope != "" {
+		form.Set("scope", req.Scope)
+	}
+	tokenReq, err := http.NewRequest(http.MethodPost, req.Realm, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	tokenReq.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	token, _, err := doTokenRequest(client, tokenReq, req.Realm)
+	return token, err
+}
+
+// doTokenRequest sends the token request and decodes the token response
+func doTokenRequest(client *http.Client, tokenReq *http.Request, realm string) (*TokenResponse, int, error) {
+	tokenResp, err := client.Do(tokenReq)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer func() {
+		_ = tokenResp.Body.Close()
+	}()
+	if !regclient.SuccessStatus(tokenResp.StatusCode) {
+		return nil, tokenResp.StatusCode, regclient.HandleErrorResponse(tokenResp)
+	}
+
+	token := &TokenResponse{}
+	if err := json.NewDecoder(tokenResp.Body).Decode(token); err != nil {
+		return nil, tokenResp.StatusCode, err
+	}
+	if token.token() == "" {
+		return nil, tokenResp.StatusCode, fmt.Errorf("token server %s returned neither token nor access_token", realm)
+	}
+	return token, tokenResp.StatusCode, nil
+}
+
+// decodeBasicAuth decodes username:password string encrypted by base64
+func decodeBasicAuth(basicAuth string) (string, string, error) {
+	b, err := base64.StdEncoding.DecodeString(basicAuth)
+	if err != nil {
+		return "", "", err
+	}
+	tokens := strings.SplitN(string(b), ":", 2)
+	if len(tokens) != 2 {
+		return "", "", fmt.Errorf("basic auth is not in username:password form")
+	}
+	return tokens[0], tokens[1], nil
+}
+
+// token returns the token, which is either in token or in the access_token of OAuth2
+func (r *TokenResponse) token() string {
+	if r.Token != "" {
+		return r.Token
+	}
+	return r.AccessToken
+}
+
+// expires returns the expiry of the token. It is issued at the time of the request if issued_at is not given
+func (r *TokenResponse) expires(requested time.Time) time.Time {
+	issuedAt := r.IssuedAt
+	if issuedAt.IsZero() {
+		issuedAt = requested
+	}
+	expiresIn := time.Duration(r.ExpiresIn) * time.Second
+	if expiresIn <= 0 {
+		expiresIn = defaultTokenExpiresIn
+	}
+	return issuedAt.Add(expiresIn)
+}
@@ -0,0 +1,372 @@
+package auth
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+)
+
+type tokenCacheTestCase struct {
+	response string
+	requests []TokenRequest
+	elapsed  time.Duration
+
+	expectedToken   string
+	expectedFetches int32
+	expectedErrMsg  string
+}
+
+func TestTokenCache_Get(t *testing.T) {
+	pullReq := TokenRequest{Service: "notary", Scope: PullScope("test.io/repo"), BasicAuth: "dGVzdDp0ZXN0"}
+	otherScopeReq := pullReq
+	otherScopeReq.Scope = PullScope("test.io/other-repo")
+	otherCredReq := pullReq
+	otherCredReq.BasicAuth = ""
+
+	tc := map[string]tokenCacheTestCase{
+		"cached": {
+			response:        `{"token": "test-token", "expires_in": 300}`,
+			requests:        []TokenRequest{pullReq, pullReq},
+			elapsed:         4 * time.Minute,
+			expectedToken:   "test-token",
+			expectedFetches: 1,
+		},
+		"expired": {
+			response:        `{"token": "test-token", "expires_in": 300}`,
+			requests:        []TokenRequest{pullReq, pullReq},
+			elapsed:         5 * time.Minute,
+			expectedToken:   "test-token",
+			expectedFetches: 2,
+		},
+		"defaultExpiresIn": {
+			response:        `{"token": "test-token"}`,
+			requests:        []TokenRequest{pullReq, pullReq},
+			elapsed:         time.Minute,
+			expectedToken:   "test-token",
+			expectedFetches: 2,
+		},
+		"accessToken": {
+			response:        `{"access_token": "test-access-token", "expires_in": 300}`,
+			requests:        []TokenRequest{pullReq, pullReq},
+			expectedToken:   "test-access-token",
+			expectedFetches: 1,
+		},
+		"otherScope": {
+			response:        `{"token": "test-token", "expires_in": 300}`,
+			requests:        []TokenRequest{pullReq, otherScopeReq},
+			expectedToken:   "test-token",
+			expectedFetches: 2,
+		},
+		"otherCredential": {
+			response:        `{"token": "test-token", "expires_in": 300}`,
+			requests:        []TokenRequest{pullReq, otherCredReq},
+			expectedToken:   "test-token",
+			expectedFetches: 2,
+		},
+		"noToken": {
+			response:        `{"expires_in": 300}`,
+			requests:        []TokenRequest{pullReq},
+			expectedFetches: 1,
+			expectedErrMsg:  "returned neither token nor access_token",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			var fetches int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&fetches, 1)
+				_, _ = w.Write([]byte(c.response))
+			}))
+			defer srv.Close()
+
+			now := time.Now()
+			cache := NewTokenCache()
+			cache.now = func() time.Time { return now }
+
+			var token *Token
+			var err error
+			for _, req := range c.requests {
+				req.Realm = srv.URL
+				token, err = cache.Get(srv.Client(), req)
+				now = now.Add(c.elapsed)
+			}
+			require.Equal(t, c.expectedFetches, atomic.LoadInt32(&fetches), "fetches")
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expectedErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, &Token{Type: TokenTypeBearer, Value: c.expectedToken}, token)
+		})
+	}
+}
+
+type fetchTokenTestCase struct {
+	postOnly bool
+	req      TokenRequest
+
+	expectedToken        string
+	expectedRefreshToken string
+	expectedRequests     []string
+	expectedErrMsg       string
+}
+
+func TestFetchToken(t *testing.T) {
+	tc := map[string]fetchTokenTestCase{
+		"get": {
+			req:                  TokenRequest{Service: "notary", Scope: PullScope("test.io/repo"), BasicAuth: testBasicAuth},
+			expectedToken:        "get-token/notary/repository:test.io/repo:pull",
+			expectedRefreshToken: "refresh-1",
+			expectedRequests:     []string{"GET"},
+		},
+		"getAnonymous": {
+			req:              TokenRequest{Scope: PullScope("test.io/repo")},
+			expectedToken:    "get-token//repository:test.io/repo:pull",
+			expectedRequests: []string{"GET"},
+		},
+		"getUnauthorized": {
+			req:              TokenRequest{Service: "notary", BasicAuth: "d3Jvbmc6d3Jvbmc="},
+			expectedRequests: []string{"GET"},
+			expectedErrMsg:   "unauthorized: authentication required",
+		},
+		"postPassword": {
+			postOnly:             true,
+			req:                  TokenRequest{Service: "notary", Scope: PullScope("test.io/repo"), BasicAuth: testBasicAuth},
+			expectedToken:        "post-token/password/notary/repository:test.io/repo:pull",
+			expectedRefreshToken: "refresh-1",
+			expectedRequests:     []string{"GET", "POST password"},
+		},
+		"postAnonymous": {
+			postOnly:         true,
+			req:              TokenRequest{Service: "notary"},
+			expectedRequests: []string{"GET"},
+			expectedErrMsg:   "405",
+		},
+		"refreshToken": {
+			req:                  TokenRequest{Service: "notary", Scope: PullScope("test.io/repo"), BasicAuth: testBasicAuth, RefreshToken: "identity-token"},
+			expectedToken:        "post-token/refresh_token/notary/repository:test.io/repo:pull",
+			expectedRefreshToken: "refresh-1",
+			expectedRequests:     []string{"POST refresh_token"},
+		},
+		"invalidRefreshToken": {
+			req:              TokenRequest{Service: "notary", RefreshToken: "invalid-token"},
+			expectedRequests: []string{"POST refresh_token"},
+			expectedErrMsg:   "unauthorized: authentication required",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			srv := newTestAuthServer(c.postOnly)
+			defer srv.Close()
+
+			req := c.req
+			req.Realm = srv.URL
+			resp, err := FetchToken(srv.Client(), req)
+			require.Equal(t, c.expectedRequests, srv.requests, "requests")
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expectedErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedToken, resp.token(), "token")
+			require.Equal(t, c.expectedRefreshToken, resp.RefreshToken, "refresh token")
+		})
+	}
+}
+
+func TestTokenCache_RefreshToken(t *testing.T) {
+	srv := newTestAuthServer(false)
+	defer srv.Close()
+
+	now := time.Now()
+	cache := NewTokenCache()
+	cache.now = func() time.Time { return now }
+
+	req := TokenRequest{Realm: srv.URL, Service: "notary", Scope: PullScope("test.io/repo"), BasicAuth: testBasicAuth}
+	_, err := cache.Get(srv.Client(), req)
+	require.NoError(t, err)
+
+	// Next token is requested with the refresh token, which is rotated
+	now = now.Add(time.Minute)
+	token, err := cache.Get(srv.Client(), req)
+	require.NoError(t, err)
+	require.Equal(t, "post-token/refresh_token/notary/repository:test.io/repo:pull", token.Value)
+
+	// Credential is used again if the refresh token is revoked
+	srv.revoke()
+	now = now.Add(time.Minute)
+	token, err = cache.Get(srv.Client(), req)
+	require.NoError(t, err)
+	require.Equal(t, "get-token/notary/repository:test.io/repo:pull", token.Value)
+
+	require.Equal(t, []string{"GET", "POST refresh_token", "POST refresh_token", "GET"}, srv.requests)
+}
+
+const testBasicAuth = "dGVzdDp0ZXN0"
+
+// testAuthServer is a fake token server of the docker registry token spec, which issues rotating refresh tokens
+type testAuthServer struct {
+	*httptest.Server
+
+	postOnly bool
+
+	lock          sync.Mutex
+	requests      []string
+	refreshTokens map[string]bool
+	issued        int
+}
+
+func newTestAuthServer(postOnly bool) *testAuthServer {
+	s := &testAuthServer{postOnly: postOnly, refreshTokens: map[string]bool{"identity-token": true}}
+	s.Server = httptest.NewServer(http.HandlerFunc(s.handle))
+	return s
+}
+
+func (s *testAuthServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	resp := TokenResponse{ExpiresIn: 60}
+	switch r.Method {
+	case http.MethodGet:
+		s.requests = append(s.requests, "GET")
+		if s.postOnly {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		q := r.URL.Query()
+		if auth := r.Header.Get("Authorization"); auth != "" && auth != "Basic "+testBasicAuth {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp.Token = fmt.Sprintf("get-token/%s/%s", q.Get("service"), q.Get("scope"))
+		if q.Get("offline_token") == "true" && q.Get("client_id") == oauthClientID {
+			resp.RefreshToken = s.issueRefreshToken()
+		}
+	case http.MethodPost:
+		grantType := r.PostFormValue("grant_type")
+		s.requests = append(s.requests, "POST "+grantType)
+		switch {
+		case r.PostFormValue("client_id") != oauthClientID:
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		case grantType == "password" && r.PostFormValue("username") == "test" && r.PostFormValue("password") == "test":
+		case grantType == "refresh_token" && s.refreshTokens[r.PostFormValue("refresh_token")]:
+			delete(s.refreshTokens, r.PostFormValue("refresh_token"))
+		default:
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		resp.AccessToken = fmt.Sprintf("post-token/%s/%s/%s", grantType, r.PostFormValue("service"), r.PostFormValue("scope"))
+		resp.RefreshToken = s.issueRefreshToken()
+	}
+	_ = json.NewEncoder(w).Encode(resp)
+}
+
+func (s *testAuthServer) issueRefreshToken() string {
+	s.issued++
+	token := fmt.Sprintf("refresh-%d", s.issued)
+	s.refreshTokens[token] = true
+	return token
+}
+
+// revoke revokes all the refresh tokens
+func (s *testAuthServer) revoke() {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	s.refreshTokens = map[string]bool{}
+}
+
+type registryTransportRefreshTestCase struct {
+	token   *Token
+	refresh func(*Token) (*Token, error)
+
+	expectedStatus   int
+	expectedRequests int32
+}
+
+func TestRegistryTransport_Refresh(t *testing.T) {
+	newToken := &Token{Type: TokenTypeBearer, Value: "new-token"}
+	refresh := func(rejected *Token) (*Token, error) {
+		if rejected.Value != "expired-token" {
+			return nil, fmt.Errorf("unexpected token %s", rejected.Value)
+		}
+		return newToken, nil
+	}
+
+	tc := map[string]registryTransportRefreshTestCase{
+		"valid": {
+			token:            newToken,
+			refresh:          refresh,
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 1,
+		},
+		"expired": {
+			token:            &Token{Type: TokenTypeBearer, Value: "expired-token"},
+			refresh:          refresh,
+			expectedStatus:   http.StatusOK,
+			expectedRequests: 2,
+		},
+		"noRefresh": {
+			token:            &Token{Type: TokenTypeBearer, Value: "expired-token"},
+			expectedStatus:   http.StatusUnauthorized,
+			expectedRequests: 1,
+		},
+		"refreshFails": {
+			token: &Token{Type: TokenTypeBearer, Value: "expired-token"},
+			refresh: func(*Token) (*Token, error) {
+				return nil, fmt.Errorf("token server is down")
+			},
+			expectedStatus:   http.StatusUnauthorized,
+			expectedRequests: 1,
+		},
+		"retryOnce": {
+			token: &Token{Type: TokenTypeBearer, Value: "expired-token"},
+			refresh: func(*Token) (*Token, error) {
+				return &Token{Type: TokenTypeBearer, Value: "other-expired-token"}, nil
+			},
+			expectedStatus:   http.StatusUnauthorized,
+			expectedRequests: 2,
+		},
+		"basic": {
+			token:            &Token{Type: TokenTypeBasic, Value: "expired-token"},
+			refresh:          refresh,
+			expectedStatus:   http.StatusUnauthorized,
+			expectedRequests: 1,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&requests, 1)
+				if r.Header.Get("Authorization") != "Bearer new-token" {
+					w.WriteHeader(http.StatusUnauthorized)
+				}
+			}))
+			defer srv.Close()
+
+			rt := &RegistryTransport{Base: http.DefaultTransport, Token: c.token, Refresh: c.refresh}
+			req, err := http.NewRequest(http.MethodGet, srv.URL, nil)
+			require.NoError(t, err)
+			resp, err := rt.RoundTrip(req)
+			require.NoError(t, err)
+			_ = resp.Body.Close()
+
+			require.Equal(t, c.expectedStatus, resp.StatusCode, "status")
+			require.Equal(t, c.expectedRequests, atomic.LoadInt32(&requests), "requests")
+		})
+	}
+}
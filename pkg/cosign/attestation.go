@@ -0,0 +1,274 @@
+package cosign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/in-toto/in-toto-golang/in_toto"
+	slsa "github.com/in-toto/in-toto-golang/in_toto/slsa_provenance/v0.2"
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/cosign/pkg/cosign/attestation"
+	"github.com/sigstore/cosign/pkg/oci"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+	"github.com/sigstore/sigstore/pkg/signature"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// Predicate types which can be used as a shorthand of the predicate URI
+const (
+	PredicateCustom    = "custom"
+	PredicateSLSA      = "slsaprovenance"
+	PredicateSPDX      = "spdx"
+	PredicateCycloneDX = "cyclonedx"
+	PredicateLink      = "link"
+	PredicateVuln      = "vuln"
+)
+
+// predicateTypeMap is the mapping between the predicate type shorthand and the predicate URI.
+// spdx is for both the tag-value and the JSON documents, as cosign attests them with the same predicate URI
+var predicateTypeMap = map[string]string{
+	PredicateCustom:    attestation.CosignCustomProvenanceV01,
+	PredicateSLSA:      slsa.PredicateSLSAProvenance,
+	PredicateSPDX:      in_toto.PredicateSPDX,
+	PredicateCycloneDX: in_toto.PredicateCycloneDX,
+	PredicateLink:      in_toto.PredicateLinkV1,
+	PredicateVuln:      attestation.CosignVulnProvenanceV01,
+}
+
+// For testing
+var cosignVerifyAttestations = cosign.VerifyImageAttestations
+
+// statement is an in-toto statement, whose predicate is kept raw to be parsed per predicate type
+type statement struct {
+	PredicateType string          `json:"predicateType"`
+	Predicate     json.RawMessage `json:"predicate"`
+
+	// signedAt is the integrated time of the rekor bundle of the attestation. It is nil if there is no bundle
+	signedAt *time.Time
+}
+
+// predicateFields are the fields of well-known predicates, which are used to evaluate AttestationSpec
+type predicateFields struct {
+	// Builder is set for slsaprovenance predicates
+	Builder struct {
+		ID string `json:"id"`
+	} `json:"builder"`
+	// Metadata is set for slsaprovenance, vuln and cyclonedx predicates
+	Metadata struct {
+		BuildFinishedOn *time.Time `json:"buildFinishedOn"`
+		ScanFinishedOn  *time.Time `json:"scanFinishedOn"`
+		Timestamp       *time.Time `json:"timestamp"`
+	} `json:"metadata"`
+	// CreationInfo is set for spdx predicates
+	CreationInfo struct {
+		Created *time.Time `json:"created"`
+	} `json:"creationInfo"`
+	// Timestamp is set for custom predicates
+	Timestamp string `json:"Timestamp"`
+}
+
+// ValidAttestations checks if the image of the digest has verified attestations satisfying all the required attestations.
+// The digest should be the one whose signatures are verified, so that the signatures and the attestations are of the same image.
+// Attestations verified by any of the keys are used, so that the required attestations may be signed by different keys
+func ValidAttestations(ctx context.Context, ref name.Digest, required []whv1.AttestationSpec, keys []Key, tlog *TransparencyLog, opts ...ociremote.Option) error {
+	if len(required) == 0 {
+		return nil
+	}
+	if len(keys) == 0 {
+		return errors.New("there are no keys to verify attestations")
+	}
+
+	var statements []statement
+	var lastErr error
+	for _, k := range keys {
+		verifier, err := signature.LoadVerifier(k.PublicKey, crypto.SHA256)
+		if err != nil {
+			validLog.Error(err, "Error creating verifier")
+			lastErr = err
+			continue
+		}
+
+		co := &cosign.CheckOpts{
+			RegistryClientOpts: opts,
+			SigVerifier:        verifier,
+			ClaimVerifier:      cosign.IntotoSubjectClaimVerifier,
+		}
+		var atts []oci.Signature
+		if tlog != nil {
+			// verify rekor bundles offline
+			atts, err = tlog.verifyAttestations(ctx, ref, co)
+		} else {
+			atts, _, err = cosignVerifyAttestations(ctx, ref, co)
+		}
+		if err != nil {
+			validLog.Error(err, "Error validating attestations")
+			lastErr = err
+			continue
+		}
+
+		// Attestations made with a revoked key or out of the key's validity period are rejected
+		atts, err = k.filterValid(atts, time.Now())
+		if err != nil {
+			lastErr = err
+			continue
+		}
+
+		keyStatements, err := parseStatements(atts)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		statements = append(statements, keyStatements...)
+	}
+	if len(statements) == 0 && lastErr != nil {
+		return lastErr
+	}
+	return matchAttestations(statements, required, time.Now())
+}
+
+// parseStatements decodes in-toto statements from the DSSE envelopes of the verified attestations
+func parseStatements(atts []oci.Signature) ([]statement, error) {
+	var statements []statement
+	for _, att := range atts {
+		payload, err := att.Payload()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting attestation payload")
+		}
+
+		envelope := struct {
+			Payload string `json:"payload"`
+		}{}
+		if err := json.Unmarshal(payload, &envelope); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling attestation envelope")
+		}
+		decoded, err := base64.StdEncoding.DecodeString(envelope.Payload)
+		if err != nil {
+			return nil, errors.Wrap(err, "decoding attestation payload")
+		}
+
+		s := statement{}
+		if err := json.Unmarshal(decoded, &s); err != nil {
+			return nil, errors.Wrap(err, "unmarshaling in-toto statement")
+		}
+
+		b, err := att.Bundle()
+		if err != nil {
+			return nil, errors.Wrap(err, "getting attestation bundle")
+		}
+		if b != nil {
+			signedAt := time.Unix(b.Payload.IntegratedTime, 0)
+			s.signedAt = &signedAt
+		}
+		statements = append(statements, s)
+	}
+	return statements, nil
+}
+
+// matchAttestations checks if every required attestation is satisfied by at least one of the statements
+func matchAttestations(statements []statement, required []whv1.AttestationSpec, now time.Time) error {
+	for _, req := range required {
+		predicateURI, ok := predicateTypeMap[req.PredicateType]
+		if !ok {
+			predicateURI = req.PredicateType
+		}
+
+		var lastErr error
+		found := false
+		for _, s := range statements {
+			if s.PredicateType != predicateURI {
+				continue
+			}
+			if err := matchPredicate(s, req, now); err != nil {
+				lastErr = err
+				continue
+			}
+			found = true
+			break
+		}
+		if !found {
+			if lastErr != nil {
+				return lastErr
+			}
+			return fmt.Errorf("there is no %s attestation", req.PredicateType)
+		}
+	}
+	return nil
+}
+
+// matchPredicate evaluates the conditions of the required attestation against the statement's predicate
+func matchPredicate(s statement, req whv1.AttestationSpec, now time.Time) error {
+	// Predicates are not always JSON objects, e.g., the spdx documents of the tag-value format are strings
+	fields := predicateFields{}
+	if bytes.HasPrefix(bytes.TrimSpace(s.Predicate), []byte("{")) {
+		if err := json.Unmarshal(s.Predicate, &fields); err != nil {
+			return errors.Wrapf(err, "unmarshaling %s predicate", req.PredicateType)
+		}
+	}
+
+	if len(req.BuilderIDs) > 0 {
+		if s.PredicateType != slsa.PredicateSLSAProvenance {
+			return fmt.Errorf("builderIDs is only supported for %s attestations", PredicateSLSA)
+		}
+		matched := false
+		for _, id := range req.BuilderIDs {
+			if id == fields.Builder.ID {
+				matched = true
+				break
+			}
+		}
+		if !matched {
+			return fmt.Errorf("builder '%s' of %s attestation is not allowed", fields.Builder.ID, req.PredicateType)
+		}
+	}
+
+	if req.MaxAge != nil {
+		issued, err := predicateTime(s, fields)
+		if err != nil {
+			return err
+		}
+		if now.Sub(issued) > req.MaxAge.Duration {
+			return fmt.Errorf("%s attestation is older than %s", req.PredicateType, req.MaxAge.Duration)
+		}
+	}
+
+	return nil
+}
+
+// predicateTime returns the time when the predicate is generated. It is the signing time of the attestation, if the predicate does
+// not have its own time, e.g., link predicates
+func predicateTime(s statement, fields predicateFields) (time.Time, error) {
+	var t *time.Time
+	switch s.PredicateType {
+	case slsa.PredicateSLSAProvenance:
+		t = fields.Metadata.BuildFinishedOn
+	case attestation.CosignVulnProvenanceV01:
+		t = fields.Metadata.ScanFinishedOn
+	case in_toto.PredicateSPDX:
+		t = fields.CreationInfo.Created
+	case in_toto.PredicateCycloneDX:
+		t = fields.Metadata.Timestamp
+	case attestation.CosignCustomProvenanceV01:
+		if fields.Timestamp != "" {
+			parsed, err := time.Parse(time.RFC3339, fields.Timestamp)
+			if err != nil {
+				return time.Time{}, errors.Wrap(err, "parsing custom predicate timestamp")
+			}
+			t = &parsed
+		}
+	}
+	if t == nil {
+		t = s.signedAt
+	}
+
+	if t == nil {
+		return time.Time{}, fmt.Errorf("%s attestation does not have a timestamp", s.PredicateType)
+	}
+	return *t, nil
+}
@@ -0,0 +1,280 @@
+package cosign
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/cosign/pkg/cosign/bundle"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/static"
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	testBuilderID = "https://github.com/tmax-cloud/ci"
+)
+
+type matchAttestationsTestCase struct {
+	statements []statement
+	required   []whv1.AttestationSpec
+
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestMatchAttestations(t *testing.T) {
+	now := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+
+	tc := map[string]matchAttestationsTestCase{
+		"noRequired": {
+			statements: nil,
+			required:   nil,
+		},
+		"noAttestation": {
+			statements: nil,
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateSLSA}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "there is no slsaprovenance attestation",
+		},
+		"otherPredicate": {
+			statements: []statement{testStatement(t, "https://spdx.dev/Document", map[string]interface{}{})},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateSLSA}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "there is no slsaprovenance attestation",
+		},
+		"predicateURI": {
+			statements: []statement{testStatement(t, "https://spdx.dev/Document", map[string]interface{}{})},
+			required:   []whv1.AttestationSpec{{PredicateType: "https://spdx.dev/Document"}},
+		},
+		"builderAllowed": {
+			statements: []statement{testSLSAStatement(t, testBuilderID, now)},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateSLSA, BuilderIDs: []string{"other", testBuilderID}}},
+		},
+		"builderNotAllowed": {
+			statements: []statement{testSLSAStatement(t, "https://untrusted", now)},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateSLSA, BuilderIDs: []string{testBuilderID}}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "builder 'https://untrusted' of slsaprovenance attestation is not allowed",
+		},
+		"builderOneOfMany": {
+			statements: []statement{
+				testSLSAStatement(t, "https://untrusted", now),
+				testSLSAStatement(t, testBuilderID, now),
+			},
+			required: []whv1.AttestationSpec{{PredicateType: PredicateSLSA, BuilderIDs: []string{testBuilderID}}},
+		},
+		"builderNotSLSA": {
+			statements: []statement{testVulnStatement(t, now)},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateVuln, BuilderIDs: []string{testBuilderID}}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "builderIDs is only supported for slsaprovenance attestations",
+		},
+		"scanFresh": {
+			statements: []statement{testVulnStatement(t, now.Add(-24*time.Hour))},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateVuln, MaxAge: &metav1.Duration{Duration: 7 * 24 * time.Hour}}},
+		},
+		"scanStale": {
+			statements: []statement{testVulnStatement(t, now.Add(-8*24*time.Hour))},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateVuln, MaxAge: &metav1.Duration{Duration: 7 * 24 * time.Hour}}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "vuln attestation is older than 168h0m0s",
+		},
+		"noTimestamp": {
+			statements: []statement{testStatement(t, "https://slsa.dev/provenance/v0.2", map[string]interface{}{})},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateSLSA, MaxAge: &metav1.Duration{Duration: time.Hour}}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "https://slsa.dev/provenance/v0.2 attestation does not have a timestamp",
+		},
+		"spdxTagValue": {
+			statements: []statement{testStatement(t, "https://spdx.dev/Document", "SPDXVersion: SPDX-2.2")},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateSPDX}},
+		},
+		"cyclonedxFresh": {
+			statements: []statement{testStatement(t, "https://cyclonedx.org/schema", map[string]interface{}{
+				"metadata": map[string]interface{}{"timestamp": now.Add(-time.Hour)},
+			})},
+			required: []whv1.AttestationSpec{{PredicateType: PredicateCycloneDX, MaxAge: &metav1.Duration{Duration: 2 * time.Hour}}},
+		},
+		"linkSignedFresh": {
+			statements: []statement{testSignedStatement(testStatement(t, "https://in-toto.io/Link/v1", map[string]interface{}{}), now.Add(-time.Hour))},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateLink, MaxAge: &metav1.Duration{Duration: 2 * time.Hour}}},
+		},
+		"linkSignedStale": {
+			statements: []statement{testSignedStatement(testStatement(t, "https://in-toto.io/Link/v1", map[string]interface{}{}), now.Add(-3*time.Hour))},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateLink, MaxAge: &metav1.Duration{Duration: 2 * time.Hour}}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "link attestation is older than 2h0m0s",
+		},
+		"linkNotSigned": {
+			statements: []statement{testStatement(t, "https://in-toto.io/Link/v1", map[string]interface{}{})},
+			required:   []whv1.AttestationSpec{{PredicateType: PredicateLink, MaxAge: &metav1.Duration{Duration: 2 * time.Hour}}},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "https://in-toto.io/Link/v1 attestation does not have a timestamp",
+		},
+		"multipleRequired": {
+			statements: []statement{testSLSAStatement(t, testBuilderID, now)},
+			required: []whv1.AttestationSpec{
+				{PredicateType: PredicateSLSA, BuilderIDs: []string{testBuilderID}},
+				{PredicateType: PredicateVuln},
+			},
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "there is no vuln attestation",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			err := matchAttestations(c.statements, c.required, now)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func testStatement(t *testing.T, predicateType string, predicate interface{}) statement {
+	b, err := json.Marshal(predicate)
+	require.NoError(t, err)
+	return statement{PredicateType: predicateType, Predicate: b}
+}
+
+// testSignedStatement sets the signing time of the statement, i.e., the integrated time of its rekor bundle
+func testSignedStatement(s statement, signedAt time.Time) statement {
+	s.signedAt = &signedAt
+	return s
+}
+
+func testSLSAStatement(t *testing.T, builderID string, finished time.Time) statement {
+	return testStatement(t, "https://slsa.dev/provenance/v0.2", map[string]interface{}{
+		"builder":  map[string]interface{}{"id": builderID},
+		"metadata": map[string]interface{}{"buildFinishedOn": finished},
+	})
+}
+
+func testVulnStatement(t *testing.T, finished time.Time) statement {
+	return testStatement(t, "cosign.sigstore.dev/attestation/vuln/v1", map[string]interface{}{
+		"metadata": map[string]interface{}{"scanFinishedOn": finished},
+	})
+}
+
+func TestParseStatements(t *testing.T) {
+	signedAt := time.Unix(1659312000, 0)
+	signed := testAttestation(t, "https://in-toto.io/Link/v1", static.WithBundle(&bundle.RekorBundle{Payload: bundle.RekorPayload{IntegratedTime: signedAt.Unix()}}))
+	notSigned := testAttestation(t, "https://in-toto.io/Link/v1")
+
+	statements, err := parseStatements([]oci.Signature{signed, notSigned})
+	require.NoError(t, err)
+	require.Len(t, statements, 2)
+	require.Equal(t, "https://in-toto.io/Link/v1", statements[0].PredicateType)
+	require.Equal(t, &signedAt, statements[0].signedAt, "signed at")
+	require.Nil(t, statements[1].signedAt, "not signed")
+}
+
+type validAttestationsTestCase struct {
+	// predicates are the predicate types attested with each key
+	predicates [][]string
+	required   []whv1.AttestationSpec
+
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestValidAttestations(t *testing.T) {
+	ref, err := name.NewDigest("registry.io/app@sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	require.NoError(t, err)
+
+	var keys []Key
+	for i := 0; i < 2; i++ {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		keys = append(keys, Key{ID: "key", PublicKey: privateKey.Public()})
+	}
+
+	required := []whv1.AttestationSpec{{PredicateType: PredicateSLSA}, {PredicateType: PredicateVuln}}
+	tc := map[string]validAttestationsTestCase{
+		"oneKey": {
+			predicates: [][]string{{"https://slsa.dev/provenance/v0.2", "cosign.sigstore.dev/attestation/vuln/v1"}, nil},
+			required:   required,
+		},
+		"differentKeys": {
+			predicates: [][]string{{"https://slsa.dev/provenance/v0.2"}, {"cosign.sigstore.dev/attestation/vuln/v1"}},
+			required:   required,
+		},
+		"missing": {
+			predicates: [][]string{{"https://slsa.dev/provenance/v0.2"}, nil},
+			required:   required,
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "there is no vuln attestation",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			cosignVerifyAttestations = testVerifyAttestations(t, ref, keys, c.predicates)
+			defer func() { cosignVerifyAttestations = cosign.VerifyImageAttestations }()
+
+			err := ValidAttestations(context.Background(), ref, c.required, keys, nil)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+// testVerifyAttestations returns a function verifying the attestations of the digest, which returns the attestations of the predicate
+// types for each key
+func testVerifyAttestations(t *testing.T, ref name.Digest, keys []Key, predicates [][]string) func(context.Context, name.Reference, *cosign.CheckOpts) ([]oci.Signature, bool, error) {
+	return func(_ context.Context, signedImgRef name.Reference, co *cosign.CheckOpts) ([]oci.Signature, bool, error) {
+		require.Equal(t, ref, signedImgRef, "verified digest")
+		publicKey, err := co.SigVerifier.PublicKey()
+		require.NoError(t, err)
+		for i, k := range keys {
+			if !k.PublicKey.(*ecdsa.PublicKey).Equal(publicKey) {
+				continue
+			}
+			var atts []oci.Signature
+			for _, predicateType := range predicates[i] {
+				atts = append(atts, testAttestation(t, predicateType))
+			}
+			return atts, false, nil
+		}
+		return nil, false, nil
+	}
+}
+
+// testAttestation returns an attestation, whose DSSE envelope has a statement of the predicate type
+func testAttestation(t *testing.T, predicateType string, opts ...static.Option) oci.Signature {
+	b, err := json.Marshal(map[string]interface{}{"predicateType": predicateType, "predicate": map[string]interface{}{}})
+	require.NoError(t, err)
+	envelope, err := json.Marshal(map[string]string{"payload": base64.StdEncoding.EncodeToString(b)})
+	require.NoError(t, err)
+	att, err := static.NewSignature(envelope, "", opts...)
+	require.NoError(t, err)
+	return att
+}
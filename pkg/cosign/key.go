@@ -0,0 +1,110 @@
+package cosign
+
+import (
+	"crypto"
+	"crypto/x509"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/sigstore/cosign/pkg/oci"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// Key is a cosign public key with its ID and validity period
+type Key struct {
+	// ID is an identifier of the key, which is reported in the admission result
+	ID string
+	// PublicKey is a public key to verify signatures
+	PublicKey crypto.PublicKey
+	// NotBefore is the time from which signatures made with the key are valid. Zero means no limit
+	NotBefore time.Time
+	// NotAfter is the time after which signatures made with the key are invalid. Zero means no limit
+	NotAfter time.Time
+	// Revoked rejects all signatures made with the key
+	Revoked bool
+}
+
+// NewKeys creates keys without validity periods. Their IDs are the given id, suffixed with the index if there are many
+func NewKeys(id string, publicKeys []crypto.PublicKey) []Key {
+	keys := make([]Key, 0, len(publicKeys))
+	for i, k := range publicKeys {
+		keyID := id
+		if len(publicKeys) > 1 {
+			keyID = fmt.Sprintf("%s#%d", id, i)
+		}
+		keys = append(keys, Key{ID: keyID, PublicKey: k})
+	}
+	return keys
+}
+
+// GetKeySetKeys parses the keys of the key set. Their IDs are formatted as <namespace>/<key set>/<key name>
+func GetKeySetKeys(keySet *whv1.CosignKeySet) ([]Key, error) {
+	var keys []Key
+	for _, k := range keySet.Spec.Keys {
+		id := fmt.Sprintf("%s/%s/%s", keySet.Namespace, keySet.Name, k.Name)
+		pems := parsePems([]byte(k.PublicKey))
+		if len(pems) != 1 {
+			return nil, fmt.Errorf("key %s should have a single PEM encoded public key", id)
+		}
+		publicKey, err := x509.ParsePKIXPublicKey(pems[0].Bytes)
+		if err != nil {
+			return nil, errors.Wrapf(err, "malformed key %s", id)
+		}
+
+		key := Key{ID: id, PublicKey: publicKey, Revoked: k.Revoked}
+		if k.NotBefore != nil {
+			key.NotBefore = k.NotBefore.Time
+		}
+		if k.NotAfter != nil {
+			key.NotAfter = k.NotAfter.Time
+		}
+		keys = append(keys, key)
+	}
+	return keys, nil
+}
+
+// checkValidity checks if the signature verified with the key is made within the key's validity period.
+// The signing time is the integrated time of the signature's rekor bundle, or the current time if there is no bundle
+func (k *Key) checkValidity(sig oci.Signature, now time.Time) error {
+	if k.Revoked {
+		return fmt.Errorf("signature is made with the revoked key %s", k.ID)
+	}
+	if k.NotBefore.IsZero() && k.NotAfter.IsZero() {
+		return nil
+	}
+
+	signedAt := now
+	b, err := sig.Bundle()
+	if err != nil {
+		return err
+	}
+	if b != nil {
+		signedAt = time.Unix(b.Payload.IntegratedTime, 0)
+	}
+
+	if !k.NotBefore.IsZero() && signedAt.Before(k.NotBefore) {
+		return fmt.Errorf("signature is made before the key %s is valid (notBefore: %s)", k.ID, k.NotBefore.Format(time.RFC3339))
+	}
+	if !k.NotAfter.IsZero() && signedAt.After(k.NotAfter) {
+		return fmt.Errorf("signature is made after the key %s is expired (notAfter: %s)", k.ID, k.NotAfter.Format(time.RFC3339))
+	}
+	return nil
+}
+
+// filterValid returns the signatures made within the key's validity period
+func (k *Key) filterValid(sigs []oci.Signature, now time.Time) ([]oci.Signature, error) {
+	var valid []oci.Signature
+	var lastErr error
+	for _, sig := range sigs {
+		if err := k.checkValidity(sig, now); err != nil {
+			lastErr = err
+			continue
+		}
+		valid = append(valid, sig)
+	}
+	if len(valid) == 0 {
+		return nil, lastErr
+	}
+	return valid, nil
+}
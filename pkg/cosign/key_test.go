@@ -0,0 +1,126 @@
+package cosign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+	"time"
+
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type keyValidityTestCase struct {
+	key Key
+	sig oci.Signature
+
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestKey_CheckValidity(t *testing.T) {
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	// The signature is integrated into the rekor log at 2022-08-01T00:00:00Z
+	signedAt := time.Unix(1659312000, 0)
+	bundled := testBundleSignature(t, rekorKey, testSignature, testPayload, false)
+	notBundled := testBundleSignature(t, nil, testSignature, testPayload, false)
+	now := signedAt.Add(30 * 24 * time.Hour)
+
+	tc := map[string]keyValidityTestCase{
+		"noPeriod": {
+			key: Key{ID: "key"},
+			sig: bundled,
+		},
+		"revoked": {
+			key: Key{ID: "key", Revoked: true},
+			sig: bundled,
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "signature is made with the revoked key key",
+		},
+		"withinPeriod": {
+			key: Key{ID: "key", NotBefore: signedAt.Add(-time.Hour), NotAfter: signedAt.Add(time.Hour)},
+			sig: bundled,
+		},
+		"beforePeriod": {
+			key: Key{ID: "key", NotBefore: time.Date(2022, 9, 1, 0, 0, 0, 0, time.UTC)},
+			sig: bundled,
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "signature is made before the key key is valid (notBefore: 2022-09-01T00:00:00Z)",
+		},
+		"afterPeriod": {
+			key: Key{ID: "key", NotAfter: time.Date(2022, 7, 1, 0, 0, 0, 0, time.UTC)},
+			sig: bundled,
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "signature is made after the key key is expired (notAfter: 2022-07-01T00:00:00Z)",
+		},
+		"noBundleWithinPeriod": {
+			key: Key{ID: "key", NotAfter: now.Add(time.Hour)},
+			sig: notBundled,
+		},
+		"noBundleAfterPeriod": {
+			key: Key{ID: "key", NotAfter: time.Date(2022, 8, 15, 0, 0, 0, 0, time.UTC)},
+			sig: notBundled,
+
+			expectedErrOccur: true,
+			expectedErrMsg:   "signature is made after the key key is expired (notAfter: 2022-08-15T00:00:00Z)",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			sigs, err := c.key.filterValid([]oci.Signature{c.sig}, now)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				require.Empty(t, sigs)
+			} else {
+				require.NoError(t, err)
+				require.Len(t, sigs, 1)
+			}
+		})
+	}
+}
+
+func TestGetKeySetKeys(t *testing.T) {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&privKey.PublicKey)
+	require.NoError(t, err)
+	publicKey := string(pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der}))
+
+	notAfter := metav1.NewTime(time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC))
+	keySet := &whv1.CosignKeySet{
+		ObjectMeta: metav1.ObjectMeta{Name: "keys", Namespace: "test"},
+		Spec: whv1.CosignKeySetSpec{
+			Keys: []whv1.CosignKey{
+				{Name: "old", PublicKey: publicKey, NotAfter: &notAfter},
+				{Name: "revoked", PublicKey: publicKey, Revoked: true},
+			},
+		},
+	}
+
+	keys, err := GetKeySetKeys(keySet)
+	require.NoError(t, err)
+	require.Len(t, keys, 2)
+	require.Equal(t, "test/keys/old", keys[0].ID)
+	require.Equal(t, notAfter.Time, keys[0].NotAfter)
+	require.True(t, keys[0].NotBefore.IsZero())
+	require.True(t, privKey.PublicKey.Equal(keys[0].PublicKey))
+	require.Equal(t, "test/keys/revoked", keys[1].ID)
+	require.True(t, keys[1].Revoked)
+
+	keySet.Spec.Keys = []whv1.CosignKey{{Name: "malformed", PublicKey: "not a pem"}}
+	_, err = GetKeySetKeys(keySet)
+	require.Error(t, err)
+	require.Equal(t, "key test/keys/malformed should have a single PEM encoded public key", err.Error())
+}
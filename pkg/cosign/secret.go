@@ -1,36 +1,20 @@
 package cosign
 
 import (
-	"context"
 	"strings"
 
 	"github.com/pkg/errors"
-	v1 "k8s.io/api/core/v1"
-	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
-	"k8s.io/client-go/kubernetes"
 )
 
 const (
 	KeyReference = "k8s://"
-)
-
-// GetKeyPairSecret get cosign key-pair from secret resource in k8s cluster
-func GetKeyPairSecret(ctx context.Context, client kubernetes.Interface, k8sKeyRef string) (*v1.Secret, error) {
-	namespace, name, err := parseRef(k8sKeyRef)
-	if err != nil {
-		return nil, err
-	}
 
-	var s *v1.Secret
-	if s, err = client.CoreV1().Secrets(namespace).Get(ctx, name, metav1.GetOptions{}); err != nil {
-		return nil, errors.Wrap(err, "Cosign: checking if secret exists")
-	}
-
-	return s, nil
-}
+	// PublicKeyKey is a default key of the secret or config map data which has cosign public keys
+	PublicKeyKey = "cosign.pub"
+)
 
-// the reference should be formatted as <namespace>/<secret name>
-func parseRef(k8sRef string) (string, string, error) {
+// ParseRef parses the namespace and the name of the reference, which should be formatted as <namespace>/<secret name>
+func ParseRef(k8sRef string) (string, string, error) {
 	s := strings.Split(strings.TrimPrefix(k8sRef, KeyReference), "/")
 	if len(s) != 2 {
 		return "", "", errors.New("Cosign: kubernetes specification should be in the format k8s://<namespace>/<secret>")
@@ -0,0 +1,273 @@
+package cosign
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+
+	"github.com/google/go-containerregistry/pkg/name"
+	v1 "github.com/google/go-containerregistry/pkg/v1"
+	"github.com/pkg/errors"
+	ssldsse "github.com/secure-systems-lab/go-securesystemslib/dsse"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/cosign/pkg/cosign/bundle"
+	"github.com/sigstore/cosign/pkg/oci"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+	"github.com/sigstore/cosign/pkg/types"
+	"github.com/sigstore/sigstore/pkg/signature/dsse"
+)
+
+const (
+	// RekorPublicKeyKey is a key of the secret data which has rekor public keys
+	RekorPublicKeyKey = "rekor.pub"
+)
+
+// TransparencyLog is a rekor transparency log, whose bundles are verified offline
+type TransparencyLog struct {
+	// PublicKeys are the public keys of the rekor log
+	PublicKeys []*ecdsa.PublicKey
+	// RequireBundle rejects signatures which do not have a rekor bundle
+	RequireBundle bool
+}
+
+// GetRekorPublicKeys parses rekor public keys from the secret data
+func GetRekorPublicKeys(cfg map[string][]byte) ([]*ecdsa.PublicKey, error) {
+	var keys []*ecdsa.PublicKey
+	for _, p := range parsePems(cfg[RekorPublicKeyKey]) {
+		key, err := x509.ParsePKIXPublicKey(p.Bytes)
+		if err != nil {
+			return nil, errors.Wrap(err, "malformed rekor.pub")
+		}
+		ecdsaKey, ok := key.(*ecdsa.PublicKey)
+		if !ok {
+			return nil, fmt.Errorf("rekor public key is not an ECDSA key")
+		}
+		keys = append(keys, ecdsaKey)
+	}
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("there is no rekor public key in %s", RekorPublicKeyKey)
+	}
+	return keys, nil
+}
+
+// bundlelessSignature hides the rekor bundle of a signature from cosign, which verifies bundles online
+type bundlelessSignature struct {
+	oci.Signature
+}
+
+// Bundle returns nil, so that the bundle is not verified by cosign
+func (s *bundlelessSignature) Bundle() (*bundle.RekorBundle, error) {
+	return nil, nil
+}
+
+// rekorHash is a hash of a rekor entry
+type rekorHash struct {
+	Algorithm string `json:"algorithm"`
+	Value     string `json:"value"`
+}
+
+// rekorEntry is a body of a rekor entry, having the fields of rekord, hashedrekord and intoto kinds
+type rekorEntry struct {
+	Kind string `json:"kind"`
+	Spec struct {
+		Signature struct {
+			Content string `json:"content"`
+		} `json:"signature"`
+		Data struct {
+			Hash rekorHash `json:"hash"`
+		} `json:"data"`
+		Content struct {
+			Hash rekorHash `json:"hash"`
+		} `json:"content"`
+	} `json:"spec"`
+}
+
+// verifySignatures verifies the image signatures and their rekor bundles without network calls to the rekor server
+func (t *TransparencyLog) verifySignatures(ctx context.Context, ref name.Reference, co *cosign.CheckOpts) ([]oci.Signature, error) {
+	se, h, err := signedEntity(ref, co.RegistryClientOpts)
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := se.Signatures()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.verifyAll(sigs, func(sig oci.Signature) error {
+		_, err := cosign.VerifyImageSignature(ctx, &bundlelessSignature{sig}, h, co)
+		return err
+	})
+}
+
+// verifyAttestations verifies the image attestations and their rekor bundles without network calls to the rekor server
+func (t *TransparencyLog) verifyAttestations(ctx context.Context, ref name.Reference, co *cosign.CheckOpts) ([]oci.Signature, error) {
+	se, h, err := signedEntity(ref, co.RegistryClientOpts)
+	if err != nil {
+		return nil, err
+	}
+	atts, err := se.Attestations()
+	if err != nil {
+		return nil, err
+	}
+
+	return t.verifyAll(atts, func(att oci.Signature) error {
+		return verifyAttestation(att, h, co)
+	})
+}
+
+// verifyAll returns the signatures which are verified by verify and have a valid rekor bundle
+func (t *TransparencyLog) verifyAll(sigs oci.Signatures, verify func(oci.Signature) error) ([]oci.Signature, error) {
+	sl, err := sigs.Get()
+	if err != nil {
+		return nil, err
+	}
+
+	var verified []oci.Signature
+	var lastErr error
+	for _, sig := range sl {
+		if err := verify(sig); err != nil {
+			lastErr = err
+			continue
+		}
+		if _, err := t.verifyBundle(sig); err != nil {
+			lastErr = err
+			continue
+		}
+		verified = append(verified, sig)
+	}
+	if len(verified) == 0 {
+		if lastErr == nil {
+			lastErr = fmt.Errorf("no matching signatures")
+		}
+		return nil, lastErr
+	}
+	return verified, nil
+}
+
+// verifyBundle verifies the signed entry timestamp of the signature's rekor bundle with the log's public keys,
+// and checks if the bundle's entry is for the signature
+func (t *TransparencyLog) verifyBundle(sig oci.Signature) (*bundle.RekorBundle, error) {
+	b, err := sig.Bundle()
+	if err != nil {
+		return nil, err
+	}
+	if b == nil {
+		if t.RequireBundle {
+			return nil, fmt.Errorf("signature does not have a rekor bundle")
+		}
+		return nil, nil
+	}
+
+	key, err := t.findKey(b.Payload.LogID)
+	if err != nil {
+		return nil, err
+	}
+	if err := cosign.VerifySET(b.Payload, b.SignedEntryTimestamp, key); err != nil {
+		return nil, errors.Wrap(err, "verifying rekor bundle")
+	}
+
+	if err := matchEntry(b, sig); err != nil {
+		return nil, err
+	}
+	return b, nil
+}
+
+// findKey finds the rekor public key, whose log ID is logID
+func (t *TransparencyLog) findKey(logID string) (*ecdsa.PublicKey, error) {
+	for _, k := range t.PublicKeys {
+		der, err := x509.MarshalPKIXPublicKey(k)
+		if err != nil {
+			return nil, err
+		}
+		digest := sha256.Sum256(der)
+		if hex.EncodeToString(digest[:]) == logID {
+			return k, nil
+		}
+	}
+	return nil, fmt.Errorf("rekor public key for log %s is not found", logID)
+}
+
+// matchEntry checks if the rekor entry of the bundle has the signature and the payload hash of sig
+func matchEntry(b *bundle.RekorBundle, sig oci.Signature) error {
+	body, ok := b.Payload.Body.(string)
+	if !ok {
+		return fmt.Errorf("rekor bundle body is not a string")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(body)
+	if err != nil {
+		return errors.Wrap(err, "decoding rekor bundle body")
+	}
+	entry := rekorEntry{}
+	if err := json.Unmarshal(decoded, &entry); err != nil {
+		return errors.Wrap(err, "unmarshaling rekor entry")
+	}
+
+	signature, err := sig.Base64Signature()
+	if err != nil {
+		return err
+	}
+	payload, err := sig.Payload()
+	if err != nil {
+		return err
+	}
+
+	// Attestations do not have a signature, but the whole envelope is the payload
+	hash := entry.Spec.Data.Hash
+	if signature == "" {
+		hash = entry.Spec.Content.Hash
+	} else if entry.Spec.Signature.Content != signature {
+		return fmt.Errorf("signature in rekor bundle does not match signature being verified")
+	}
+
+	payloadHash := sha256.Sum256(payload)
+	if hash.Algorithm != "sha256" || hash.Value != hex.EncodeToString(payloadHash[:]) {
+		return fmt.Errorf("rekor bundle does not match the payload")
+	}
+	return nil
+}
+
+// signedEntity fetches the signed entity of the reference and its digest
+func signedEntity(ref name.Reference, opts []ociremote.Option) (oci.SignedEntity, v1.Hash, error) {
+	se, err := ociremote.SignedEntity(ref, opts...)
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+	h, err := se.(interface{ Digest() (v1.Hash, error) }).Digest()
+	if err != nil {
+		return nil, v1.Hash{}, err
+	}
+	return se, h, nil
+}
+
+// verifyAttestation verifies the DSSE envelope of the attestation and its claims
+func verifyAttestation(att oci.Signature, h v1.Hash, co *cosign.CheckOpts) error {
+	payload, err := att.Payload()
+	if err != nil {
+		return err
+	}
+	env := ssldsse.Envelope{}
+	if err := json.Unmarshal(payload, &env); err != nil {
+		return err
+	}
+	if env.PayloadType != types.IntotoPayloadType {
+		return fmt.Errorf("invalid payloadType %s on envelope, expected %s", env.PayloadType, types.IntotoPayloadType)
+	}
+
+	dssev, err := ssldsse.NewEnvelopeVerifier(&dsse.VerifierAdapter{SignatureVerifier: co.SigVerifier})
+	if err != nil {
+		return err
+	}
+	if _, err := dssev.Verify(&env); err != nil {
+		return err
+	}
+
+	if co.ClaimVerifier != nil {
+		return co.ClaimVerifier(att, h, co.Annotations)
+	}
+	return nil
+}
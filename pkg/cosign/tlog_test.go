@@ -0,0 +1,171 @@
+package cosign
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"testing"
+
+	"github.com/cyberphone/json-canonicalization/go/src/webpki.org/jsoncanonicalizer"
+	"github.com/sigstore/cosign/pkg/cosign/bundle"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/static"
+	"github.com/stretchr/testify/require"
+)
+
+const (
+	testPayload   = `{"critical":{"identity":{"docker-reference":"test.registry/image"}}}`
+	testSignature = "dGVzdC1zaWduYXR1cmU="
+)
+
+type verifyBundleTestCase struct {
+	sig           oci.Signature
+	requireBundle bool
+
+	expectedBundleNil bool
+	expectedErrOccur  bool
+	expectedErrMsg    string
+}
+
+func TestTransparencyLog_VerifyBundle(t *testing.T) {
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	otherKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+
+	tc := map[string]verifyBundleTestCase{
+		"valid": {
+			sig: testBundleSignature(t, rekorKey, testSignature, testPayload, false),
+		},
+		"noBundle": {
+			sig:               testBundleSignature(t, nil, testSignature, testPayload, false),
+			expectedBundleNil: true,
+		},
+		"noBundleRequired": {
+			sig:              testBundleSignature(t, nil, testSignature, testPayload, false),
+			requireBundle:    true,
+			expectedErrOccur: true,
+			expectedErrMsg:   "signature does not have a rekor bundle",
+		},
+		"unknownLog": {
+			sig:              testBundleSignature(t, otherKey, testSignature, testPayload, false),
+			expectedErrOccur: true,
+			expectedErrMsg:   fmt.Sprintf("rekor public key for log %s is not found", testLogID(t, otherKey)),
+		},
+		"tamperedSET": {
+			sig:              testBundleSignature(t, rekorKey, testSignature, testPayload, true),
+			expectedErrOccur: true,
+			expectedErrMsg:   "verifying rekor bundle: unable to verify",
+		},
+		"otherSignature": {
+			sig:              testBundleSignatureWithEntry(t, rekorKey, "b3RoZXItc2lnbmF0dXJl", testSignature, testPayload),
+			expectedErrOccur: true,
+			expectedErrMsg:   "signature in rekor bundle does not match signature being verified",
+		},
+		"otherPayload": {
+			sig:              testBundleSignatureWithEntry(t, rekorKey, testSignature, testSignature, `{"other":"payload"}`),
+			expectedErrOccur: true,
+			expectedErrMsg:   "rekor bundle does not match the payload",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			tlog := &TransparencyLog{PublicKeys: []*ecdsa.PublicKey{&rekorKey.PublicKey}, RequireBundle: c.requireBundle}
+			b, err := tlog.verifyBundle(c.sig)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+			} else {
+				require.NoError(t, err)
+				require.Equal(t, c.expectedBundleNil, b == nil, "bundle nil")
+			}
+		})
+	}
+}
+
+func TestGetRekorPublicKeys(t *testing.T) {
+	rekorKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	der, err := x509.MarshalPKIXPublicKey(&rekorKey.PublicKey)
+	require.NoError(t, err)
+
+	keys, err := GetRekorPublicKeys(map[string][]byte{RekorPublicKeyKey: pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: der})})
+	require.NoError(t, err)
+	require.Len(t, keys, 1)
+	require.True(t, rekorKey.PublicKey.Equal(keys[0]))
+
+	_, err = GetRekorPublicKeys(map[string][]byte{})
+	require.Error(t, err)
+	require.Equal(t, "there is no rekor public key in rekor.pub", err.Error())
+}
+
+// testBundleSignature creates a signature with a rekor bundle signed by rekorKey. If rekorKey is nil, the signature does not have a bundle
+func testBundleSignature(t *testing.T, rekorKey *ecdsa.PrivateKey, b64sig, payload string, tamper bool) oci.Signature {
+	if rekorKey == nil {
+		sig, err := static.NewSignature([]byte(payload), b64sig)
+		require.NoError(t, err)
+		return sig
+	}
+
+	sig := testBundleSignatureWithEntry(t, rekorKey, b64sig, b64sig, payload)
+	if tamper {
+		b, err := sig.Bundle()
+		require.NoError(t, err)
+		b.Payload.IntegratedTime++
+		sig, err = static.NewSignature([]byte(payload), b64sig, static.WithBundle(b))
+		require.NoError(t, err)
+	}
+	return sig
+}
+
+// testBundleSignatureWithEntry creates a signature whose rekor entry has entrySig and the hash of entryPayload
+func testBundleSignatureWithEntry(t *testing.T, rekorKey *ecdsa.PrivateKey, entrySig, b64sig, entryPayload string) oci.Signature {
+	payloadHash := sha256.Sum256([]byte(entryPayload))
+	entry := map[string]interface{}{
+		"apiVersion": "0.0.1",
+		"kind":       "hashedrekord",
+		"spec": map[string]interface{}{
+			"signature": map[string]interface{}{"content": entrySig},
+			"data": map[string]interface{}{
+				"hash": map[string]interface{}{"algorithm": "sha256", "value": hex.EncodeToString(payloadHash[:])},
+			},
+		},
+	}
+	body, err := json.Marshal(entry)
+	require.NoError(t, err)
+
+	b := &bundle.RekorBundle{
+		Payload: bundle.RekorPayload{
+			Body:           base64.StdEncoding.EncodeToString(body),
+			IntegratedTime: 1659312000,
+			LogIndex:       1,
+			LogID:          testLogID(t, rekorKey),
+		},
+	}
+	contents, err := json.Marshal(b.Payload)
+	require.NoError(t, err)
+	canonicalized, err := jsoncanonicalizer.Transform(contents)
+	require.NoError(t, err)
+	hash := sha256.Sum256(canonicalized)
+	b.SignedEntryTimestamp, err = ecdsa.SignASN1(rand.Reader, rekorKey, hash[:])
+	require.NoError(t, err)
+
+	sig, err := static.NewSignature([]byte(testPayload), b64sig, static.WithBundle(b))
+	require.NoError(t, err)
+	return sig
+}
+
+func testLogID(t *testing.T, key *ecdsa.PrivateKey) string {
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	require.NoError(t, err)
+	digest := sha256.Sum256(der)
+	return hex.EncodeToString(digest[:])
+}
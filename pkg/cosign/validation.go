@@ -19,10 +19,12 @@ import (
 	"crypto"
 	"crypto/tls"
 	"crypto/x509"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
-	"net/http"
+	"time"
 
+	"github.com/google/go-containerregistry/pkg/authn"
 	"github.com/google/go-containerregistry/pkg/name"
 	"github.com/google/go-containerregistry/pkg/v1/remote"
 	"github.com/pkg/errors"
@@ -30,6 +32,8 @@ import (
 	"github.com/sigstore/cosign/pkg/oci"
 	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
 	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 )
 
@@ -37,16 +41,25 @@ var (
 	validLog = logf.Log.WithName("cosign/validation.go")
 )
 
-func Valid(ctx context.Context, ref name.Reference, signer []string, keys []crypto.PublicKey, opts ...ociremote.Option) ([]oci.Signature, error) {
+// Valid verifies the image signatures with the keys and returns the verified signatures and the first key which verified them.
+// Signers are counted for the threshold of the policy across the keys, and each key counts as one signer
+func Valid(ctx context.Context, ref name.Reference, signerPolicy *signer.Policy, keys []Key, tlog *TransparencyLog, opts ...ociremote.Option) ([]oci.Signature, *Key, error) {
 	if len(keys) == 0 {
 		// If there are no keys,
 		msg := "There are no keys for valid"
-		return nil, errors.Errorf(msg)
+		return nil, nil, errors.Errorf(msg)
 	}
-	// We return nil if ANY key matches
+	if len(signerPolicy.Signers()) == 0 {
+		return nil, &keys[0], nil
+	}
+
+	var matched []oci.Signature
+	var matchedKey *Key
+	var signers []string
+	var keySigners [][]string
 	var lastErr error
-	for _, k := range keys {
-		verifier, err := signature.LoadVerifier(k, crypto.SHA256)
+	for i, k := range keys {
+		verifier, err := signature.LoadVerifier(k.PublicKey, crypto.SHA256)
 		if err != nil {
 			msg := fmt.Sprintf("Error creating verifier: %v", err)
 			validLog.Error(err, msg)
@@ -54,59 +67,133 @@ func Valid(ctx context.Context, ref name.Reference, signer []string, keys []cryp
 			continue
 		}
 
-		sps, err := validSignatures(ctx, ref, signer, verifier, opts...)
+		sps, err := validSignatures(ctx, ref, verifier, tlog, opts...)
 		if err != nil {
 			msg := fmt.Sprintf("Error validating signatures: %v", err)
 			validLog.Error(err, msg)
 			lastErr = err
 			continue
 		}
-		return sps, nil
+
+		// Signatures made with a revoked key or out of the key's validity period are rejected
+		sps, err = k.filterValid(sps, time.Now())
+		if err != nil {
+			validLog.Error(err, "Invalid key")
+			lastErr = err
+			continue
+		}
+
+		sps, ss, err := matchSigners(sps, signerPolicy)
+		signers = append(signers, ss...)
+		if err != nil {
+			validLog.Error(err, "Signers do not match")
+			lastErr = err
+			continue
+		}
+		if matchedKey == nil {
+			matchedKey = &keys[i]
+		}
+		matched = append(matched, sps...)
+		keySigners = append(keySigners, ss)
 	}
-	validLog.Info("No valid signatures were found.")
-	return nil, lastErr
+
+	if matchedKey == nil {
+		validLog.Info("No valid signatures were found.")
+		return nil, nil, lastErr
+	}
+	if !signerPolicy.MatchKeySigners(keySigners) {
+		return nil, nil, &signer.MismatchError{AnnotationKey: signerPolicy.AnnotationKey, Signers: signers, Threshold: signerPolicy.Threshold}
+	}
+	return matched, matchedKey, nil
 }
 
 // For testing
 var cosignVerifySignatures = cosign.VerifyImageSignatures
 
-func validSignatures(ctx context.Context, ref name.Reference, policySigners []string, verifier signature.Verifier, opts ...ociremote.Option) ([]oci.Signature, error) {
-	// allow insecure registry [x509 error fix]
-	opts = append(opts, ociremote.WithRemoteOptions(remote.WithTransport(&http.Transport{TLSClientConfig: &tls.Config{InsecureSkipVerify: true}})))
+func validSignatures(ctx context.Context, ref name.Reference, verifier signature.Verifier, tlog *TransparencyLog, opts ...ociremote.Option) ([]oci.Signature, error) {
+	// do cosign verify signature
+	co := &cosign.CheckOpts{
+		RegistryClientOpts: opts,
+		RootCerts:          nil,
+		SigVerifier:        verifier,
+		ClaimVerifier:      cosign.SimpleClaimVerifier,
+	}
+	var sigs []oci.Signature
+	var err error
+	if tlog != nil {
+		// verify rekor bundles offline
+		sigs, err = tlog.verifySignatures(ctx, ref, co)
+	} else {
+		sigs, _, err = cosignVerifySignatures(ctx, ref, co)
+	}
+	msg := fmt.Sprintf("%v", sigs)
+	validLog.Info(msg)
+	if err != nil {
+		return nil, err
+	}
+	return sigs, nil
+}
+
+// matchSigners returns the signatures, whose signer annotation matches the signer policy, and the signers of the signatures.
+// The threshold of the policy is checked by Valid across the keys
+func matchSigners(sigs []oci.Signature, signerPolicy *signer.Policy) ([]oci.Signature, []string, error) {
+	var matched []oci.Signature
+	var signers []string
+	for _, sig := range sigs {
+		b, err := sig.Payload()
+		if err != nil {
+			return nil, nil, err
+		}
+		ss := payload.SimpleContainerImage{}
+		if err := json.Unmarshal(b, &ss); err != nil {
+			return nil, nil, errors.Wrap(err, "unmarshaling signature payload")
+		}
 
-	var lastErr error
-	var lastSig []oci.Signature
-
-	for _, signer := range policySigners {
-		// do cosign verify signature & signer annotations
-		sigs, _, err := cosignVerifySignatures(ctx, ref, &cosign.CheckOpts{
-			RegistryClientOpts: opts,
-			RootCerts:          nil,
-			SigVerifier:        verifier,
-			ClaimVerifier:      cosign.SimpleClaimVerifier,
-			Annotations: map[string]interface{}{
-				"signer": signer,
-			},
-		})
-		msg := fmt.Sprintf("%v", sigs)
-		validLog.Info(msg)
-		// if signature is valid & signer is valid, return sig
-		if err == nil {
-			return sigs, nil
+		value, exist := ss.Optional[signerPolicy.AnnotationKey]
+		if !exist {
+			continue
+		}
+		signerValue := fmt.Sprint(value)
+		signers = append(signers, signerValue)
+		if signerPolicy.Match(signerValue) {
+			matched = append(matched, sig)
 		}
-		lastErr = err
-		lastSig = sigs
 	}
-	// return error because it is invalid
-	return lastSig, lastErr
+	if len(matched) == 0 {
+		return nil, signers, &signer.MismatchError{AnnotationKey: signerPolicy.AnnotationKey, Signers: signers, Threshold: signerPolicy.Threshold}
+	}
+	return matched, signers, nil
+}
+
+// RegistryOpts returns the options of the registry client, which authenticates with the credentials of the keychain,
+// and verifies the TLS certificates of the registry with the TLS config.
+// The default keychain and the default transport, which uses the system CAs, are used if they are nil
+func RegistryOpts(tlsConfig *tls.Config, keychain authn.Keychain) []ociremote.Option {
+	if keychain == nil {
+		keychain = authn.DefaultKeychain
+	}
+	// Remote options are replaced as a whole, so that they are passed at once
+	ropts := []remote.Option{remote.WithAuthFromKeychain(keychain)}
+	if tlsConfig != nil {
+		t := remote.DefaultTransport.Clone()
+		t.TLSClientConfig = tlsConfig
+		ropts = append(ropts, remote.WithTransport(t))
+	}
+	return []ociremote.Option{ociremote.WithRemoteOptions(ropts...)}
 }
 
+// GetPublicKey parses cosign public keys from cosign.pub of the secret data
 func GetPublicKey(cfg map[string][]byte) ([]crypto.PublicKey, error) {
+	validLog.Info("Get Public key...")
+	return ParsePublicKeys(cfg[PublicKeyKey], PublicKeyKey)
+}
+
+// ParsePublicKeys parses PEM encoded cosign public keys. source is used in the error message
+func ParsePublicKeys(b []byte, source string) ([]crypto.PublicKey, error) {
 	keys := []crypto.PublicKey{}
 	errs := []error{}
 
-	validLog.Info("Get Public key...")
-	pems := parsePems(cfg["cosign.pub"])
+	pems := parsePems(b)
 	for _, p := range pems {
 		// TODO: check header
 		key, err := x509.ParsePKIXPublicKey(p.Bytes)
@@ -116,8 +203,8 @@ func GetPublicKey(cfg map[string][]byte) ([]crypto.PublicKey, error) {
 			keys = append(keys, key.(crypto.PublicKey))
 		}
 	}
-	if keys == nil {
-		msg := fmt.Sprintf("malformed cosign.pub: %v", errs)
+	if len(keys) == 0 && len(errs) > 0 {
+		msg := fmt.Sprintf("malformed %s: %v", source, errs)
 		return nil, errors.Wrap(errs[0], msg)
 	}
 	return keys, nil
@@ -0,0 +1,307 @@
+package cosign
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/google/go-containerregistry/pkg/authn"
+	"github.com/google/go-containerregistry/pkg/name"
+	"github.com/google/go-containerregistry/pkg/registry"
+	"github.com/google/go-containerregistry/pkg/v1/random"
+	"github.com/google/go-containerregistry/pkg/v1/remote"
+	"github.com/sigstore/cosign/pkg/cosign"
+	"github.com/sigstore/cosign/pkg/oci"
+	"github.com/sigstore/cosign/pkg/oci/mutate"
+	ociremote "github.com/sigstore/cosign/pkg/oci/remote"
+	"github.com/sigstore/cosign/pkg/oci/static"
+	"github.com/sigstore/sigstore/pkg/signature"
+	"github.com/sigstore/sigstore/pkg/signature/payload"
+	"github.com/stretchr/testify/require"
+	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+type matchSignersTestCase struct {
+	spec     whv1.RegistrySpec
+	payloads []string
+
+	expectedMatched  int
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestMatchSigners(t *testing.T) {
+	tc := map[string]matchSignersTestCase{
+		"defaultKey": {
+			spec:            whv1.RegistrySpec{Signer: []string{"alice"}},
+			payloads:        []string{testSignerPayload("signer", "alice"), testSignerPayload("signer", "bob")},
+			expectedMatched: 1,
+		},
+		"customKey": {
+			spec:            whv1.RegistrySpec{Signer: []string{"*@tmax.co.kr"}, SignerAnnotationKey: "team", SignerMatchType: "glob"},
+			payloads:        []string{testSignerPayload("team", "alice@tmax.co.kr")},
+			expectedMatched: 1,
+		},
+		"mismatch": {
+			spec:             whv1.RegistrySpec{Signer: []string{"alice"}},
+			payloads:         []string{testSignerPayload("signer", "bob")},
+			expectedErrOccur: true,
+			expectedErrMsg:   "signers [bob] of the annotation 'signer' do not match the policy",
+		},
+		"thresholdNotChecked": {
+			spec:            whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			payloads:        []string{testSignerPayload("signer", "qa"), testSignerPayload("signer", "developer")},
+			expectedMatched: 1,
+		},
+		"thresholdSatisfied": {
+			spec:            whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			payloads:        []string{testSignerPayload("signer", "qa"), testSignerPayload("signer", "security")},
+			expectedMatched: 2,
+		},
+		"noAnnotation": {
+			spec:             whv1.RegistrySpec{Signer: []string{"alice"}, SignerAnnotationKey: "team"},
+			payloads:         []string{testSignerPayload("signer", "alice")},
+			expectedErrOccur: true,
+			expectedErrMsg:   "signatures do not have the signer annotation 'team'",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			p, err := signer.NewPolicy(c.spec)
+			require.NoError(t, err)
+
+			var sigs []oci.Signature
+			for _, pl := range c.payloads {
+				sig, err := static.NewSignature([]byte(pl), testSignature)
+				require.NoError(t, err)
+				sigs = append(sigs, sig)
+			}
+
+			matched, _, err := matchSigners(sigs, p)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				var mismatchErr *signer.MismatchError
+				require.True(t, errors.As(err, &mismatchErr))
+			} else {
+				require.NoError(t, err)
+				require.Len(t, matched, c.expectedMatched)
+			}
+		})
+	}
+}
+
+func testSignerPayload(key, value string) string {
+	return `{"critical":{"identity":{"docker-reference":"test.registry/image"}},"optional":{"` + key + `":"` + value + `"}}`
+}
+
+type validSignerThresholdTestCase struct {
+	// signers are the signers of the signatures verified with each key
+	signers [][]string
+
+	expectedKeyID    string
+	expectedMatched  int
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestValid_SignerThreshold(t *testing.T) {
+	ref, err := name.NewDigest("registry.io/app@sha256:1111111111111111111111111111111111111111111111111111111111111111")
+	require.NoError(t, err)
+	p, err := signer.NewPolicy(whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2})
+	require.NoError(t, err)
+
+	var keys []Key
+	for _, id := range []string{"key-0", "key-1"} {
+		privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		require.NoError(t, err)
+		keys = append(keys, Key{ID: id, PublicKey: privateKey.Public()})
+	}
+
+	tc := map[string]validSignerThresholdTestCase{
+		"differentKeys": {
+			signers:         [][]string{{"qa"}, {"security"}},
+			expectedKeyID:   "key-0",
+			expectedMatched: 2,
+		},
+		"oneKeyTwoSigners": {
+			signers:          [][]string{{"qa", "security"}, nil},
+			expectedErrOccur: true,
+			expectedErrMsg:   "signers [qa security] of the annotation 'signer' do not satisfy the policy requiring 2 signers",
+		},
+		"sameSigner": {
+			signers:          [][]string{{"qa"}, {"qa"}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "signers [qa qa] of the annotation 'signer' do not satisfy the policy requiring 2 signers",
+		},
+		"secondKeyMismatch": {
+			signers:          [][]string{{"qa"}, {"developer"}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "signers [qa developer] of the annotation 'signer' do not satisfy the policy requiring 2 signers",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			cosignVerifySignatures = testVerifySignatures(t, keys, c.signers)
+			defer func() { cosignVerifySignatures = cosign.VerifyImageSignatures }()
+
+			sigs, key, err := Valid(context.Background(), ref, p, keys, nil)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, sigs, c.expectedMatched)
+			require.Equal(t, c.expectedKeyID, key.ID)
+		})
+	}
+}
+
+// testVerifySignatures returns a function verifying the signatures, which returns the signatures of the signers for each key
+func testVerifySignatures(t *testing.T, keys []Key, signers [][]string) func(context.Context, name.Reference, *cosign.CheckOpts) ([]oci.Signature, bool, error) {
+	return func(_ context.Context, _ name.Reference, co *cosign.CheckOpts) ([]oci.Signature, bool, error) {
+		publicKey, err := co.SigVerifier.PublicKey()
+		require.NoError(t, err)
+		for i, k := range keys {
+			if !k.PublicKey.(*ecdsa.PublicKey).Equal(publicKey) || len(signers[i]) == 0 {
+				continue
+			}
+			var sigs []oci.Signature
+			for _, s := range signers[i] {
+				sig, err := static.NewSignature([]byte(testSignerPayload("signer", s)), testSignature)
+				require.NoError(t, err)
+				sigs = append(sigs, sig)
+			}
+			return sigs, false, nil
+		}
+		return nil, false, errors.New("no matching signatures")
+	}
+}
+
+type registryAuthTestCase struct {
+	auth string
+
+	expectedErrOccur bool
+}
+
+// TestValid_RegistryAuth checks that the signatures are fetched from a private registry with the credentials of the pull secrets
+func TestValid_RegistryAuth(t *testing.T) {
+	const username, password = "puller", "secret"
+	srv := testAuthRegistry(username, password)
+	defer srv.Close()
+	host := strings.TrimPrefix(srv.URL, "https://")
+
+	pool := x509.NewCertPool()
+	pool.AddCert(srv.Certificate())
+	tlsConfig := &tls.Config{RootCAs: pool}
+
+	privateKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	ref := testSignedImage(t, host+"/private/image:v1", privateKey, authn.FromConfig(authn.AuthConfig{Username: username, Password: password}), tlsConfig)
+
+	p, err := signer.NewPolicy(whv1.RegistrySpec{Signer: []string{"alice"}})
+	require.NoError(t, err)
+	keys := NewKeys("test", []crypto.PublicKey{privateKey.Public()})
+
+	tc := map[string]registryAuthTestCase{
+		"credentials": {
+			auth: base64.StdEncoding.EncodeToString([]byte(username + ":" + password)),
+		},
+		"wrongCredentials": {
+			auth:             base64.StdEncoding.EncodeToString([]byte(username + ":wrong")),
+			expectedErrOccur: true,
+		},
+		"anonymous": {
+			expectedErrOccur: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			var secrets []*corev1.Secret
+			if c.auth != "" {
+				dcj, err := json.Marshal(utils.DockerConfigJSON{Auths: map[string]utils.DockerLoginCredential{srv.URL: {utils.DockerConfigAuthKey: c.auth}}})
+				require.NoError(t, err)
+				secrets = append(secrets, &corev1.Secret{
+					ObjectMeta: metav1.ObjectMeta{Name: "pull-secret"},
+					Type:       corev1.SecretTypeDockerConfigJson,
+					Data:       map[string][]byte{corev1.DockerConfigJsonKey: dcj},
+				})
+			}
+			keychain := utils.NewKeychain(secrets)
+
+			sigs, key, err := Valid(context.Background(), ref, p, keys, nil, RegistryOpts(tlsConfig, keychain)...)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				return
+			}
+			require.NoError(t, err)
+			require.Len(t, sigs, 1)
+			require.Equal(t, "test", key.ID)
+		})
+	}
+}
+
+// testAuthRegistry starts an in-process registry, which requires the basic auth
+func testAuthRegistry(username, password string) *httptest.Server {
+	reg := registry.New()
+	return httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u, p, ok := r.BasicAuth(); !ok || u != username || p != password {
+			w.Header().Set("WWW-Authenticate", `Basic realm="test"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		reg.ServeHTTP(w, r)
+	}))
+}
+
+// testSignedImage pushes a random image and its cosign signature, whose signer is alice, to the registry
+func testSignedImage(t *testing.T, image string, privateKey *ecdsa.PrivateKey, auth authn.Authenticator, tlsConfig *tls.Config) name.Digest {
+	transport := remote.DefaultTransport.Clone()
+	transport.TLSClientConfig = tlsConfig
+	ropts := []remote.Option{remote.WithAuth(auth), remote.WithTransport(transport)}
+
+	tag, err := name.NewTag(image)
+	require.NoError(t, err)
+	img, err := random.Image(256, 1)
+	require.NoError(t, err)
+	require.NoError(t, remote.Write(tag, img, ropts...))
+	h, err := img.Digest()
+	require.NoError(t, err)
+	ref := tag.Context().Digest(h.String())
+
+	pl, err := (&payload.Cosign{Image: ref, Annotations: map[string]interface{}{"signer": "alice"}}).MarshalJSON()
+	require.NoError(t, err)
+	signerVerifier, err := signature.LoadECDSASignerVerifier(privateKey, crypto.SHA256)
+	require.NoError(t, err)
+	rawSig, err := signerVerifier.SignMessage(bytes.NewReader(pl))
+	require.NoError(t, err)
+	sig, err := static.NewSignature(pl, base64.StdEncoding.EncodeToString(rawSig))
+	require.NoError(t, err)
+
+	se, err := ociremote.SignedEntity(ref, ociremote.WithRemoteOptions(ropts...))
+	require.NoError(t, err)
+	se, err = mutate.AttachSignatureToEntity(se, sig)
+	require.NoError(t, err)
+	require.NoError(t, ociremote.WriteSignatures(ref.Repository, se, ociremote.WithRemoteOptions(ropts...)))
+	return ref
+}
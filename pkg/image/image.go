@@ -31,6 +31,9 @@ const (
 	LegacyV1Server = "https://index.docker.io/v1"
 	// LegacyV2Server is FQDN of legacy v2 server
 	LegacyV2Server = "https://index.docker.io/v2"
+
+	// DefaultTag is the tag of an image referenced without a tag or a digest
+	DefaultTag = "latest"
 )
 
 // Image is a struct containing info of image
@@ -47,11 +50,16 @@ type Image struct {
 	BasicAuth string
 	Token     *auth.Token
 
+	// IdentityToken is the refresh token of OAuth2, which the token is requested with instead of the basic auth
+	IdentityToken string
+
+	// TLSConfig verifies the TLS certificates of the registry and the notary server. The system CAs are used if it is nil
+	TLSConfig  *tls.Config
 	HTTPClient http.Client
 }
 
-// NewImage creates new image client
-func NewImage(uri, basicAuth string) (*Image, error) {
+// NewImage creates new image client. The TLS certificates of the registry are verified with the TLS config
+func NewImage(uri, basicAuth string, tlsConfig *tls.Config) (*Image, error) {
 	r := &Image{}
 
 	// Set image
@@ -68,8 +76,7 @@ func NewImage(uri, basicAuth string) (*Image, error) {
 	r.Token = &auth.Token{}
 
 	// Generate HTTPS client
-	var tlsConfig = &tls.Config{InsecureSkipVerify: true}
-
+	r.TLSConfig = tlsConfig
 	r.HTTPClient = http.Client{
 		Transport: &http.Transport{
 			TLSClientConfig: tlsConfig,
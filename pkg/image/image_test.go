@@ -95,7 +95,7 @@ func TestNewImage(t *testing.T) {
 	}
 	for name, c := range tc {
 		t.Run(name, func(t *testing.T) {
-			r, err := NewImage(c.uri, c.basicAuth)
+			r, err := NewImage(c.uri, c.basicAuth, nil)
 			if c.expectedErr == "" {
 				require.Equal(t, c.expectedHost, r.Host)
 				require.Equal(t, c.expectedName, r.Name)
@@ -1,19 +1,33 @@
 package notary
 
 import (
-	"fmt"
-	"os"
+	"crypto/tls"
+	"errors"
 	"strings"
 
+	"github.com/opencontainers/go-digest"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/trustpinning"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
 
-	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/trust"
 )
 
 var (
 	signatureLog = logf.Log.WithName("signature.go")
+
+	// signaturePool is a pool of the notary repositories, shared by the requests
+	signaturePool = trust.NewPool(trust.DefaultRefreshInterval)
+)
+
+var (
+	// ErrNotSigned is an error for the tag or the digest, which is not signed
+	ErrNotSigned = errors.New("image is not signed")
+
+	// ErrDigestMismatch is an error for the digest being deployed, which is different from the signed digest of the tag
+	ErrDigestMismatch = errors.New("digest is different from the signed digest")
 )
 
 // Signature is a sign info of an image
@@ -24,74 +38,100 @@ type Signature struct {
 
 // SignedTag is a tag-signature info
 type SignedTag struct {
-	SignedTag string   `json:"SignedTag"`
-	Digest    string   `json:"Digest"`
-	Signers   []string `json:"Signers"`
+	SignedTag string `json:"SignedTag"`
+	// Digest is a signed digest of the tag, with its algorithm (i.e., sha256:<hex>)
+	Digest  string   `json:"Digest"`
+	Signers []string `json:"Signers"`
 }
 
-// GetDigest gets signed digest for the tag
-func (s *Signature) GetDigest(tag string) string {
-	digest := ""
+// MatchSigner finds the signed target of the tag and the digest being deployed, whose signers satisfy the policy.
+// Only the targets of the tag are checked, and the digest is cross-checked against them unless it is empty.
+// If the tag is empty (i.e., the image is referenced by the digest), the targets are found by the digest
+func (s *Signature) MatchSigner(tag, digest string, signerPolicy *signer.Policy) (*SignedTag, error) {
+	var targets []SignedTag
 	for _, signedTag := range s.SignedTags {
-		if signedTag.SignedTag == tag {
-			digest = signedTag.Digest
+		if tag == "" || signedTag.SignedTag == tag {
+			targets = append(targets, signedTag)
 		}
 	}
-	return digest
-}
+	if len(targets) == 0 {
+		return nil, ErrNotSigned
+	}
 
-// MatchSigner find match who signed
-func (s *Signature) MatchSigner(policySigners []string) bool {
-	for _, signedTag := range s.SignedTags {
-		for _, signers := range signedTag.Signers {
-			// when image signer is Repository Administrator, just return true
-			if signers == "Repo Admin" {
-				return true
+	if digest != "" {
+		var digestTargets []SignedTag
+		for _, t := range targets {
+			if t.Digest == digest {
+				digestTargets = append(digestTargets, t)
 			}
-			for _, sgr := range policySigners {
-				if sgr == signers {
-					return true
-				}
+		}
+		if len(digestTargets) == 0 {
+			if tag == "" {
+				return nil, ErrNotSigned
 			}
+			return nil, ErrDigestMismatch
 		}
+		targets = digestTargets
+	}
 
+	var allSigners []string
+	for i, t := range targets {
+		var signers []string
+		for _, sgr := range t.Signers {
+			// when image signer is Repository Administrator, the target matches unless it is refused
+			if sgr == signer.RepoAdmin {
+				if signerPolicy.AllowRepoAdmin {
+					return &targets[i], nil
+				}
+				continue
+			}
+			signers = append(signers, sgr)
+		}
+		if signerPolicy.MatchSigners(signers) {
+			return &targets[i], nil
+		}
+		allSigners = append(allSigners, signers...)
 	}
-	return false
+	return nil, &signer.MismatchError{Signers: allSigners, Threshold: signerPolicy.Threshold}
+}
+
+// FetchOptions are options of fetching a signature from the notary server
+type FetchOptions struct {
+	// BasicAuth is username:password string encrypted by base64, for the registry
+	BasicAuth string
+	// IdentityToken is the refresh token of OAuth2 for the token server, e.g., the identity token of the docker config
+	IdentityToken string
+	// NotaryServer is a url of the notary server. Docker hub's notary server is used if it is empty
+	NotaryServer string
+	// TrustPinning should be satisfied by the root of the repository
+	TrustPinning trustpinning.TrustPinConfig
+	// TLSConfig verifies the TLS certificates of the registry and the notary server. The system CAs are used if it is nil
+	TLSConfig *tls.Config
 }
 
 // FetchSignature fetches a signature from the notary server
-func FetchSignature(imageURI, basicAuth, notaryServer string) (*Signature, error) {
-	img, err := image.NewImage(imageURI, basicAuth)
+func FetchSignature(imageURI string, opts FetchOptions) (*Signature, error) {
+	img, err := image.NewImage(imageURI, opts.BasicAuth, opts.TLSConfig)
 	if err != nil {
 		signatureLog.Error(err, "failed new image")
 		return nil, err
 	}
+	img.IdentityToken = opts.IdentityToken
 
-	// Use notary client
-	// Here, we create a new cache directory per requests.
-	// (Be aware that FetchSigner is called from inside the http.Handler. It can be called simultaneously as goroutines)
-	// By doing so, we can clean the cache directory after the process in easier way.
-	tempDir := fmt.Sprintf("%s/notary/%s", os.TempDir(), utils.RandomString(10))
-	not, err := trust.NewReadOnly(img, notaryServer, tempDir)
-	if err != nil {
-		signatureLog.Error(err, "failed new image read in notary")
-		return nil, err
-	}
-
-	defer func() {
-		if err := not.ClearDir(); err != nil {
-			errMsg := fmt.Sprintf("deleting notary temp dir error by %s", err)
-			signatureLog.Error(err, errMsg)
-		}
-	}()
-
-	signedRepo, err := not.GetSignedMetadata(img.Tag)
+	// Notary repositories are pooled, so that their TUF metadata is reused across the requests.
+	// Images referenced only by the digest are found by the digest in any released role
+	signedRepo, err := signaturePool.GetSignedMetadata(img, opts.NotaryServer, opts.TrustPinning)
 	if err != nil {
 		// If the image is not signed
 		// TODO - registry's GetSignedMetadata error handle - not using error string!
 		if strings.Contains(err.Error(), "does not have trust data for") {
 			return nil, nil
 		}
+		// If the tag or the digest is not signed, e.g., it is signed only by cosign in a repository which has trust data
+		var noTargetErr client.ErrNoSuchTarget
+		if errors.As(err, &noTargetErr) {
+			return nil, nil
+		}
 		signatureLog.Error(err, "failed Get Signed Metadata")
 		return nil, err
 	}
@@ -101,7 +141,7 @@ func FetchSignature(imageURI, basicAuth, notaryServer string) (*Signature, error
 	for _, t := range signedRepo.SignedTags {
 		sig.SignedTags = append(sig.SignedTags, SignedTag{
 			SignedTag: t.SignedTag,
-			Digest:    t.Digest,
+			Digest:    digest.NewDigestFromEncoded(digest.SHA256, t.Digest).String(),
 			Signers:   t.Signers,
 		})
 	}
@@ -1,13 +1,17 @@
 package notary
 
 import (
+	"crypto/tls"
 	"fmt"
 	"os"
+	"strings"
 	"testing"
 
 	"github.com/sirupsen/logrus"
 	"github.com/stretchr/testify/require"
 	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/signer"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
 	ctrl "sigs.k8s.io/controller-runtime"
 	"sigs.k8s.io/controller-runtime/pkg/log/zap"
 )
@@ -58,7 +62,7 @@ func TestFetchSignature(t *testing.T) {
 
 	for name, c := range tc {
 		t.Run(name, func(t *testing.T) {
-			sig, err := FetchSignature(fmt.Sprintf("%s/%s:%s", c.imgHost, c.imgRepo, c.imgTag), "", testSrv.URL)
+			sig, err := FetchSignature(fmt.Sprintf("%s/%s:%s", c.imgHost, c.imgRepo, c.imgTag), FetchOptions{NotaryServer: testSrv.URL, TLSConfig: testSrv.TLSConfig()})
 			require.NoError(t, err)
 
 			if c.expectedSignatureNil {
@@ -74,3 +78,322 @@ func TestFetchSignature(t *testing.T) {
 		})
 	}
 }
+
+type matchSignerTestCase struct {
+	signedTags []SignedTag
+	tag        string
+	digest     string
+	spec       whv1.RegistrySpec
+
+	expectedDigest string
+	expectedErr    error
+	expectedErrMsg string
+}
+
+func TestSignature_MatchSigner(t *testing.T) {
+	const (
+		digest1 = "sha256:1111111111111111111111111111111111111111111111111111111111111111"
+		digest2 = "sha256:2222222222222222222222222222222222222222222222222222222222222222"
+	)
+
+	tc := map[string]matchSignerTestCase{
+		"anyOf": {
+			signedTags:     []SignedTag{{SignedTag: testImageTag, Digest: digest1, Signers: []string{"qa"}}},
+			tag:            testImageTag,
+			spec:           whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}},
+			expectedDigest: digest1,
+		},
+		"twoOfThree": {
+			signedTags:     []SignedTag{{SignedTag: testImageTag, Digest: digest1, Signers: []string{"qa", "release"}}},
+			tag:            testImageTag,
+			spec:           whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			expectedDigest: digest1,
+		},
+		"twoOfThreeNotEnough": {
+			signedTags:     []SignedTag{{SignedTag: testImageTag, Digest: digest1, Signers: []string{"qa", "developer"}}},
+			tag:            testImageTag,
+			spec:           whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			expectedErrMsg: "signers [qa developer] do not satisfy the policy requiring 2 signers",
+		},
+		"twoOfThreeAcrossDigests": {
+			signedTags: []SignedTag{
+				{SignedTag: testImageTag, Digest: digest1, Signers: []string{"qa"}},
+				{SignedTag: testImageTag, Digest: digest2, Signers: []string{"release"}},
+			},
+			tag:            testImageTag,
+			spec:           whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			expectedErrMsg: "signers [qa release] do not satisfy the policy requiring 2 signers",
+		},
+		"repoAdmin": {
+			signedTags:     []SignedTag{{SignedTag: testImageTag, Digest: digest1, Signers: []string{"Repo Admin"}}},
+			tag:            testImageTag,
+			spec:           whv1.RegistrySpec{Signer: []string{"security"}},
+			expectedDigest: digest1,
+		},
+		"repoAdminDisallowed": {
+			signedTags:     []SignedTag{{SignedTag: testImageTag, Digest: digest1, Signers: []string{"Repo Admin"}}},
+			tag:            testImageTag,
+			spec:           whv1.RegistrySpec{Signer: []string{"security"}, DisallowRepoAdmin: true},
+			expectedErrMsg: "signatures do not have any signer",
+		},
+		"otherTagSigned": {
+			signedTags: []SignedTag{
+				{SignedTag: "v1", Digest: digest1, Signers: []string{"security"}},
+				{SignedTag: "v2", Digest: digest2, Signers: []string{"developer"}},
+			},
+			tag:            "v2",
+			spec:           whv1.RegistrySpec{Signer: []string{"security"}},
+			expectedErrMsg: "signers [developer] do not match the policy",
+		},
+		"otherTagOnly": {
+			signedTags:  []SignedTag{{SignedTag: "v1", Digest: digest1, Signers: []string{"security"}}},
+			tag:         "v2",
+			spec:        whv1.RegistrySpec{Signer: []string{"security"}},
+			expectedErr: ErrNotSigned,
+		},
+		"digest": {
+			signedTags: []SignedTag{
+				{SignedTag: testImageTag, Digest: digest1, Signers: []string{"developer"}},
+				{SignedTag: testImageTag, Digest: digest2, Signers: []string{"security"}},
+			},
+			tag:            testImageTag,
+			digest:         digest2,
+			spec:           whv1.RegistrySpec{Signer: []string{"security"}},
+			expectedDigest: digest2,
+		},
+		"digestSignedByOther": {
+			signedTags: []SignedTag{
+				{SignedTag: testImageTag, Digest: digest1, Signers: []string{"developer"}},
+				{SignedTag: testImageTag, Digest: digest2, Signers: []string{"security"}},
+			},
+			tag:            testImageTag,
+			digest:         digest1,
+			spec:           whv1.RegistrySpec{Signer: []string{"security"}},
+			expectedErrMsg: "signers [developer] do not match the policy",
+		},
+		"digestMismatch": {
+			signedTags:  []SignedTag{{SignedTag: testImageTag, Digest: digest1, Signers: []string{"security"}}},
+			tag:         testImageTag,
+			digest:      digest2,
+			spec:        whv1.RegistrySpec{Signer: []string{"security"}},
+			expectedErr: ErrDigestMismatch,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			p, err := signer.NewPolicy(c.spec)
+			require.NoError(t, err)
+
+			sig := &Signature{SignedTags: c.signedTags}
+			signedTag, err := sig.MatchSigner(c.tag, c.digest, p)
+			switch {
+			case c.expectedErr != nil:
+				require.ErrorIs(t, err, c.expectedErr)
+			case c.expectedErrMsg != "":
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+			default:
+				require.NoError(t, err)
+				require.Equal(t, c.expectedDigest, signedTag.Digest)
+			}
+		})
+	}
+}
+
+type fetchAndMatchTestCase struct {
+	imgTag string
+	digest string
+
+	expectedSignatureNil bool
+	expectedErr          error
+	expectedErrMsg       string
+}
+
+// TestFetchSignature_MatchSigner checks that only the signed target of the tag being deployed is trusted, with the mock notary server
+func TestFetchSignature_MatchSigner(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	const testImageDelegated = "delegated"
+	digest1 := strings.Repeat("1", 32)
+	digest2 := strings.Repeat("2", 32)
+
+	// v1 is signed by a trusted signer, v2 is signed by an untrusted signer and v3 is signed by the repository administrator
+	require.NoError(t, testSrv.SignImageAs(testSrv.URL, testRegistryHost, testImageDelegated, "v1", digest1, "security"))
+	require.NoError(t, testSrv.SignImageAs(testSrv.URL, testRegistryHost, testImageDelegated, "v2", digest2, "developer"))
+	_, err = testSrv.SignImage(testSrv.URL, testRegistryHost, testImageDelegated, "v3", digest2)
+	require.NoError(t, err)
+
+	p, err := signer.NewPolicy(whv1.RegistrySpec{Signer: []string{"security"}, DisallowRepoAdmin: true})
+	require.NoError(t, err)
+
+	tc := map[string]fetchAndMatchTestCase{
+		"trusted": {
+			imgTag: "v1",
+		},
+		"trustedWithDigest": {
+			imgTag: "v1",
+			digest: fmt.Sprintf("sha256:%x", digest1),
+		},
+		"untrusted": {
+			imgTag:         "v2",
+			expectedErrMsg: "signers [developer] do not match the policy",
+		},
+		"repoAdmin": {
+			imgTag:         "v3",
+			expectedErrMsg: "signatures do not have any signer",
+		},
+		"digestMismatch": {
+			imgTag:      "v1",
+			digest:      fmt.Sprintf("sha256:%x", digest2),
+			expectedErr: ErrDigestMismatch,
+		},
+		"digestOnly": {
+			digest: fmt.Sprintf("sha256:%x", digest1),
+		},
+		"digestOnlyUntrusted": {
+			digest:         fmt.Sprintf("sha256:%x", digest2),
+			expectedErrMsg: "signers [developer] do not match the policy",
+		},
+		"digestOnlyNotSigned": {
+			digest:               fmt.Sprintf("sha256:%x", strings.Repeat("3", 32)),
+			expectedSignatureNil: true,
+		},
+		// The tag may be signed only by cosign, so it is left to the cosign verification
+		"tagNotSigned": {
+			imgTag:               "v4",
+			expectedSignatureNil: true,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			imgURI := fmt.Sprintf("%s/%s", testRegistryHost, testImageDelegated)
+			if c.imgTag != "" {
+				imgURI += ":" + c.imgTag
+			}
+			if c.digest != "" {
+				imgURI += "@" + c.digest
+			}
+			sig, err := FetchSignature(imgURI, FetchOptions{NotaryServer: testSrv.URL, TLSConfig: testSrv.TLSConfig()})
+			require.NoError(t, err)
+			if c.expectedSignatureNil {
+				require.Nil(t, sig)
+				return
+			}
+			require.NotNil(t, sig)
+
+			signedTag, err := sig.MatchSigner(c.imgTag, c.digest, p)
+			switch {
+			case c.expectedErr != nil:
+				require.ErrorIs(t, err, c.expectedErr)
+			case c.expectedErrMsg != "":
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+			default:
+				require.NoError(t, err)
+				if c.imgTag != "" {
+					require.Equal(t, c.imgTag, signedTag.SignedTag)
+				}
+				require.Equal(t, fmt.Sprintf("sha256:%x", digest1), signedTag.Digest)
+			}
+		})
+	}
+}
+
+type trustPinningTestCase struct {
+	pins []whv1.NotaryTrustPin
+
+	expectedErrOccur bool
+}
+
+func TestFetchSignature_TrustPinning(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	_, err = testSrv.SignImage(testSrv.URL, testRegistryHost, testImageSigned, testImageTag, strings.Repeat("1", 32))
+	require.NoError(t, err)
+	rootKeyID, err := testSrv.RootKeyID(fmt.Sprintf("%s/%s", testRegistryHost, testImageSigned))
+	require.NoError(t, err)
+
+	getCABundle := testGetCABundle(map[string]string{"ca": testCABundle(t)})
+
+	tc := map[string]trustPinningTestCase{
+		"noPin": {},
+		"pinnedCertID": {
+			pins: []whv1.NotaryTrustPin{{GUNPrefix: testRegistryHost + "/", CertIDs: []string{rootKeyID}}},
+		},
+		"otherCertID": {
+			pins:             []whv1.NotaryTrustPin{{GUNPrefix: testRegistryHost + "/", CertIDs: []string{strings.Repeat("0", 64)}}},
+			expectedErrOccur: true,
+		},
+		"otherCA": {
+			pins:             []whv1.NotaryTrustPin{{GUNPrefix: testRegistryHost + "/", CABundle: testCABundleSource("ca")}},
+			expectedErrOccur: true,
+		},
+		"otherPrefix": {
+			pins: []whv1.NotaryTrustPin{{GUNPrefix: "other.registry/", CertIDs: []string{strings.Repeat("0", 64)}}},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			trustPinning, err := NewTrustPinConfig(c.pins, getCABundle)
+			require.NoError(t, err)
+
+			sig, err := FetchSignature(fmt.Sprintf("%s/%s:%s", testRegistryHost, testImageSigned, testImageTag), FetchOptions{NotaryServer: testSrv.URL, TrustPinning: trustPinning, TLSConfig: testSrv.TLSConfig()})
+			if c.expectedErrOccur {
+				require.Error(t, err)
+			} else {
+				require.NoError(t, err)
+				require.NotNil(t, sig)
+			}
+		})
+	}
+}
+
+type tlsTestCase struct {
+	tlsConfig func(srv *notarytest.Server) *tls.Config
+
+	expectedErrMsg string
+}
+
+func TestFetchSignature_TLS(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	tc := map[string]tlsTestCase{
+		"systemCAs": {
+			tlsConfig:      func(_ *notarytest.Server) *tls.Config { return nil },
+			expectedErrMsg: "x509: certificate signed by unknown authority",
+		},
+		"caBundle": {
+			tlsConfig: func(srv *notarytest.Server) *tls.Config { return srv.TLSConfig() },
+		},
+		"insecureSkipTLSVerify": {
+			tlsConfig: func(_ *notarytest.Server) *tls.Config { return &tls.Config{InsecureSkipVerify: true} },
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			// Each case has its own repository, so that the pooled metadata of the other cases is not used
+			repo := "tls-" + strings.ToLower(name)
+			_, err := testSrv.SignImage(testSrv.URL, testRegistryHost, repo, testImageTag, strings.Repeat("1", 32))
+			require.NoError(t, err)
+
+			sig, err := FetchSignature(fmt.Sprintf("%s/%s:%s", testRegistryHost, repo, testImageTag), FetchOptions{NotaryServer: testSrv.URL, TLSConfig: c.tlsConfig(testSrv)})
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expectedErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.NotNil(t, sig)
+		})
+	}
+}
@@ -2,13 +2,16 @@ package test
 
 import (
 	"crypto/tls"
+	"crypto/x509"
 	"encoding/json"
 	"fmt"
 	"io/ioutil"
+	"mime"
 	"net/http"
 	"net/http/httptest"
 	"os"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/gorilla/mux"
@@ -30,6 +33,8 @@ var (
 const (
 	testNotaryKeyGun     = "gun"
 	testNotaryKeyKeyType = "keyType"
+
+	trustReleasesRole = notarydata.RoleName("targets/releases")
 )
 
 // Server is a notary mock server for the test purpose
@@ -37,15 +42,24 @@ type Server struct {
 	// needAuth is true if you want a bearer token authentication
 	needAuth bool
 
+	// lock guards files and requests
+	lock sync.Mutex
+
 	// files is a map of file contents - key: gun/role name
 	files map[string]map[notarydata.RoleName][]byte
 
+	// requests is a map of the number of the metadata requests - key: gun/role name
+	requests map[string]map[notarydata.RoleName]int
+
 	// crypto is a server-side key store
 	crypto *cryptoservice.CryptoService
 
 	// timestampKey is a time stamp key of the server
 	timestampKey notarydata.TUFKey
 
+	// signDir is a directory of the notary client, which signs images. Signing keys are kept here, so that a repository can be signed several times
+	signDir string
+
 	*httptest.Server
 }
 
@@ -59,17 +73,66 @@ func New(needAuth bool) (*Server, error) {
 		return nil, err
 	}
 
+	signDir, err := ioutil.TempDir("", "notary-test-sign-image")
+	if err != nil {
+		return nil, err
+	}
+
 	srv := &Server{
 		needAuth:     needAuth,
 		files:        map[string]map[notarydata.RoleName][]byte{},
+		requests:     map[string]map[notarydata.RoleName]int{},
 		crypto:       crypto,
 		timestampKey: notarydata.TUFKey{Type: pub.Algorithm(), Value: notarydata.KeyPair{Public: pub.Public()}},
+		signDir:      signDir,
 	}
 	srv.Server = httptest.NewTLSServer(srv.notaryHandler())
 
 	return srv, nil
 }
 
+// TLSConfig returns a TLS config, which trusts the certificate of the server
+func (s *Server) TLSConfig() *tls.Config {
+	pool := x509.NewCertPool()
+	pool.AddCert(s.Certificate())
+	return &tls.Config{RootCAs: pool}
+}
+
+// RequestCount returns the number of the requests of the role's metadata of the gun
+func (s *Server) RequestCount(gun string, role notarydata.RoleName) int {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+	return s.requests[gun][role]
+}
+
+// RootKeyID returns the ID of the root key of the gun, which is the root certificate ID to be pinned
+func (s *Server) RootKeyID(gun string) (string, error) {
+	s.lock.Lock()
+	defer s.lock.Unlock()
+
+	root := struct {
+		Signed struct {
+			Roles map[notarydata.RoleName]struct {
+				KeyIDs []string `json:"keyids"`
+			} `json:"roles"`
+		} `json:"signed"`
+	}{}
+	if err := json.Unmarshal(s.files[gun][notarydata.CanonicalRootRole], &root); err != nil {
+		return "", err
+	}
+	keyIDs := root.Signed.Roles[notarydata.CanonicalRootRole].KeyIDs
+	if len(keyIDs) == 0 {
+		return "", fmt.Errorf("there is no root key of %s", gun)
+	}
+	return keyIDs[0], nil
+}
+
+// Close shuts down the server and removes the signing keys
+func (s *Server) Close() {
+	s.Server.Close()
+	_ = os.RemoveAll(s.signDir)
+}
+
 func (s *Server) authHandler(h http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		serverLog.Info(req.Method + ": " + req.URL.String())
@@ -122,21 +185,30 @@ func (s *Server) notaryHandler() http.Handler {
 	})
 
 	// Get keys json
-	m.Methods(http.MethodGet).Path(fmt.Sprintf("/v2/{%s:[^*]+}/_trust/tuf/{%s}.json", testNotaryKeyGun, testNotaryKeyKeyType)).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+	m.Methods(http.MethodGet).Path(fmt.Sprintf("/v2/{%s:[^*]+}/_trust/tuf/{%s:.+}.json", testNotaryKeyGun, testNotaryKeyKeyType)).HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
 		vars := mux.Vars(req)
-		keys, ok := s.files[vars[testNotaryKeyGun]]
+		gun := vars[testNotaryKeyGun]
+
+		// Consistent names (e.g., targets/releases.<checksum>) are mapped to the role name
+		keyType := vars[testNotaryKeyKeyType]
+		if i := strings.Index(keyType, "."); i >= 0 {
+			keyType = keyType[:i]
+		}
+
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		if _, ok := s.requests[gun]; !ok {
+			s.requests[gun] = map[notarydata.RoleName]int{}
+		}
+		s.requests[gun][notarydata.RoleName(keyType)]++
+
+		keys, ok := s.files[gun]
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
 			return
 		}
 
-		keyType := vars[testNotaryKeyKeyType]
-		if strings.HasPrefix(keyType, string(notarydata.CanonicalSnapshotRole)) {
-			keyType = string(notarydata.CanonicalSnapshotRole)
-		} else if strings.HasPrefix(keyType, string(notarydata.CanonicalTargetsRole)) {
-			keyType = string(notarydata.CanonicalTargetsRole)
-		}
-
 		key, ok := keys[notarydata.RoleName(keyType)]
 		if !ok {
 			w.WriteHeader(http.StatusNotFound)
@@ -150,8 +222,14 @@ func (s *Server) notaryHandler() http.Handler {
 		vars := mux.Vars(req)
 		_ = req.ParseMultipartForm(32 << 20)
 
+		s.lock.Lock()
+		defer s.lock.Unlock()
+
+		// Published files are merged into the existing ones, as only the updated roles are published
 		gun := vars[testNotaryKeyGun]
-		s.files[gun] = map[notarydata.RoleName][]byte{}
+		if _, ok := s.files[gun]; !ok {
+			s.files[gun] = map[notarydata.RoleName][]byte{}
+		}
 		tufRepo := tuf.NewRepo(s.crypto)
 
 		files := req.MultipartForm.File["files"]
@@ -163,23 +241,29 @@ func (s *Server) notaryHandler() http.Handler {
 				return
 			}
 
+			// Filename of the multipart file header is a base name, which drops targets/ of the delegation roles
 			fileName := notarydata.RoleName(f.Filename)
+			if _, params, err := mime.ParseMediaType(f.Header.Get("Content-Disposition")); err == nil && params["filename"] != "" {
+				fileName = notarydata.RoleName(params["filename"])
+			}
 			s.files[gun][fileName], err = ioutil.ReadAll(file)
 			if err != nil {
 				serverLog.Error(err, "")
 				w.WriteHeader(http.StatusInternalServerError)
 				return
 			}
+		}
 
+		for fileName, b := range s.files[gun] {
 			switch fileName {
 			case notarydata.CanonicalRootRole:
-				if err := json.Unmarshal(s.files[gun][fileName], &tufRepo.Root); err != nil {
+				if err := json.Unmarshal(b, &tufRepo.Root); err != nil {
 					serverLog.Error(err, "")
 					w.WriteHeader(http.StatusInternalServerError)
 					return
 				}
 			case notarydata.CanonicalSnapshotRole:
-				if err := json.Unmarshal(s.files[gun][fileName], &tufRepo.Snapshot); err != nil {
+				if err := json.Unmarshal(b, &tufRepo.Snapshot); err != nil {
 					serverLog.Error(err, "")
 					w.WriteHeader(http.StatusInternalServerError)
 					return
@@ -216,29 +300,75 @@ func (rt *testRoundTrip) RoundTrip(r *http.Request) (*http.Response, error) {
 
 // SignImage signs an image and publish it to the notary mock server
 func (s *Server) SignImage(srvURL, imgHost, imgRepo, imgTag, digest string) (string, error) {
-	// Init notary client and sign images
-	tempDir := fmt.Sprintf("%s/notary/test-sign-image", os.TempDir())
-	rt := &testRoundTrip{}
-	repo, err := client.NewFileCachedRepository(tempDir, notarydata.GUN(fmt.Sprintf("%s/%s", imgHost, imgRepo)), srvURL, rt, passphrase.ConstantRetriever("test"), trustpinning.TrustPinConfig{})
+	repo, err := s.signImage(srvURL, imgHost, imgRepo, imgTag, digest, nil)
 	if err != nil {
 		return "", err
 	}
-	defer func() {
-		_ = os.RemoveAll(tempDir)
-	}()
-	rootPub, err := repo.GetCryptoService().Create(notarydata.CanonicalRootRole, "", notarydata.ECDSAKey)
+
+	var targetKey string
+	for _, k := range repo.GetCryptoService().ListKeys(notarydata.CanonicalTargetsRole) {
+		targetKey = k
+		break
+	}
+
+	return targetKey, nil
+}
+
+// SignImageAs signs an image as the signers and publish it to the notary mock server.
+// The signers are delegation roles (targets/<signer>), and the image is also signed into targets/releases
+// so that the signers are shown for the tag, just like docker trust sign does
+func (s *Server) SignImageAs(srvURL, imgHost, imgRepo, imgTag, digest string, signers ...string) error {
+	roles := []notarydata.RoleName{trustReleasesRole}
+	for _, sgr := range signers {
+		roles = append(roles, notarydata.RoleName(fmt.Sprintf("%s/%s", notarydata.CanonicalTargetsRole, sgr)))
+	}
+	_, err := s.signImage(srvURL, imgHost, imgRepo, imgTag, digest, roles)
+	return err
+}
+
+// signImage signs an image into the roles. The image is signed into the targets role if there is no role
+func (s *Server) signImage(srvURL, imgHost, imgRepo, imgTag, digest string, roles []notarydata.RoleName) (client.Repository, error) {
+	// Init notary client and sign images
+	rt := &testRoundTrip{}
+	repo, err := client.NewFileCachedRepository(s.signDir, notarydata.GUN(fmt.Sprintf("%s/%s", imgHost, imgRepo)), srvURL, rt, passphrase.ConstantRetriever("test"), trustpinning.TrustPinConfig{})
 	if err != nil {
-		return "", err
+		return nil, err
 	}
 
+	// Delegations of the published repository, which are not created again
+	var delegations []notarydata.Role
 	if _, err := repo.ListTargets(); err != nil {
 		switch err.(type) {
 		case client.ErrRepoNotInitialized, client.ErrRepositoryNotExist:
+			rootPub, err := repo.GetCryptoService().Create(notarydata.CanonicalRootRole, "", notarydata.ECDSAKey)
+			if err != nil {
+				return nil, err
+			}
 			if err := repo.Initialize([]string{rootPub.ID()}); err != nil {
-				return "", err
+				return nil, err
 			}
 		default:
-			return "", err
+			return nil, err
+		}
+	} else if delegations, err = repo.GetDelegationRoles(); err != nil {
+		return nil, err
+	}
+
+	if len(roles) == 0 {
+		roles = []notarydata.RoleName{notarydata.CanonicalTargetsRole}
+	}
+
+	// Create delegation roles which do not exist yet
+	for _, role := range roles {
+		if role == notarydata.CanonicalTargetsRole || hasRole(delegations, role) {
+			continue
+		}
+		pub, err := repo.GetCryptoService().Create(role, repo.GetGUN(), notarydata.ECDSAKey)
+		if err != nil {
+			return nil, err
+		}
+		if err := repo.AddDelegation(role, []notarydata.PublicKey{pub}, []string{""}); err != nil {
+			return nil, err
 		}
 	}
 
@@ -247,19 +377,22 @@ func (s *Server) SignImage(srvURL, imgHost, imgRepo, imgTag, digest string) (str
 		Hashes: notarydata.Hashes{"sha256": []byte(digest)},
 		Length: 32,
 	}
-	if err := repo.AddTarget(target, notarydata.CanonicalTargetsRole); err != nil {
-		return "", err
+	if err := repo.AddTarget(target, roles...); err != nil {
+		return nil, err
 	}
 
 	if err := repo.Publish(); err != nil {
-		return "", err
+		return nil, err
 	}
 
-	var targetKey string
-	for _, k := range repo.GetCryptoService().ListKeys(notarydata.CanonicalTargetsRole) {
-		targetKey = k
-		break
-	}
+	return repo, nil
+}
 
-	return targetKey, nil
+func hasRole(roles []notarydata.Role, name notarydata.RoleName) bool {
+	for _, r := range roles {
+		if r.Name == name {
+			return true
+		}
+	}
+	return false
 }
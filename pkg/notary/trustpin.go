@@ -0,0 +1,103 @@
+package notary
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/theupdateframework/notary/trustpinning"
+
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// caBundleDir is a directory of the CA bundles of the trust pins. The notary client loads CA bundles only from files
+var caBundleDir = filepath.Join(os.TempDir(), "notary", "ca")
+
+// NewTrustPinConfig converts the trust pins of the policy to the trust pinning of the notary client. The CA bundles of the pins
+// are read by getCABundle. Repositories which no pin matches trust their first roots
+func NewTrustPinConfig(pins []whv1.NotaryTrustPin, getCABundle func(source *whv1.CABundleSource) ([]byte, error)) (trustpinning.TrustPinConfig, error) {
+	cfg := trustpinning.TrustPinConfig{}
+	for _, pin := range pins {
+		switch {
+		case len(pin.CertIDs) > 0:
+			if cfg.Certs == nil {
+				cfg.Certs = map[string][]string{}
+			}
+			// GUNs ending with * are prefixes
+			cfg.Certs[pin.GUNPrefix+"*"] = pin.CertIDs
+		case pin.CABundle != nil:
+			bundle, err := getCABundle(pin.CABundle)
+			if err != nil {
+				return trustpinning.TrustPinConfig{}, fmt.Errorf("trust pin of %s is invalid: %w", pin.GUNPrefix, err)
+			}
+			caFile, err := writeCABundle(bundle)
+			if err != nil {
+				return trustpinning.TrustPinConfig{}, fmt.Errorf("trust pin of %s is invalid: %w", pin.GUNPrefix, err)
+			}
+			if cfg.CA == nil {
+				cfg.CA = map[string]string{}
+			}
+			cfg.CA[pin.GUNPrefix] = caFile
+		default:
+			return trustpinning.TrustPinConfig{}, fmt.Errorf("trust pin of %s should have either certIDs or caBundle", pin.GUNPrefix)
+		}
+	}
+	return cfg, nil
+}
+
+// writeCABundle writes the PEM encoded CA bundle to a file named after its checksum, so that the same bundle is written once
+// and the file is reused by the following requests
+func writeCABundle(bundle []byte) (string, error) {
+	if !hasCertificate(bundle) {
+		return "", fmt.Errorf("CA bundle does not have any certificate")
+	}
+
+	caFile := filepath.Join(caBundleDir, fmt.Sprintf("%x.pem", sha256.Sum256(bundle)))
+	if _, err := os.Stat(caFile); err == nil {
+		return caFile, nil
+	}
+
+	if err := os.MkdirAll(caBundleDir, 0700); err != nil {
+		return "", err
+	}
+	// Write to a temporary file and rename it, as the bundle can be written concurrently
+	tmpFile, err := ioutil.TempFile(caBundleDir, "ca-")
+	if err != nil {
+		return "", err
+	}
+	defer func() {
+		_ = os.Remove(tmpFile.Name())
+	}()
+	if _, err := tmpFile.Write(bundle); err != nil {
+		_ = tmpFile.Close()
+		return "", err
+	}
+	if err := tmpFile.Close(); err != nil {
+		return "", err
+	}
+	if err := os.Rename(tmpFile.Name(), caFile); err != nil {
+		return "", err
+	}
+	return caFile, nil
+}
+
+// hasCertificate checks if the PEM encoded data has at least a certificate
+func hasCertificate(b []byte) bool {
+	for {
+		var block *pem.Block
+		block, b = pem.Decode(b)
+		if block == nil {
+			return false
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		if _, err := x509.ParseCertificate(block.Bytes); err == nil {
+			return true
+		}
+	}
+}
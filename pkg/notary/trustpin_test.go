@@ -0,0 +1,144 @@
+package notary
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"fmt"
+	"math/big"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+type trustPinConfigTestCase struct {
+	pins []whv1.NotaryTrustPin
+
+	expectedCerts    map[string][]string
+	expectedCAs      []string
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestNewTrustPinConfig(t *testing.T) {
+	caBundle := testCABundle(t)
+	getCABundle := testGetCABundle(map[string]string{"ca": caBundle, "invalid": "invalid"})
+
+	tc := map[string]trustPinConfigTestCase{
+		"certIDs": {
+			pins:          []whv1.NotaryTrustPin{{GUNPrefix: "registry.io/team/", CertIDs: []string{"abc"}}},
+			expectedCerts: map[string][]string{"registry.io/team/*": {"abc"}},
+		},
+		"certIDsOverCA": {
+			pins:          []whv1.NotaryTrustPin{{GUNPrefix: "registry.io/", CertIDs: []string{"abc"}, CABundle: testCABundleSource("ca")}},
+			expectedCerts: map[string][]string{"registry.io/*": {"abc"}},
+		},
+		"caBundle": {
+			pins:        []whv1.NotaryTrustPin{{GUNPrefix: "registry.io/", CABundle: testCABundleSource("ca")}},
+			expectedCAs: []string{"registry.io/"},
+		},
+		"caBundlesOfSameCerts": {
+			pins: []whv1.NotaryTrustPin{
+				{GUNPrefix: "registry.io/", CABundle: testCABundleSource("ca")},
+				{GUNPrefix: "other.registry.io/", CABundle: testCABundleSource("ca")},
+			},
+			expectedCAs: []string{"registry.io/", "other.registry.io/"},
+		},
+		"invalidCABundle": {
+			pins:             []whv1.NotaryTrustPin{{GUNPrefix: "registry.io/", CABundle: testCABundleSource("invalid")}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "trust pin of registry.io/ is invalid: CA bundle does not have any certificate",
+		},
+		"missingCABundle": {
+			pins:             []whv1.NotaryTrustPin{{GUNPrefix: "registry.io/", CABundle: testCABundleSource("missing")}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "trust pin of registry.io/ is invalid: secret test/missing does not exist",
+		},
+		"empty": {
+			pins:             []whv1.NotaryTrustPin{{GUNPrefix: "registry.io/"}},
+			expectedErrOccur: true,
+			expectedErrMsg:   "trust pin of registry.io/ should have either certIDs or caBundle",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			cfg, err := NewTrustPinConfig(c.pins, getCABundle)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedCerts, cfg.Certs)
+			require.Len(t, cfg.CA, len(c.expectedCAs))
+			for _, prefix := range c.expectedCAs {
+				b, err := os.ReadFile(cfg.CA[prefix])
+				require.NoError(t, err)
+				require.Equal(t, caBundle, string(b))
+				// The same bundle is written to the same file
+				require.Equal(t, cfg.CA[c.expectedCAs[0]], cfg.CA[prefix])
+			}
+		})
+	}
+}
+
+func TestWriteCABundle(t *testing.T) {
+	caBundle := []byte(testCABundle(t))
+
+	caFile, err := writeCABundle(caBundle)
+	require.NoError(t, err)
+	info, err := os.Stat(caFile)
+	require.NoError(t, err)
+
+	// The file is reused for the same bundle, instead of writing a new one
+	reusedFile, err := writeCABundle(append([]byte{}, caBundle...))
+	require.NoError(t, err)
+	require.Equal(t, caFile, reusedFile)
+	reusedInfo, err := os.Stat(reusedFile)
+	require.NoError(t, err)
+	require.Equal(t, info.ModTime(), reusedInfo.ModTime())
+
+	otherFile, err := writeCABundle([]byte(testCABundle(t)))
+	require.NoError(t, err)
+	require.NotEqual(t, caFile, otherFile)
+}
+
+// testCABundleSource returns a source of the secret, whose bundle is got by testGetCABundle
+func testCABundleSource(name string) *whv1.CABundleSource {
+	return &whv1.CABundleSource{SecretRef: &whv1.KeyObjectReference{Namespace: "test", Name: name}}
+}
+
+// testGetCABundle returns a function which gets the CA bundles of the secrets by their names
+func testGetCABundle(bundles map[string]string) func(source *whv1.CABundleSource) ([]byte, error) {
+	return func(source *whv1.CABundleSource) ([]byte, error) {
+		bundle, exist := bundles[source.SecretRef.Name]
+		if !exist {
+			return nil, fmt.Errorf("secret %s/%s does not exist", source.SecretRef.Namespace, source.SecretRef.Name)
+		}
+		return []byte(bundle), nil
+	}
+}
+
+func testCABundle(t *testing.T) string {
+	privKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	require.NoError(t, err)
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "test-ca"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &privKey.PublicKey, privKey)
+	require.NoError(t, err)
+	return string(pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}))
+}
@@ -0,0 +1,61 @@
+package server
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	authenticationv1 "k8s.io/api/authentication/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+)
+
+var (
+	authLog = logf.Log.WithName("server/auth.go")
+)
+
+// userKey is the context key of the authenticated user
+type userKey struct{}
+
+// Authenticated authenticates the requests by their bearer tokens with the TokenReview API, before they are passed to the handler.
+// Requests without valid tokens are rejected as unauthorized. The user of the token is put in the context of the request
+func Authenticated(client kubernetes.Interface, h http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		token := bearerToken(req)
+		if token == "" {
+			http.Error(w, "Bearer token is required", http.StatusUnauthorized)
+			return
+		}
+
+		review, err := client.AuthenticationV1().TokenReviews().Create(req.Context(), &authenticationv1.TokenReview{
+			Spec: authenticationv1.TokenReviewSpec{Token: token},
+		}, metav1.CreateOptions{})
+		if err != nil {
+			authLog.Error(err, "Couldn't review token")
+			http.Error(w, "Couldn't authenticate request", http.StatusInternalServerError)
+			return
+		}
+		if !review.Status.Authenticated {
+			http.Error(w, "Token is not valid", http.StatusUnauthorized)
+			return
+		}
+
+		h.ServeHTTP(w, req.WithContext(context.WithValue(req.Context(), userKey{}, review.Status.User)))
+	})
+}
+
+// UserFrom returns the user authenticated by Authenticated from the context
+func UserFrom(ctx context.Context) (authenticationv1.UserInfo, bool) {
+	user, ok := ctx.Value(userKey{}).(authenticationv1.UserInfo)
+	return user, ok
+}
+
+// bearerToken returns the bearer token of the Authorization header. It is empty if there is none
+func bearerToken(req *http.Request) string {
+	parts := strings.SplitN(req.Header.Get("Authorization"), " ", 2)
+	if len(parts) != 2 || !strings.EqualFold(parts[0], "Bearer") {
+		return ""
+	}
+	return strings.TrimSpace(parts[1])
+}
@@ -0,0 +1,86 @@
+package server
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+type authenticatedTestCase struct {
+	authorization string
+
+	expectedStatusCode int
+	expectedOutput     string
+}
+
+func TestAuthenticated(t *testing.T) {
+	testCli := fake.NewSimpleClientset()
+	testCli.PrependReactor("create", "tokenreviews", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		review := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenReview)
+		switch review.Spec.Token {
+		case "valid-token":
+			review.Status = authenticationv1.TokenReviewStatus{Authenticated: true, User: authenticationv1.UserInfo{Username: "alice"}}
+		case "error-token":
+			return true, nil, fmt.Errorf("token review is not available")
+		}
+		return true, review, nil
+	})
+
+	h := Authenticated(testCli, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		user, ok := UserFrom(req.Context())
+		require.True(t, ok, "user")
+		_, _ = w.Write([]byte(user.Username))
+	}))
+
+	tc := map[string]authenticatedTestCase{
+		"valid": {
+			authorization:      "Bearer valid-token",
+			expectedStatusCode: http.StatusOK,
+			expectedOutput:     "alice",
+		},
+		"noToken": {
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedOutput:     "Bearer token is required\n",
+		},
+		"basic": {
+			authorization:      "Basic YWxpY2U6cGFzc3dvcmQ=",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedOutput:     "Bearer token is required\n",
+		},
+		"invalidToken": {
+			authorization:      "Bearer invalid-token",
+			expectedStatusCode: http.StatusUnauthorized,
+			expectedOutput:     "Token is not valid\n",
+		},
+		"reviewError": {
+			authorization:      "Bearer error-token",
+			expectedStatusCode: http.StatusInternalServerError,
+			expectedOutput:     "Couldn't authenticate request\n",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/test", nil)
+			if c.authorization != "" {
+				req.Header.Set("Authorization", c.authorization)
+			}
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, req)
+
+			output, err := ioutil.ReadAll(w.Result().Body)
+			require.NoError(t, err)
+
+			require.Equal(t, c.expectedStatusCode, w.Code, "code")
+			require.Equal(t, c.expectedOutput, string(output), "output")
+		})
+	}
+}
@@ -0,0 +1,195 @@
+package signer
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+// MatchType is a type of matching the signers with the policy
+type MatchType string
+
+// Match types of the signers
+const (
+	MatchExact MatchType = "exact"
+	MatchGlob  MatchType = "glob"
+	MatchRegex MatchType = "regex"
+)
+
+const (
+	// DefaultAnnotationKey is a default key of the cosign signature annotation which has the signer
+	DefaultAnnotationKey = "signer"
+
+	// RepoAdmin is a notary signer of the repository administrator (targets role)
+	RepoAdmin = "Repo Admin"
+)
+
+// Policy is a policy of the signers of images
+type Policy struct {
+	// AnnotationKey is a key of the cosign signature annotation which has the signer
+	AnnotationKey string
+	// Threshold is the number of distinct signers matching the policy, which should sign images
+	Threshold int
+	// AllowRepoAdmin allows notary signatures of the repository administrator regardless of the signers
+	AllowRepoAdmin bool
+
+	signers   []string
+	matchType MatchType
+	regexps   []*regexp.Regexp
+}
+
+// NewPolicy creates a signer policy from the registry spec
+func NewPolicy(spec whv1.RegistrySpec) (*Policy, error) {
+	p := &Policy{
+		AnnotationKey:  spec.SignerAnnotationKey,
+		Threshold:      spec.SignerThreshold,
+		AllowRepoAdmin: !spec.DisallowRepoAdmin,
+		signers:        spec.Signer,
+		matchType:      MatchType(spec.SignerMatchType),
+	}
+	if p.AnnotationKey == "" {
+		p.AnnotationKey = DefaultAnnotationKey
+	}
+	if p.Threshold == 0 {
+		p.Threshold = 1
+	}
+	if p.matchType == "" {
+		p.matchType = MatchExact
+	}
+
+	switch p.matchType {
+	case MatchExact:
+		if spec.SignerThreshold > len(p.signers) {
+			return nil, fmt.Errorf("signer threshold %d is greater than the number of signers %d", p.Threshold, len(p.signers))
+		}
+	case MatchGlob, MatchRegex:
+		for _, s := range p.signers {
+			expr := s
+			if p.matchType == MatchGlob {
+				expr = globToRegex(s)
+			}
+			re, err := regexp.Compile("^(?:" + expr + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("signer '%s' is not a valid %s pattern: %v", s, p.matchType, err)
+			}
+			p.regexps = append(p.regexps, re)
+		}
+	default:
+		return nil, fmt.Errorf("signer match type %s is not supported", p.matchType)
+	}
+
+	return p, nil
+}
+
+// CheckKeys checks if the cosign signatures of the keys can satisfy the policy. Cosign signers are counted by distinct keys, whatever
+// the match type is, so the policy requiring more signers than the keys would deny every image
+func (p *Policy) CheckKeys(numKeys int) error {
+	if len(p.signers) > 0 && p.Threshold > numKeys {
+		return fmt.Errorf("signer threshold %d is greater than the number of cosign keys %d", p.Threshold, numKeys)
+	}
+	return nil
+}
+
+// Signers returns the signers of the policy
+func (p *Policy) Signers() []string {
+	return p.signers
+}
+
+// Match checks if the signer matches any of the signers of the policy
+func (p *Policy) Match(signer string) bool {
+	if p.matchType == MatchExact {
+		for _, s := range p.signers {
+			if s == signer {
+				return true
+			}
+		}
+		return false
+	}
+
+	for _, re := range p.regexps {
+		if re.MatchString(signer) {
+			return true
+		}
+	}
+	return false
+}
+
+// MatchSigners checks if at least Threshold distinct signers match the policy
+func (p *Policy) MatchSigners(signers []string) bool {
+	matched := map[string]struct{}{}
+	for _, s := range signers {
+		if p.Match(s) {
+			matched[s] = struct{}{}
+		}
+	}
+	return len(matched) >= p.Threshold
+}
+
+// MatchKeySigners checks if at least Threshold distinct signers matching the policy are claimed by distinct keys. keySigners
+// are the signers of the signatures verified by each key, so that a key counts as one signer however many signers it claims
+func (p *Policy) MatchKeySigners(keySigners [][]string) bool {
+	// Signers are assigned to the keys by augmenting paths, i.e., the maximum bipartite matching of them
+	assigned := map[string]int{}
+	var assign func(key int, visited map[string]bool) bool
+	assign = func(key int, visited map[string]bool) bool {
+		for _, s := range keySigners[key] {
+			if visited[s] || !p.Match(s) {
+				continue
+			}
+			visited[s] = true
+			if k, exist := assigned[s]; !exist || assign(k, visited) {
+				assigned[s] = key
+				return true
+			}
+		}
+		return false
+	}
+
+	matched := 0
+	for key := range keySigners {
+		if assign(key, map[string]bool{}) {
+			matched++
+		}
+	}
+	return matched >= p.Threshold
+}
+
+// globToRegex converts a glob pattern to a regular expression. '*' matches any characters and '?' matches a character
+func globToRegex(glob string) string {
+	expr := regexp.QuoteMeta(glob)
+	expr = strings.ReplaceAll(expr, `\*`, ".*")
+	expr = strings.ReplaceAll(expr, `\?`, ".")
+	return expr
+}
+
+// MismatchError is an error for the signatures, whose signers do not match the policy
+type MismatchError struct {
+	// AnnotationKey is a key of the annotation which has the signer
+	AnnotationKey string
+	// Signers are the signers of the signatures
+	Signers []string
+	// Threshold is the number of distinct signers required by the policy
+	Threshold int
+}
+
+func (e *MismatchError) Error() string {
+	// Notary signers are roles, not annotations
+	if e.AnnotationKey == "" {
+		if len(e.Signers) == 0 {
+			return "signatures do not have any signer"
+		}
+		if e.Threshold > 1 {
+			return fmt.Sprintf("signers %v do not satisfy the policy requiring %d signers", e.Signers, e.Threshold)
+		}
+		return fmt.Sprintf("signers %v do not match the policy", e.Signers)
+	}
+	if len(e.Signers) == 0 {
+		return fmt.Sprintf("signatures do not have the signer annotation '%s'", e.AnnotationKey)
+	}
+	if e.Threshold > 1 {
+		return fmt.Sprintf("signers %v of the annotation '%s' do not satisfy the policy requiring %d signers", e.Signers, e.AnnotationKey, e.Threshold)
+	}
+	return fmt.Sprintf("signers %v of the annotation '%s' do not match the policy", e.Signers, e.AnnotationKey)
+}
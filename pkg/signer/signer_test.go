@@ -0,0 +1,233 @@
+package signer
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/require"
+	whv1 "github.com/tmax-cloud/image-validating-webhook/pkg/type"
+)
+
+type policyMatchTestCase struct {
+	spec   whv1.RegistrySpec
+	signer string
+
+	expectedMatch    bool
+	expectedErrOccur bool
+	expectedErrMsg   string
+}
+
+func TestPolicy_Match(t *testing.T) {
+	tc := map[string]policyMatchTestCase{
+		"exact": {
+			spec:          whv1.RegistrySpec{Signer: []string{"alice", "bob"}},
+			signer:        "bob",
+			expectedMatch: true,
+		},
+		"exactMismatch": {
+			spec:   whv1.RegistrySpec{Signer: []string{"alice"}, SignerMatchType: "exact"},
+			signer: "alice@tmax.co.kr",
+		},
+		"glob": {
+			spec:          whv1.RegistrySpec{Signer: []string{"*@tmax.co.kr"}, SignerMatchType: "glob"},
+			signer:        "alice@tmax.co.kr",
+			expectedMatch: true,
+		},
+		"globEscaped": {
+			spec:   whv1.RegistrySpec{Signer: []string{"*@tmax.co.kr"}, SignerMatchType: "glob"},
+			signer: "alice@tmaxXco.kr",
+		},
+		"globSingleChar": {
+			spec:          whv1.RegistrySpec{Signer: []string{"team-?"}, SignerMatchType: "glob"},
+			signer:        "team-a",
+			expectedMatch: true,
+		},
+		"regex": {
+			spec:          whv1.RegistrySpec{Signer: []string{"ci-(dev|prod)"}, SignerMatchType: "regex"},
+			signer:        "ci-prod",
+			expectedMatch: true,
+		},
+		"regexAnchored": {
+			spec:   whv1.RegistrySpec{Signer: []string{"ci-(dev|prod)"}, SignerMatchType: "regex"},
+			signer: "ci-production",
+		},
+		"invalidRegex": {
+			spec:             whv1.RegistrySpec{Signer: []string{"ci-("}, SignerMatchType: "regex"},
+			expectedErrOccur: true,
+			expectedErrMsg:   "signer 'ci-(' is not a valid regex pattern: error parsing regexp: missing closing ): `^(?:ci-()$`",
+		},
+		"invalidMatchType": {
+			spec:             whv1.RegistrySpec{Signer: []string{"alice"}, SignerMatchType: "prefix"},
+			expectedErrOccur: true,
+			expectedErrMsg:   "signer match type prefix is not supported",
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewPolicy(c.spec)
+			if c.expectedErrOccur {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, c.expectedMatch, p.Match(c.signer))
+		})
+	}
+}
+
+type policyMatchSignersTestCase struct {
+	spec    whv1.RegistrySpec
+	signers []string
+
+	expectedMatch bool
+}
+
+func TestPolicy_MatchSigners(t *testing.T) {
+	tc := map[string]policyMatchSignersTestCase{
+		"default": {
+			spec:          whv1.RegistrySpec{Signer: []string{"qa", "security"}},
+			signers:       []string{"developer", "security"},
+			expectedMatch: true,
+		},
+		"threshold": {
+			spec:          whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			signers:       []string{"qa", "security"},
+			expectedMatch: true,
+		},
+		"thresholdDuplicated": {
+			spec:    whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			signers: []string{"qa", "qa"},
+		},
+		"thresholdGlob": {
+			spec:          whv1.RegistrySpec{Signer: []string{"*@tmax.co.kr"}, SignerMatchType: "glob", SignerThreshold: 2},
+			signers:       []string{"alice@tmax.co.kr", "bob@tmax.co.kr"},
+			expectedMatch: true,
+		},
+		"noSigners": {
+			spec: whv1.RegistrySpec{Signer: []string{"qa"}},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewPolicy(c.spec)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedMatch, p.MatchSigners(c.signers))
+		})
+	}
+}
+
+type policyMatchKeySignersTestCase struct {
+	spec       whv1.RegistrySpec
+	keySigners [][]string
+
+	expectedMatch bool
+}
+
+func TestPolicy_MatchKeySigners(t *testing.T) {
+	tc := map[string]policyMatchKeySignersTestCase{
+		"default": {
+			spec:          whv1.RegistrySpec{Signer: []string{"qa"}},
+			keySigners:    [][]string{{"developer"}, {"qa"}},
+			expectedMatch: true,
+		},
+		"threshold": {
+			spec:          whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			keySigners:    [][]string{{"qa"}, {"security"}},
+			expectedMatch: true,
+		},
+		"thresholdOneKey": {
+			spec:       whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			keySigners: [][]string{{"qa", "security"}},
+		},
+		"thresholdSameSigner": {
+			spec:       whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 2},
+			keySigners: [][]string{{"qa"}, {"qa"}},
+		},
+		"thresholdReassigned": {
+			spec:          whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 3},
+			keySigners:    [][]string{{"qa", "security", "release"}, {"qa", "security"}, {"qa"}},
+			expectedMatch: true,
+		},
+		"thresholdNotReassigned": {
+			spec:       whv1.RegistrySpec{Signer: []string{"qa", "security", "release"}, SignerThreshold: 3},
+			keySigners: [][]string{{"qa", "security", "release"}, {"qa"}, {"qa"}},
+		},
+		"noKeys": {
+			spec: whv1.RegistrySpec{Signer: []string{"qa"}},
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewPolicy(c.spec)
+			require.NoError(t, err)
+			require.Equal(t, c.expectedMatch, p.MatchKeySigners(c.keySigners))
+		})
+	}
+}
+
+func TestNewPolicy_Threshold(t *testing.T) {
+	_, err := NewPolicy(whv1.RegistrySpec{Signer: []string{"qa"}, SignerThreshold: 2})
+	require.Error(t, err)
+	require.Equal(t, "signer threshold 2 is greater than the number of signers 1", err.Error())
+}
+
+type policyCheckKeysTestCase struct {
+	spec    whv1.RegistrySpec
+	numKeys int
+
+	expectedErrMsg string
+}
+
+func TestPolicy_CheckKeys(t *testing.T) {
+	tc := map[string]policyCheckKeysTestCase{
+		"default": {
+			spec:    whv1.RegistrySpec{Signer: []string{"qa"}},
+			numKeys: 1,
+		},
+		"threshold": {
+			spec:    whv1.RegistrySpec{Signer: []string{"qa", "security"}, SignerThreshold: 2},
+			numKeys: 2,
+		},
+		"thresholdOneKey": {
+			spec:           whv1.RegistrySpec{Signer: []string{"qa", "security"}, SignerThreshold: 2},
+			numKeys:        1,
+			expectedErrMsg: "signer threshold 2 is greater than the number of cosign keys 1",
+		},
+		"thresholdGlobOneKey": {
+			spec:           whv1.RegistrySpec{Signer: []string{"*@tmax.co.kr"}, SignerMatchType: "glob", SignerThreshold: 2},
+			numKeys:        1,
+			expectedErrMsg: "signer threshold 2 is greater than the number of cosign keys 1",
+		},
+		"noSigners": {
+			spec:    whv1.RegistrySpec{},
+			numKeys: 1,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			p, err := NewPolicy(c.spec)
+			require.NoError(t, err)
+			err = p.CheckKeys(c.numKeys)
+			if c.expectedErrMsg == "" {
+				require.NoError(t, err)
+			} else {
+				require.Error(t, err)
+				require.Equal(t, c.expectedErrMsg, err.Error())
+			}
+		})
+	}
+}
+
+func TestNewPolicy_AnnotationKey(t *testing.T) {
+	p, err := NewPolicy(whv1.RegistrySpec{})
+	require.NoError(t, err)
+	require.Equal(t, DefaultAnnotationKey, p.AnnotationKey)
+
+	p, err = NewPolicy(whv1.RegistrySpec{SignerAnnotationKey: "team"})
+	require.NoError(t, err)
+	require.Equal(t, "team", p.AnnotationKey)
+}
@@ -0,0 +1,189 @@
+package trust
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/opencontainers/go-digest"
+	"github.com/theupdateframework/notary/client"
+	"github.com/theupdateframework/notary/cryptoservice"
+	store "github.com/theupdateframework/notary/storage"
+	"github.com/theupdateframework/notary/trustpinning"
+	"github.com/theupdateframework/notary/tuf"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+)
+
+const (
+	// DefaultRefreshInterval is the default interval of updating the TUF metadata of a pooled repository,
+	// even if its roles are not expired yet
+	DefaultRefreshInterval = 1 * time.Minute
+
+	// poolIdleTimeout is the time after which a repository, which is not used, is dropped from the pool
+	poolIdleTimeout = 1 * time.Hour
+)
+
+// Pool is a pool of the notary repositories, which is safe for concurrent use.
+// A repository is kept per notary server, GUN, credential and trust pinning, and its TUF metadata is reused across the requests.
+// The metadata is updated only when any of the roles expires, or the refresh interval passes.
+// Updates are verified against the metadata kept in the pool, so rollback and freeze attacks are still detected
+type Pool struct {
+	refreshInterval time.Duration
+
+	lock  sync.Mutex
+	repos map[string]*pooledRepo
+
+	// now is for the test purpose
+	now func() time.Time
+}
+
+// pooledRepo is a notary repository of the pool
+type pooledRepo struct {
+	lock sync.Mutex
+
+	gun          data.GUN
+	notaryURL    string
+	trustPinning trustpinning.TrustPinConfig
+
+	// cache keeps the TUF metadata of the repository, which the updates are verified against
+	cache *store.MemoryStore
+
+	tufRepo  *tuf.Repo
+	expires  time.Time
+	lastUsed time.Time
+}
+
+// NewPool creates a pool of the notary repositories
+func NewPool(refreshInterval time.Duration) *Pool {
+	return &Pool{
+		refreshInterval: refreshInterval,
+		repos:           map[string]*pooledRepo{},
+		now:             time.Now,
+	}
+}
+
+// GetSignedMetadata gets the signed metadata of the image from the notary server.
+// The targets are found by the tag of the image, or by the digest if the image is referenced only by the digest.
+// The root of the repository should satisfy the trust pinning, when it is fetched for the first time
+func (p *Pool) GetSignedMetadata(img *image.Image, notaryURL string, trustPinning trustpinning.TrustPinConfig) (*trustRepo, error) {
+	if notaryURL == "" {
+		notaryURL = DefaultNotaryServer
+	}
+
+	r := p.getRepo(img, notaryURL, trustPinning)
+	tufRepo, err := r.getTUFRepo(img, p.now(), p.refreshInterval)
+	if err != nil {
+		return &trustRepo{}, err
+	}
+
+	allSignedTargets, err := client.NewReadOnly(tufRepo).GetAllTargetMetadataByName("")
+	if err != nil {
+		trustLog.Error(err, "failed to get all target metadata")
+		return &trustRepo{}, err
+	}
+
+	targets, err := filterTargets(allSignedTargets, img.Tag, digest.Digest(img.Digest))
+	if err != nil {
+		return &trustRepo{}, err
+	}
+
+	return newTrustRepo(r.gun, targets), nil
+}
+
+// getRepo gets the pooled repository of the image. Repositories which are not used for a while are dropped
+func (p *Pool) getRepo(img *image.Image, notaryURL string, trustPinning trustpinning.TrustPinConfig) *pooledRepo {
+	p.lock.Lock()
+	defer p.lock.Unlock()
+
+	// The cached root is trusted without the trust pinning, so the repository is not shared by different trust pinnings
+	now := p.now()
+	gun := data.GUN(img.GetImageNameWithHost())
+	key := fmt.Sprintf("%s/%s/%x/%x", notaryURL, gun, sha256.Sum256([]byte(img.BasicAuth+":"+img.IdentityToken)), sha256.Sum256([]byte(fmt.Sprintf("%v", trustPinning))))
+	if r, exist := p.repos[key]; exist {
+		r.lastUsed = now
+		return r
+	}
+
+	for k, r := range p.repos {
+		if now.Sub(r.lastUsed) > poolIdleTimeout {
+			delete(p.repos, k)
+		}
+	}
+
+	r := &pooledRepo{
+		gun:          gun,
+		notaryURL:    notaryURL,
+		trustPinning: trustPinning,
+		cache:        store.NewMemoryStore(nil),
+		lastUsed:     now,
+	}
+	p.repos[key] = r
+	return r
+}
+
+// getTUFRepo gets the TUF repository. It is updated from the notary server only if it is expired.
+// The TLS config of the requested image is used for the update, so that the updated CA bundles take effect
+func (r *pooledRepo) getTUFRepo(img *image.Image, now time.Time, refreshInterval time.Duration) (*tuf.Repo, error) {
+	r.lock.Lock()
+	defer r.lock.Unlock()
+
+	if r.tufRepo != nil && now.Before(r.expires) {
+		return r.tufRepo, nil
+	}
+
+	// Token is got for each update from the token cache, which fetches it again if it is expired
+	n := &notaryRepo{notaryServerURL: r.notaryURL, image: img}
+	token, err := n.getToken()
+	if err != nil {
+		return nil, err
+	}
+	remote, err := store.NewNotaryServerStore(r.notaryURL, r.gun, n.newRegistryTransport(token))
+	if err != nil {
+		return nil, err
+	}
+
+	// Only the timestamp is downloaded if the other metadata is not changed, as the checksums are compared with the cached ones
+	tufRepo, _, err := client.LoadTUFRepo(client.TUFLoadOptions{
+		GUN:           r.gun,
+		TrustPinning:  r.trustPinning,
+		CryptoService: cryptoservice.NewCryptoService(),
+		Cache:         r.cache,
+		RemoteStore:   remote,
+	})
+	if err != nil {
+		// Expired metadata is never served
+		r.tufRepo = nil
+		return nil, err
+	}
+
+	r.tufRepo = tufRepo
+	r.expires = expiresOf(tufRepo, now.Add(refreshInterval))
+	return tufRepo, nil
+}
+
+// expiresOf returns the earliest expiry of the TUF metadata of the repository, or the given time if it is earlier.
+// The targets are not valid if any of the roles is expired, including the root, the targets and the delegations
+func expiresOf(tufRepo *tuf.Repo, expires time.Time) time.Time {
+	earlier := func(signed data.SignedCommon) {
+		if signed.Expires.Before(expires) {
+			expires = signed.Expires
+		}
+	}
+	if tufRepo.Root != nil {
+		earlier(tufRepo.Root.Signed.SignedCommon)
+	}
+	if tufRepo.Timestamp != nil {
+		earlier(tufRepo.Timestamp.Signed.SignedCommon)
+	}
+	if tufRepo.Snapshot != nil {
+		earlier(tufRepo.Snapshot.Signed.SignedCommon)
+	}
+	for _, targets := range tufRepo.Targets {
+		if targets != nil {
+			earlier(targets.Signed.SignedCommon)
+		}
+	}
+	return expires
+}
@@ -0,0 +1,219 @@
+package trust
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+	"github.com/theupdateframework/notary/trustpinning"
+	"github.com/theupdateframework/notary/tuf"
+	"github.com/theupdateframework/notary/tuf/data"
+	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
+	notarytest "github.com/tmax-cloud/image-validating-webhook/pkg/notary/test"
+)
+
+type poolTestCase struct {
+	image string
+
+	expectedTags   []string
+	expectedErrMsg string
+}
+
+func TestPool_GetSignedMetadata(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	testDigest := strings.Repeat("1", 32)
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "signed-repo", "signed-tag", testDigest)
+	require.NoError(t, err)
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "signed-repo", "other-tag", testDigest)
+	require.NoError(t, err)
+
+	tc := map[string]poolTestCase{
+		"signedTag": {
+			image:        "test.io/signed-repo:signed-tag",
+			expectedTags: []string{"signed-tag"},
+		},
+		"digestOnly": {
+			image:        fmt.Sprintf("test.io/signed-repo@sha256:%x", testDigest),
+			expectedTags: []string{"other-tag", "signed-tag"},
+		},
+		"unsignedTag": {
+			image:          "test.io/signed-repo:unsigned-tag",
+			expectedErrMsg: "No valid trust data for unsigned-tag",
+		},
+		"unsignedDigest": {
+			image:          fmt.Sprintf("test.io/signed-repo@sha256:%x", strings.Repeat("2", 32)),
+			expectedErrMsg: fmt.Sprintf("No valid trust data for sha256:%x", strings.Repeat("2", 32)),
+		},
+		"unsignedRepo": {
+			image:          "test.io/unsigned-repo:unsigned-tag",
+			expectedErrMsg: "does not have trust data for",
+		},
+	}
+
+	pool := NewPool(DefaultRefreshInterval)
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			img, err := image.NewImage(c.image, "", testSrv.TLSConfig())
+			require.NoError(t, err)
+
+			repo, err := pool.GetSignedMetadata(img, testSrv.URL, trustpinning.TrustPinConfig{})
+			if c.expectedErrMsg != "" {
+				require.Error(t, err)
+				require.Contains(t, err.Error(), c.expectedErrMsg)
+				return
+			}
+			require.NoError(t, err)
+			require.Equal(t, "test.io/signed-repo", repo.Name)
+			var tags []string
+			for _, row := range repo.SignedTags {
+				tags = append(tags, row.SignedTag)
+			}
+			require.Equal(t, c.expectedTags, tags)
+		})
+	}
+}
+
+func TestPool_Refresh(t *testing.T) {
+	testSrv, err := notarytest.New(false)
+	require.NoError(t, err)
+	defer testSrv.Close()
+
+	const gun = "test.io/signed-repo"
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "signed-repo", "v1", strings.Repeat("1", 32))
+	require.NoError(t, err)
+
+	now := time.Now()
+	pool := NewPool(time.Minute)
+	pool.now = func() time.Time { return now }
+
+	getTag := func(tag string) error {
+		img, err := image.NewImage(fmt.Sprintf("%s:%s", gun, tag), "", testSrv.TLSConfig())
+		require.NoError(t, err)
+		_, err = pool.GetSignedMetadata(img, testSrv.URL, trustpinning.TrustPinConfig{})
+		return err
+	}
+
+	// requests counts the metadata requests of the pool, excluding the ones of signing images
+	roles := []data.RoleName{data.CanonicalRootRole, data.CanonicalTimestampRole, data.CanonicalSnapshotRole, data.CanonicalTargetsRole}
+	counts := map[data.RoleName]int{}
+	requests := func(f func()) map[data.RoleName]int {
+		for _, r := range roles {
+			counts[r] = testSrv.RequestCount(gun, r)
+		}
+		f()
+		result := map[data.RoleName]int{}
+		for _, r := range roles {
+			result[r] = testSrv.RequestCount(gun, r) - counts[r]
+		}
+		return result
+	}
+
+	// Metadata is downloaded once, and then reused across concurrent requests
+	require.Equal(t, map[data.RoleName]int{data.CanonicalRootRole: 1, data.CanonicalTimestampRole: 1, data.CanonicalSnapshotRole: 1, data.CanonicalTargetsRole: 1}, requests(func() {
+		wg := sync.WaitGroup{}
+		for i := 0; i < 10; i++ {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				require.NoError(t, getTag("v1"))
+			}()
+		}
+		wg.Wait()
+	}))
+
+	// Newly signed tag is not visible until the refresh interval passes
+	_, err = testSrv.SignImage(testSrv.URL, "test.io", "signed-repo", "v2", strings.Repeat("2", 32))
+	require.NoError(t, err)
+	require.Equal(t, map[data.RoleName]int{data.CanonicalRootRole: 0, data.CanonicalTimestampRole: 0, data.CanonicalSnapshotRole: 0, data.CanonicalTargetsRole: 0}, requests(func() {
+		require.Error(t, getTag("v2"))
+	}))
+
+	// Changed metadata is downloaded after the refresh interval
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, map[data.RoleName]int{data.CanonicalRootRole: 0, data.CanonicalTimestampRole: 1, data.CanonicalSnapshotRole: 1, data.CanonicalTargetsRole: 1}, requests(func() {
+		require.NoError(t, getTag("v2"))
+	}))
+
+	// Only the timestamp is downloaded again if nothing is changed
+	now = now.Add(2 * time.Minute)
+	require.Equal(t, map[data.RoleName]int{data.CanonicalRootRole: 0, data.CanonicalTimestampRole: 1, data.CanonicalSnapshotRole: 0, data.CanonicalTargetsRole: 0}, requests(func() {
+		require.NoError(t, getTag("v1"))
+	}))
+}
+
+type expiresOfTestCase struct {
+	tufRepo *tuf.Repo
+
+	expectedExpires time.Time
+}
+
+func TestExpiresOf(t *testing.T) {
+	now := time.Date(2022, 8, 1, 0, 0, 0, 0, time.UTC)
+	refresh := now.Add(time.Hour)
+	expired := now.Add(time.Minute)
+	signed := func(expires time.Time) data.SignedCommon {
+		return data.SignedCommon{Expires: expires}
+	}
+	testRepo := func() *tuf.Repo {
+		return &tuf.Repo{
+			Root:      &data.SignedRoot{Signed: data.Root{SignedCommon: signed(now.Add(24 * time.Hour))}},
+			Timestamp: &data.SignedTimestamp{Signed: data.Timestamp{SignedCommon: signed(now.Add(24 * time.Hour))}},
+			Snapshot:  &data.SignedSnapshot{Signed: data.Snapshot{SignedCommon: signed(now.Add(24 * time.Hour))}},
+			Targets: map[data.RoleName]*data.SignedTargets{
+				data.CanonicalTargetsRole: {Signed: data.Targets{SignedCommon: signed(now.Add(24 * time.Hour))}},
+				ReleasesRole:              {Signed: data.Targets{SignedCommon: signed(now.Add(24 * time.Hour))}},
+			},
+		}
+	}
+
+	tc := map[string]expiresOfTestCase{
+		"refresh": {
+			tufRepo:         testRepo(),
+			expectedExpires: refresh,
+		},
+		"root": {
+			tufRepo: func() *tuf.Repo {
+				r := testRepo()
+				r.Root.Signed.Expires = expired
+				return r
+			}(),
+			expectedExpires: expired,
+		},
+		"timestamp": {
+			tufRepo: func() *tuf.Repo {
+				r := testRepo()
+				r.Timestamp.Signed.Expires = expired
+				return r
+			}(),
+			expectedExpires: expired,
+		},
+		"targets": {
+			tufRepo: func() *tuf.Repo {
+				r := testRepo()
+				r.Targets[data.CanonicalTargetsRole].Signed.Expires = expired
+				return r
+			}(),
+			expectedExpires: expired,
+		},
+		"delegation": {
+			tufRepo: func() *tuf.Repo {
+				r := testRepo()
+				r.Targets[ReleasesRole].Signed.Expires = expired
+				return r
+			}(),
+			expectedExpires: expired,
+		},
+	}
+
+	for name, c := range tc {
+		t.Run(name, func(t *testing.T) {
+			require.Equal(t, c.expectedExpires, expiresOf(c.tufRepo, refresh))
+		})
+	}
+}
@@ -1,27 +1,21 @@
 package trust
 
 import (
-	"crypto/tls"
 	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"net"
 	"net/http"
 	"net/url"
-	"os"
 	"path"
 	"sort"
 	"strings"
 	"time"
 
-	regclient "github.com/docker/distribution/registry/client"
-	"github.com/docker/distribution/registry/client/auth/challenge"
 	"github.com/fvbommel/sortorder"
+	"github.com/opencontainers/go-digest"
 	"github.com/theupdateframework/notary"
 	"github.com/theupdateframework/notary/client"
-	"github.com/theupdateframework/notary/trustpinning"
 	"github.com/theupdateframework/notary/tuf/data"
-	"github.com/tmax-cloud/image-validating-webhook/internal/utils"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/auth"
 	"github.com/tmax-cloud/image-validating-webhook/pkg/image"
 	logf "sigs.k8s.io/controller-runtime/pkg/log"
@@ -52,56 +46,29 @@ type trustRepo struct {
 	SignedTags []trustTagRow
 }
 
-// ReadOnly can get sign data
-type ReadOnly interface {
-	GetSignedMetadata(string) (*trustRepo, error)
-	ClearDir() error
-}
-
-// trustPass key-value map to store passPhrase
-type trustPass map[string]string
-
-// addKeyPass add new passPhrase to trustPass
-func (p trustPass) addKeyPass(key, val string) {
-	p[key] = val
-}
-
+// notaryRepo is a notary server of the image, which the token of the TUF metadata requests is fetched for
 type notaryRepo struct {
-	notaryPath      string
 	notaryServerURL string
-	repo            client.Repository
 	token           *auth.Token
 	image           *image.Image
-	passPhrase      trustPass
+
+	// tokenRequest is the request of the bearer token, which is nil if the token is not issued by a token server
+	tokenRequest *auth.TokenRequest
 }
 
+// tokenCache caches the bearer tokens of the notary servers across the requests
+var tokenCache = auth.NewTokenCache()
+
 const (
 	// DefaultNotaryServer is url of docker hub's notary server
 	DefaultNotaryServer = "https://notary.docker.io"
 	releasedRoleName    = "Repo Admin"
 )
 
-// NewReadOnly returns new readonly object to get sign data
-func NewReadOnly(image *image.Image, notaryURL, path string) (ReadOnly, error) {
-	n := &notaryRepo{
-		notaryPath: path,
-		image:      image,
-	}
-
-	// Notary Server url
-	if notaryURL == "" {
-		n.notaryServerURL = DefaultNotaryServer
-	} else {
-		n.notaryServerURL = notaryURL
-	}
-
-	token, err := n.getToken()
-	if err != nil {
-		return nil, err
-	}
-
-	// Generate Transport
-	rt := &auth.RegistryTransport{
+// newRegistryTransport returns a transport of the notary client, which sets the token to the requests.
+// The token is refreshed once if it is rejected. The TLS certificates of the notary server are verified with the TLS config of the image
+func (n *notaryRepo) newRegistryTransport(token *auth.Token) *auth.RegistryTransport {
+	return &auth.RegistryTransport{
 		Base: &http.Transport{ // Base is DefaultTransport, added TLSClientConfig
 			Proxy: http.ProxyFromEnvironment,
 			DialContext: (&net.Dialer{
@@ -113,19 +80,11 @@ func NewReadOnly(image *image.Image, notaryURL, path string) (ReadOnly, error) {
 			IdleConnTimeout:       90 * time.Second,
 			TLSHandshakeTimeout:   10 * time.Second,
 			ExpectContinueTimeout: 1 * time.Second,
-			TLSClientConfig:       &tls.Config{InsecureSkipVerify: true},
+			TLSClientConfig:       n.image.TLSConfig,
 		},
-		Token: token,
-	}
-
-	// Initialize Notary repository
-	repo, err := client.NewFileCachedRepository(n.notaryPath, data.GUN(image.GetImageNameWithHost()), n.notaryServerURL, rt, n.passRetriever(), trustpinning.TrustPinConfig{})
-	if err != nil {
-		return nil, err
+		Token:   token,
+		Refresh: n.refreshToken,
 	}
-	n.repo = repo
-
-	return n, nil
 }
 
 // getToken returns token to get sign from notary server
@@ -183,107 +142,56 @@ func (n *notaryRepo) fetchToken() error {
 		return nil
 	}
 
-	challenges := challenge.ResponseChallenges(pingResp)
-	if len(challenges) < 1 {
-		return fmt.Errorf("header does not contain WWW-Authenticate")
+	// All the challenges are considered, as the registries may advertise basic auth before the bearer token
+	ch, err := auth.SelectChallenge(auth.ParseChallenges(pingResp))
+	if err != nil {
+		return err
 	}
-	realm, realmExist := challenges[0].Parameters["realm"]
-	service, serviceExist := challenges[0].Parameters["service"]
-	if !realmExist || !serviceExist {
-		return fmt.Errorf("there is no realm or service in parameters")
+	// The basic auth is already sent with the ping
+	if ch.IsScheme(auth.TokenTypeBasic) {
+		if n.image.BasicAuth == "" {
+			return fmt.Errorf("notary server %s requires basic auth", n.notaryServerURL)
+		}
+		return fmt.Errorf("notary server %s rejected the basic auth", n.notaryServerURL)
 	}
 
-	// Get Token
-	return n.setToken(service, realm)
+	// Get Token. Service may be omitted
+	return n.setToken(ch.Parameters["service"], ch.Parameters["realm"])
 }
 
+// setToken sets the bearer token of the token server. Only the pull scope is requested, as the webhook only reads the signatures.
+// Tokens are cached until they expire
 func (n *notaryRepo) setToken(service string, realm string) error {
-	img := n.image.GetImageNameWithHost()
-
-	param := map[string]string{
-		"service": service,
-		"scope":   fmt.Sprintf("repository:%s:pull,push", img),
+	n.tokenRequest = &auth.TokenRequest{
+		Realm:        realm,
+		Service:      service,
+		Scope:        auth.PullScope(n.image.GetImageNameWithHost()),
+		BasicAuth:    n.image.BasicAuth,
+		RefreshToken: n.image.IdentityToken,
 	}
-	tokenReq, err := http.NewRequest(http.MethodGet, realm, nil)
+	token, err := tokenCache.Get(&n.image.HTTPClient, *n.tokenRequest)
 	if err != nil {
 		return err
 	}
-	if n.image.BasicAuth != "" {
-		tokenReq.Header.Set("Authorization", fmt.Sprintf("Basic %s", n.image.BasicAuth))
-	}
-	tokenQ := tokenReq.URL.Query()
-	for k, v := range param {
-		tokenQ.Add(k, v)
-	}
-	tokenReq.URL.RawQuery = tokenQ.Encode()
-
-	tokenResp, err := n.image.HTTPClient.Do(tokenReq)
-	if err != nil {
-		return err
-	}
-	defer func() {
-		_ = tokenResp.Body.Close()
-	}()
-	if !regclient.SuccessStatus(tokenResp.StatusCode) {
-		err := regclient.HandleErrorResponse(tokenResp)
-		return err
-	}
-
-	decoder := json.NewDecoder(tokenResp.Body)
-	token := &auth.TokenResponse{}
-	if err := decoder.Decode(token); err != nil {
-		return err
-	}
-
-	n.token = &auth.Token{
-		Type:  "Bearer",
-		Value: token.Token,
-	}
+	n.token = token
 
 	return nil
 }
 
-func (n *notaryRepo) passRetriever() notary.PassRetriever {
-	return func(id, _ string, createNew bool, attempts int) (string, bool, error) {
-		if createNew {
-			n.passPhrase.addKeyPass(id, utils.RandomString(10))
-		}
-		phrase, ok := n.passPhrase[id]
-		if !ok {
-			return "", attempts > 1, fmt.Errorf("no pass phrase is found")
-		}
-		return phrase, attempts > 1, nil
+// refreshToken fetches a new token, when the token is rejected by the notary server
+func (n *notaryRepo) refreshToken(rejected *auth.Token) (*auth.Token, error) {
+	if n.tokenRequest == nil {
+		return nil, fmt.Errorf("token is not issued by a token server")
 	}
+	trustLog.Info("Token is rejected. Refreshing token...")
+	tokenCache.Invalidate(*n.tokenRequest, rejected)
+	return tokenCache.Get(&n.image.HTTPClient, *n.tokenRequest)
 }
 
-// ClearDir remove temporary directory
-func (n *notaryRepo) ClearDir() error {
-	return os.RemoveAll(n.notaryPath)
-}
-
-// GetSignedMetadata returns trust repository
-func (n *notaryRepo) GetSignedMetadata(tag string) (*trustRepo, error) {
-	allSignedTargets, err := n.repo.GetAllTargetMetadataByName(tag)
-	if err != nil {
-		trustLog.Error(err, "failed to get all target metadata")
-		return &trustRepo{}, err
-	}
-
+// newTrustRepo converts the signed targets to the signed metadata of the released targets
+func newTrustRepo(gun data.GUN, allSignedTargets []client.TargetSignedStruct) *trustRepo {
 	signatureRows := matchReleasedSignatures(allSignedTargets)
 
-	// get the administrative roles
-	_, err = n.repo.ListRoles()
-	if err != nil {
-		return &trustRepo{}, fmt.Errorf("no signers for %s", n.notaryServerURL)
-	}
-
-	// get delegation roles with the canonical key IDs
-	_, err = n.repo.GetDelegationRoles()
-	if err != nil {
-		errMsg := fmt.Sprintf("no delegation roles found, or error fetching them for %s", n.notaryServerURL)
-		trustLog.Error(err, errMsg)
-	}
-
 	// process the signatures to include repo admin if signed by the base targets role
 	for idx, sig := range signatureRows {
 		if len(sig.Signers) == 0 {
@@ -292,9 +200,39 @@ func (n *notaryRepo) GetSignedMetadata(tag string) (*trustRepo, error) {
 	}
 
 	return &trustRepo{
-		Name:       n.repo.GetGUN().String(),
+		Name:       gun.String(),
 		SignedTags: signatureRows,
-	}, nil
+	}
+}
+
+// filterTargets filters the signed targets of the tag, or of the digest if the tag is empty.
+// Targets signed by the delegation roles which are not allowed to sign the tag are dropped
+func filterTargets(allSignedTargets []client.TargetSignedStruct, tag string, dgst digest.Digest) ([]client.TargetSignedStruct, error) {
+	if tag == "" && dgst.Algorithm() != digest.SHA256 {
+		return nil, fmt.Errorf("digest algorithm %s is not supported", dgst.Algorithm())
+	}
+
+	var targets []client.TargetSignedStruct
+	for _, tgt := range allSignedTargets {
+		if !tgt.Role.CheckPaths(tgt.Target.Name) {
+			continue
+		}
+		if tag != "" && tgt.Target.Name != tag {
+			continue
+		}
+		if tag == "" && hex.EncodeToString(tgt.Target.Hashes[notary.SHA256]) != dgst.Encoded() {
+			continue
+		}
+		targets = append(targets, tgt)
+	}
+
+	if len(targets) == 0 {
+		if tag == "" {
+			return nil, client.ErrNoSuchTarget(dgst.String())
+		}
+		return nil, client.ErrNoSuchTarget(tag)
+	}
+	return targets, nil
 }
 
 func matchReleasedSignatures(allTargets []client.TargetSignedStruct) []trustTagRow {
@@ -322,6 +260,9 @@ func matchReleasedSignatures(allTargets []client.TargetSignedStruct) []trustTagR
 		signatureRows = append(signatureRows, trustTagRow{targetKey, signers})
 	}
 	sort.Slice(signatureRows, func(i, j int) bool {
+		if signatureRows[i].SignedTag == signatureRows[j].SignedTag {
+			return signatureRows[i].Digest < signatureRows[j].Digest
+		}
 		return sortorder.NaturalLess(signatureRows[i].SignedTag, signatureRows[j].SignedTag)
 	})
 	return signatureRows
@@ -0,0 +1,48 @@
+package v1
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func init() {
+	SchemeBuilder.Register(&CosignKeySet{}, &CosignKeySetList{})
+}
+
+// CosignKey is a named cosign public key with its validity period
+type CosignKey struct {
+	// Name is an ID of the key, which is reported in the admission result
+	Name string `json:"name"`
+	// PublicKey is a PEM encoded cosign public key
+	PublicKey string `json:"publicKey"`
+	// NotBefore is the time from which signatures made with the key are valid
+	NotBefore *metav1.Time `json:"notBefore,omitempty"`
+	// NotAfter is the time after which signatures made with the key are invalid
+	NotAfter *metav1.Time `json:"notAfter,omitempty"`
+	// Revoked is a flag to reject all signatures made with the key
+	Revoked bool `json:"revoked,omitempty"`
+}
+
+// CosignKeySetSpec is a spec of CosignKeySet
+type CosignKeySetSpec struct {
+	// Keys are the list of cosign public keys
+	Keys []CosignKey `json:"keys"`
+}
+
+// +kubebuilder:object:root=true
+
+// CosignKeySet contains the list of cosign public keys, which are rotated by their validity periods
+// +kubebuilder:resource:path=cosignkeysets,scope=Namespaced,shortName=cks
+type CosignKeySet struct {
+	metav1.TypeMeta   `json:",inline"`
+	metav1.ObjectMeta `json:"metadata,omitempty"`
+	Spec              CosignKeySetSpec `json:"spec"`
+}
+
+// +kubebuilder:object:root=true
+
+// CosignKeySetList contains the list of CosignKeySet resources
+type CosignKeySetList struct {
+	metav1.TypeMeta `json:",inline"`
+	metav1.ListMeta `json:"metadata,omitempty"`
+	Items           []CosignKeySet `json:"items"`
+}
@@ -15,12 +15,95 @@ type RegistrySpec struct {
 	Registry string `json:"registry"`
 	// Notary is URL of registry's notary server
 	Notary string `json:"notary,omitempty"`
+	// CABundle is a source of PEM encoded CA certificates, which verify the TLS certificates of the registry and the notary server in addition to the system ones
+	CABundle *CABundleSource `json:"caBundle,omitempty"`
+	// InsecureSkipTLSVerify is a flag to skip verifying the TLS certificates of the registry and the notary server. It is vulnerable to man-in-the-middle attacks
+	InsecureSkipTLSVerify bool `json:"insecureSkipTLSVerify,omitempty"`
+	// NotaryTrustPins pin the roots of the notary repositories per GUN prefix. The first root of a repository, which no pin matches, is trusted
+	NotaryTrustPins []NotaryTrustPin `json:"notaryTrustPins,omitempty"`
 	// SignCheck is a flag to decide to check sign data or not. If it is set false, sign check is skipped
 	SignCheck bool `json:"signCheck"`
 	// CosignKeyRef is key reference like secret resource or else that saved cosign key
 	CosignKeyRef string `json:"cosignKeyRef,omitempty"`
+	// CosignKey is a source of cosign public keys, which is either a secret, a config map or inline PEM
+	CosignKey *CosignKeySource `json:"cosignKey,omitempty"`
+	// CosignKeySetRef is a reference of the CosignKeySet which has cosign keys with their validity periods, formatted as k8s://<namespace>/<name>
+	CosignKeySetRef string `json:"cosignKeySetRef,omitempty"`
 	// Signers are the list of desired signers of images to be allowed
 	Signer []string `json:"signer,omitempty"`
+	// SignerAnnotationKey is a key of the cosign signature annotation which has the signer. Default is signer
+	SignerAnnotationKey string `json:"signerAnnotationKey,omitempty"`
+	// SignerMatchType is a type of matching the signers with Signer, which is one of exact, glob and regex. Default is exact
+	// +kubebuilder:validation:Enum=exact;glob;regex
+	SignerMatchType string `json:"signerMatchType,omitempty"`
+	// SignerThreshold is the number of distinct signers matching Signer, which should sign images (e.g., 2 for 2 of 3 signers). Default is 1
+	// +kubebuilder:validation:Minimum=1
+	SignerThreshold int `json:"signerThreshold,omitempty"`
+	// DisallowRepoAdmin is a flag to refuse notary signatures of the repository administrator (targets role), which are allowed regardless of Signer by default
+	DisallowRepoAdmin bool `json:"disallowRepoAdmin,omitempty"`
+	// Attestations are the list of cosign attestations required for images to be allowed
+	Attestations []AttestationSpec `json:"attestations,omitempty"`
+	// TransparencyLog is a rekor transparency log to verify the bundles of cosign signatures offline
+	TransparencyLog *TransparencyLogSpec `json:"transparencyLog,omitempty"`
+}
+
+// NotaryTrustPin pins the roots of the notary repositories whose GUNs start with the prefix.
+// Either CertIDs or CABundle should be set. If both are set, CertIDs are used
+type NotaryTrustPin struct {
+	// GUNPrefix is a prefix of the GUNs (e.g., registry.io/project/), which the pin is applied to
+	GUNPrefix string `json:"gunPrefix"`
+	// CertIDs are the IDs of the root certificates which are trusted
+	CertIDs []string `json:"certIDs,omitempty"`
+	// CABundle is a source of PEM encoded CA certificates, which should issue the root certificates
+	CABundle *CABundleSource `json:"caBundle,omitempty"`
+}
+
+// CosignKeySource is a source of PEM encoded cosign public keys. Only one of the fields should be set
+type CosignKeySource struct {
+	// SecretRef is a reference of the secret which has cosign public keys
+	SecretRef *KeyObjectReference `json:"secretRef,omitempty"`
+	// ConfigMapRef is a reference of the config map which has cosign public keys
+	ConfigMapRef *KeyObjectReference `json:"configMapRef,omitempty"`
+	// PublicKey is inline PEM encoded cosign public keys
+	PublicKey string `json:"publicKey,omitempty"`
+}
+
+// CABundleSource is a source of PEM encoded CA certificates. Only one of the fields should be set
+type CABundleSource struct {
+	// SecretRef is a reference of the secret which has the CA certificates
+	SecretRef *KeyObjectReference `json:"secretRef,omitempty"`
+	// ConfigMapRef is a reference of the config map which has the CA certificates
+	ConfigMapRef *KeyObjectReference `json:"configMapRef,omitempty"`
+}
+
+// KeyObjectReference is a reference of the data of a secret or a config map
+type KeyObjectReference struct {
+	// Namespace is a namespace of the object
+	Namespace string `json:"namespace"`
+	// Name is a name of the object
+	Name string `json:"name"`
+	// Key is a key of the data which has the PEM encoded keys or certificates. cosign.pub is used for cosign keys and ca.crt is used for CA bundles if it is empty
+	Key string `json:"key,omitempty"`
+}
+
+// TransparencyLogSpec is a spec of a rekor transparency log
+type TransparencyLogSpec struct {
+	// PublicKeyRef is a reference of the secret which has rekor public keys in rekor.pub, formatted as k8s://<namespace>/<secret>
+	PublicKeyRef string `json:"publicKeyRef"`
+	// RequireBundle is a flag to reject signatures which do not have a rekor bundle
+	RequireBundle bool `json:"requireBundle,omitempty"`
+}
+
+// AttestationSpec is a spec of an attestation required for images
+type AttestationSpec struct {
+	// PredicateType is a type of the in-toto predicate. It is either a predicate URI or one of
+	// custom, slsaprovenance, spdx, cyclonedx, link and vuln
+	PredicateType string `json:"predicateType"`
+	// BuilderIDs are the list of allowed builder IDs of a slsaprovenance predicate
+	BuilderIDs []string `json:"builderIDs,omitempty"`
+	// MaxAge is the maximum age of the attestation (e.g., 168h for a vulnerability scan newer than 7 days).
+	// The age is from the time in the predicate, or from the signing time of the attestation if the predicate has none
+	MaxAge *metav1.Duration `json:"maxAge,omitempty"`
 }
 
 // ClusterRegistrySecurityPolicySpec is a spec of ClusterRegistrySecurityPolicy
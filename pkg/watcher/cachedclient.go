@@ -101,3 +101,15 @@ func (g *cachedClient) List(sel Selector, out runtime.Object) error {
 type Selector struct {
 	Namespace string
 }
+
+// NewStaticCachedClient creates a cache reader client of the given objects, which are not watched.
+// It is for reading the objects from the files instead of the cluster
+func NewStaticCachedClient(objs ...runtime.Object) (CachedClient, error) {
+	indexer := cache.NewIndexer(cache.MetaNamespaceKeyFunc, cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	for _, obj := range objs {
+		if err := indexer.Add(obj); err != nil {
+			return nil, err
+		}
+	}
+	return &cachedClient{indexer: indexer}, nil
+}
@@ -2,6 +2,7 @@ package watcher
 
 import (
 	"fmt"
+	"sync"
 	"time"
 
 	"k8s.io/apimachinery/pkg/fields"
@@ -26,6 +27,7 @@ type Handler interface {
 // Watcher is an interface of k8s object watcher
 type Watcher interface {
 	Start(chan struct{})
+	Stop()
 	SetHandler(Handler)
 
 	getIndexer() cache.Indexer
@@ -36,7 +38,8 @@ type watcher struct {
 	indexer  cache.Indexer
 	informer cache.Controller
 
-	stopCh chan struct{}
+	stopCh   chan struct{}
+	stopOnce sync.Once
 
 	handler Handler
 }
@@ -83,9 +86,10 @@ func (w *watcher) Start(waitCh chan struct{}) {
 	// Start informer sync
 	go w.informer.Run(w.stopCh)
 
-	// Wait until cache is synced
+	// Wait until cache is synced. It fails only if the watcher is stopped
 	if !cache.WaitForCacheSync(w.stopCh, w.informer.HasSynced) {
-		panic(fmt.Errorf("timed out waiting for caches to sync"))
+		watcherLog.Info("watcher is stopped before its cache is synced")
+		return
 	}
 
 	waitCh <- struct{}{}
@@ -94,6 +98,14 @@ func (w *watcher) Start(waitCh chan struct{}) {
 	wait.Until(w.watch, time.Second, w.stopCh)
 }
 
+// Stop stops the informer and the watcher func. It is safe to be called more than once
+func (w *watcher) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+		w.queue.ShutDown()
+	})
+}
+
 func (w *watcher) SetHandler(handler Handler) {
 	w.handler = handler
 }
@@ -14,6 +14,7 @@ import (
 	restfake "k8s.io/client-go/rest/fake"
 	"net/http"
 	"testing"
+	"time"
 )
 
 func TestNew(t *testing.T) {
@@ -167,6 +168,29 @@ func TestWatcher_Start(t *testing.T) {
 	// TODO
 }
 
+func TestWatcher_Stop(t *testing.T) {
+	// The objects are never listed, so the cache is never synced
+	cli := testWatcherRestClient()
+	wi := New("", "", &corev1.Pod{}, cli, fields.Everything())
+
+	waitCh := make(chan struct{}, 1)
+	done := make(chan struct{})
+	go func() {
+		wi.Start(waitCh)
+		close(done)
+	}()
+
+	wi.Stop()
+	wi.Stop()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher is not stopped")
+	}
+	require.Len(t, waitCh, 0, "synced")
+}
+
 func testWatcherRestClient() *restfake.RESTClient {
 	return &restfake.RESTClient{
 		GroupVersion:         whv1.GroupVersion,